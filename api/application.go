@@ -1,6 +1,9 @@
 package api
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 // Application represents your application.
 type Application interface {
@@ -27,4 +30,59 @@ type Application interface {
 	//
 	// https://docs.newrelic.com/docs/insights/new-relic-insights/adding-querying-data/inserting-custom-events-new-relic-apm-agents
 	RecordCustomEvent(eventType string, params map[string]interface{}) error
+
+	// NoticeError records an error which did not occur within a
+	// Transaction, such as in a background worker.  The error is recorded
+	// with the transaction name "OtherTransaction/Go/unknown" and the
+	// attributes provided, which are subject to the same restrictions as
+	// Transaction.AddAttribute.  The error may implement the interfaces in
+	// errors.go.  Config.ErrorCollector applies to these errors as it does
+	// to those noticed by transactions.
+	NoticeError(err error, attrs map[string]interface{}) error
+
+	// RecordCustomMetric records a value for the custom metric with the
+	// given name.  Metrics with the same name are aggregated:  The count,
+	// total, minimum, maximum, and sum of squares of the values recorded
+	// during each harvest are sent.  The name is prefixed with "Custom/" and
+	// must contain fewer than 256 bytes including the prefix.  Custom
+	// metrics are subject to the metric name rules sent by New Relic, and
+	// may be dropped if too many unique metrics are recorded.
+	RecordCustomMetric(name string, value float64) error
+
+	// IncrementCustomMetric adds to the count of the custom metric with the
+	// given name.  It is subject to the same restrictions as
+	// RecordCustomMetric.
+	IncrementCustomMetric(name string, count int) error
+
+	// RecordCustomTiming records a duration for the custom metric with the
+	// given name.  It is subject to the same restrictions as
+	// RecordCustomMetric.
+	RecordCustomTiming(name string, duration time.Duration) error
+
+	// WaitForConnection blocks until the Application is connected to New
+	// Relic's servers, the connection fails, or the timeout has elapsed.
+	// Transactions and events recorded before the Application connects
+	// are not sent unless Config.Spool is configured.  WaitForConnection
+	// is useful for short-lived programs and integration tests that need
+	// to know data will be accepted.
+	//
+	// nil is returned once the Application is connected.  If the
+	// connection attempt failed permanently, for example due to an invalid
+	// license, the collector error is returned.  An error is also returned
+	// if Shutdown is called before the Application connects.  If
+	// Config.Enabled is false, nil is returned immediately.
+	WaitForConnection(timeout time.Duration) error
+
+	// Shutdown flushes data to New Relic's servers and stops all
+	// goroutines spawned by the Application.  The current harvest is sent
+	// immediately rather than waiting for the end of the harvest period.
+	// Shutdown blocks until the data has been sent or the timeout has
+	// elapsed.  Use this method before the process exits to avoid losing
+	// data, particularly in short-lived programs.
+	//
+	// After Shutdown is called, StartTransaction returns a Transaction
+	// that does not record data and RecordCustomEvent has no effect.
+	// Subsequent calls to Shutdown will wait for the original shutdown to
+	// complete.
+	Shutdown(timeout time.Duration)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // Config contains Application and Transaction behavior settings.
@@ -80,10 +81,99 @@ type Config struct {
 		// greater than or equal to 400, with the exception of 404, are
 		// turned into errors.
 		IgnoreStatusCodes []int
+		// IgnoreClasses lists the error classes which are not recorded.
+		// The class of an error is its type, for example
+		// "*errors.errorString", and the class of a response code
+		// error is the response code, for example "500".
+		IgnoreClasses []string
+		// IgnoreMessages lists, for each error class, the error
+		// messages which are not recorded.
+		IgnoreMessages map[string][]string
+		// ExpectedClasses lists the error classes which are part of
+		// normal operation.  Expected errors are recorded but do not
+		// affect the error rate or apdex.
+		ExpectedClasses []string
+		// ExpectedStatusCodes lists the http response codes which are
+		// turned into expected errors.
+		ExpectedStatusCodes []int
 		// Attributes controls the attributes included with errors.
 		Attributes AttributeDestinationConfig
 	}
 
+	// TransactionTracer controls the capture of transaction traces.
+	TransactionTracer struct {
+		// Enabled controls whether transaction traces are captured.
+		Enabled bool
+		// Threshold controls whether a transaction trace will be
+		// considered for capture.  Of the traces exceeding the
+		// threshold, the slowest trace every minute is captured.
+		Threshold struct {
+			// If IsApdexFailing is true then the trace threshold is
+			// four times the apdex threshold.
+			IsApdexFailing bool
+			// If IsApdexFailing is false then this field is the
+			// threshold, otherwise it is ignored.
+			Duration time.Duration
+		}
+		// SegmentThreshold is the threshold at which segments will be
+		// added to the trace.  Lowering this setting may increase
+		// overhead.
+		SegmentThreshold time.Duration
+		// MaxSegments limits the number of segments recorded in each
+		// transaction trace.
+		MaxSegments int
+		// Attributes controls the attributes included with transaction
+		// traces.
+		Attributes AttributeDestinationConfig
+	}
+
+	// DatastoreTracer controls the recording of datastore queries.
+	DatastoreTracer struct {
+		// SlowQuery controls the capture of slow query traces.  Slow
+		// query traces are only captured for segments with a Query.
+		SlowQuery struct {
+			Enabled bool
+			// Threshold is the datastore segment duration at
+			// which a slow query trace is captured.
+			Threshold time.Duration
+		}
+	}
+
+	// CrossApplicationTracer controls cross application tracing: the
+	// headers added to external requests made with
+	// Transaction.PrepareRequest and Transaction.EndRequest and to the
+	// responses of web transactions.
+	CrossApplicationTracer struct {
+		Enabled bool
+	}
+
+	// DistributedTracer controls W3C trace context distributed tracing.
+	// When enabled, transactions continue the trace of incoming requests
+	// with a traceparent header, Transaction.PrepareRequest adds the
+	// traceparent and tracestate headers to external requests, and cross
+	// application tracing is disabled.
+	DistributedTracer struct {
+		Enabled bool
+	}
+
+	// SpanEvents controls the capture of span events, which represent the
+	// segments of a distributed trace.  Span events are only captured when
+	// distributed tracing is enabled.
+	SpanEvents struct {
+		Enabled bool
+	}
+
+	// BrowserMonitoring controls browser monitoring: the JavaScript
+	// returned by Transaction.BrowserTimingHeader and
+	// Transaction.BrowserTimingFooter.
+	BrowserMonitoring struct {
+		Enabled bool
+		// Attributes controls the attributes included in the browser
+		// timing footer.  These attributes are visible in the page
+		// source and are therefore disabled by default.
+		Attributes AttributeDestinationConfig
+	}
+
 	// HostDisplayName gives this server a recognizable name in the New
 	// Relic UI.  This is an optional setting.
 	HostDisplayName string
@@ -117,6 +207,90 @@ type Config struct {
 		// Enabled controls whether runtime statistics are captured.
 		Enabled bool
 	}
+
+	// EventHarvestPeriod controls how often transaction, custom, and error
+	// events are sent to New Relic.  Metrics, traces, and span events are
+	// sent once a minute.  Sending events more often than once a minute
+	// reduces the number of events discarded by busy applications.  If
+	// non-zero, it must be between one second and one minute.  If zero or
+	// one minute, events are sent with the metrics.
+	EventHarvestPeriod time.Duration
+
+	// HarvestLimits controls the amount of data recorded during each
+	// harvest.  Raising these limits allows more data to be sent to New
+	// Relic at the cost of memory.  If a limit is zero, its default is
+	// used.
+	HarvestLimits struct {
+		// MaxTxnEvents limits the transaction events recorded each
+		// minute.  The limit is split between the event harvests
+		// occurring each minute according to EventHarvestPeriod.
+		MaxTxnEvents int
+		// MaxCustomEvents limits the custom events recorded each
+		// minute, and is split like MaxTxnEvents.
+		MaxCustomEvents int
+		// MaxErrorEvents limits the error events recorded each minute,
+		// and is split like MaxTxnEvents.
+		MaxErrorEvents int
+		// MaxSpanEvents limits the span events recorded each harvest.
+		MaxSpanEvents int
+		// MaxErrors limits the traced errors recorded each harvest.
+		MaxErrors int
+		// MaxTxnErrors limits the errors recorded by each transaction.
+		MaxTxnErrors int
+		// MaxMetrics limits the unique metrics recorded each harvest.
+		// Once it is reached, only the metrics required by New Relic
+		// are recorded.
+		MaxMetrics int
+	}
+
+	// Spool controls the storage on disk of data which could not be sent
+	// because New Relic was unreachable, including data recorded while
+	// the application is not connected.  Spooled data is sent once the
+	// application is able to reach New Relic again, including by a later
+	// process using the same directory.
+	Spool struct {
+		// Dir is the directory in which data is stored.  The spool is
+		// disabled if Dir is empty.  Data is stored in a subdirectory
+		// specific to the application name and license, so that
+		// applications may share the directory:  Data spooled by an
+		// application is only sent by an application with the same
+		// name and license.
+		Dir string
+		// MaxBytes limits the size of each application's spool.  The
+		// oldest data is discarded first when it is reached.
+		MaxBytes int64
+		// MaxAge is the age at which spooled data is discarded rather
+		// than sent.
+		MaxAge time.Duration
+	}
+
+	// MaxPayloadBytes limits the uncompressed size of the event and
+	// metric data sent in each request to New Relic.  Larger event and
+	// metric payloads are split and sent in multiple requests.  Other
+	// payloads, such as traces, are not limited.  Payloads rejected by
+	// New Relic as too large are split, or discarded if they cannot be
+	// split.  If zero, the size is not limited.
+	MaxPayloadBytes int
+
+	// Compression controls the compression of data sent to New Relic.
+	Compression CompressionConfig
+}
+
+// Compression formats of CompressionConfig.
+const (
+	CompressionDeflate = "deflate"
+	CompressionGzip    = "gzip"
+)
+
+// CompressionConfig controls the compression of data sent to New Relic.
+type CompressionConfig struct {
+	// Format is CompressionDeflate or CompressionGzip.  If empty, deflate
+	// is used.
+	Format string
+	// Level is the compression level, from 1 (fastest) to 9 (smallest).
+	// CPU-constrained hosts may choose a lower level.  If zero, the
+	// default level of the compress/flate package is used.
+	Level int
 }
 
 // AttributeDestinationConfig controls the attributes included with errors and
@@ -146,10 +320,35 @@ func NewConfig(appname, license string) Config {
 		http.StatusNotFound, // 404
 	}
 	c.ErrorCollector.Attributes.Enabled = true
+	c.TransactionTracer.Enabled = true
+	c.TransactionTracer.Threshold.IsApdexFailing = true
+	c.TransactionTracer.Threshold.Duration = 500 * time.Millisecond
+	c.TransactionTracer.SegmentThreshold = 2 * time.Millisecond
+	c.TransactionTracer.MaxSegments = 2000
+	c.TransactionTracer.Attributes.Enabled = true
+	c.DatastoreTracer.SlowQuery.Enabled = true
+	c.DatastoreTracer.SlowQuery.Threshold = 10 * time.Millisecond
+	c.CrossApplicationTracer.Enabled = true
+	c.DistributedTracer.Enabled = false
+	c.SpanEvents.Enabled = true
+	c.BrowserMonitoring.Enabled = true
+	c.BrowserMonitoring.Attributes.Enabled = false
 	c.Utilization.DetectAWS = true
 	c.Utilization.DetectDocker = true
 	c.Attributes.Enabled = true
 	c.RuntimeSampler.Enabled = true
+	c.EventHarvestPeriod = 5 * time.Second
+	c.HarvestLimits.MaxTxnEvents = 10 * 1000
+	c.HarvestLimits.MaxCustomEvents = 10 * 1000
+	c.HarvestLimits.MaxErrorEvents = 100
+	c.HarvestLimits.MaxSpanEvents = 1000
+	c.HarvestLimits.MaxErrors = 20
+	c.HarvestLimits.MaxTxnErrors = 5
+	c.HarvestLimits.MaxMetrics = 2 * 1000
+	c.Spool.MaxBytes = 10 * 1024 * 1024
+	c.Spool.MaxAge = time.Hour
+	c.MaxPayloadBytes = 1000 * 1000
+	c.Compression.Format = CompressionDeflate
 
 	return c
 }
@@ -157,14 +356,23 @@ func NewConfig(appname, license string) Config {
 const (
 	licenseLength = 40
 	appNameLimit  = 3
+
+	minEventHarvestPeriod = time.Second
+	maxEventHarvestPeriod = time.Minute
 )
 
 // The following errors will be returned if your Config fails to validate.
 var (
-	ErrLicenseLen      = fmt.Errorf("license length is not %d", licenseLength)
-	ErrHighSecurityTLS = errors.New("high security requires TLS")
-	ErrAppNameMissing  = errors.New("AppName required")
-	ErrAppNameLimit    = fmt.Errorf("max of %d rollup application names", appNameLimit)
+	ErrLicenseLen         = fmt.Errorf("license length is not %d", licenseLength)
+	ErrHighSecurityTLS    = errors.New("high security requires TLS")
+	ErrAppNameMissing     = errors.New("AppName required")
+	ErrAppNameLimit       = fmt.Errorf("max of %d rollup application names", appNameLimit)
+	ErrHarvestLimits      = errors.New("harvest limits must not be negative")
+	ErrEventHarvestPeriod = fmt.Errorf("event harvest period must be between %v and %v",
+		minEventHarvestPeriod, maxEventHarvestPeriod)
+	ErrSpool           = errors.New("spool MaxBytes and MaxAge must be positive")
+	ErrMaxPayloadBytes = errors.New("MaxPayloadBytes must not be negative")
+	ErrCompression     = errors.New("compression format must be deflate or gzip and level must be between 0 and 9")
 )
 
 // Validate checks the config for improper fields.  If the config is invalid,
@@ -189,5 +397,25 @@ func (c Config) Validate() error {
 	if strings.Count(c.AppName, ";") >= appNameLimit {
 		return ErrAppNameLimit
 	}
+	l := c.HarvestLimits
+	if l.MaxTxnEvents < 0 || l.MaxCustomEvents < 0 || l.MaxErrorEvents < 0 ||
+		l.MaxSpanEvents < 0 || l.MaxErrors < 0 || l.MaxTxnErrors < 0 ||
+		l.MaxMetrics < 0 {
+		return ErrHarvestLimits
+	}
+	if 0 != c.EventHarvestPeriod &&
+		(c.EventHarvestPeriod < minEventHarvestPeriod || c.EventHarvestPeriod > maxEventHarvestPeriod) {
+		return ErrEventHarvestPeriod
+	}
+	if "" != c.Spool.Dir && (c.Spool.MaxBytes <= 0 || c.Spool.MaxAge <= 0) {
+		return ErrSpool
+	}
+	if c.MaxPayloadBytes < 0 {
+		return ErrMaxPayloadBytes
+	}
+	if f := c.Compression.Format; ("" != f && CompressionDeflate != f && CompressionGzip != f) ||
+		c.Compression.Level < 0 || c.Compression.Level > 9 {
+		return ErrCompression
+	}
 	return nil
 }
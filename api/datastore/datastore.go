@@ -5,10 +5,16 @@ package datastore
 type Segment struct {
 	// Product is the datastore type.  See the constants below.
 	Product Product
-	// Collection is the table or group.
+	// Collection is the table or group.  If empty, it is parsed from
+	// the Query when possible.
 	Collection string
-	// Operation is the relevant action, e.g. "SELECT" or "GET".
+	// Operation is the relevant action, e.g. "SELECT" or "GET".  If
+	// empty, it is parsed from the Query when possible.
 	Operation string
+	// Query is the raw query text.  This field is optional.  Literal
+	// values are removed from the query before it is recorded, using
+	// quoting rules appropriate to the Product.
+	Query string
 }
 
 // Product encourages consistent metrics across New Relic agents.  You may
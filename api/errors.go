@@ -0,0 +1,29 @@
+package api
+
+// The following interfaces may be implemented by errors given to
+// Transaction.NoticeError to provide more information about the error.  If
+// the error has an Unwrap method returning the error that caused it, the
+// chain of causes is searched for these interfaces as well.
+
+// ErrorClasser is implemented by errors which provide their own class.  By
+// default, the class of an error is the type of its root cause.
+type ErrorClasser interface {
+	ErrorClass() string
+}
+
+// ErrorAttributer is implemented by errors which provide attributes to be
+// recorded with the error.  The attributes are subject to the same validation
+// and configuration as attributes added with Transaction.AddAttribute, and
+// take precedence over them.  The attributes of an error take precedence over
+// those of its causes.
+type ErrorAttributer interface {
+	ErrorAttributes() map[string]interface{}
+}
+
+// StackTracer is implemented by errors which record the stack where they
+// were created, in the format returned by runtime.Callers.  By default, the
+// stack of the call to NoticeError is recorded.  The stack of the deepest
+// cause which provides one is used.
+type StackTracer interface {
+	StackTrace() []uintptr
+}
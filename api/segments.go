@@ -7,7 +7,8 @@ import (
 )
 
 // SegmentTracer times blocks of code.  It is embedded into Transaction.
-// It must be used in a single goroutine.
+// It must be used in a single goroutine.  Use Transaction.NewGoroutine to time
+// segments in other goroutines.
 type SegmentTracer interface {
 	// StartSegment begins timing a segment and returns an identification
 	// token.  Pass this token to an end method to finish timing the
@@ -1,9 +1,13 @@
 package api
 
-import "net/http"
+import (
+	"html/template"
+	"net/http"
+)
 
 // Transaction represents a request or a background task.
-// Each Transaction should only be used in a single goroutine.
+// Each Transaction should only be used in a single goroutine.  Use
+// NewGoroutine to time segments in other goroutines.
 type Transaction interface {
 	// If StartTransaction is called with a non-nil http.ResponseWriter then
 	// the Transaction may be used in its place.  This allows
@@ -23,7 +27,8 @@ type Transaction interface {
 
 	// NoticeError records an error.  The first five errors per transaction
 	// are recorded (this behavior is subject to potential change in the
-	// future).
+	// future).  The error may implement the interfaces in errors.go to
+	// provide its class, attributes, and stack trace.
 	NoticeError(err error) error
 
 	// AddAttribute adds a key value pair to the current transaction.  This
@@ -36,8 +41,43 @@ type Transaction interface {
 	// https://docs.newrelic.com/docs/agents/manage-apm-agents/agent-metrics/collect-custom-attributes
 	AddAttribute(key string, value interface{}) error
 
+	// BrowserTimingHeader returns the JavaScript loader of browser
+	// monitoring, which should be placed as early as possible within the
+	// <head> of an HTML page.  An empty string is returned if browser
+	// monitoring is disabled or the application has not yet connected.
+	//
+	// For more information, see:
+	// https://docs.newrelic.com/docs/browser/new-relic-browser/getting-started/introduction-new-relic-browser
+	BrowserTimingHeader() template.HTML
+
+	// BrowserTimingFooter returns the JavaScript configuration of browser
+	// monitoring, which should be placed at the end of the <body> of an
+	// HTML page.  An empty string is returned if BrowserTimingHeader has
+	// not returned the header.  The footer contains the transaction name,
+	// so the name cannot be changed after the footer is created.
+	BrowserTimingFooter() template.HTML
+
 	// SegmentTracer allows the timing of functions, external calls, and
 	// datastore calls.  These methods MUST be used in a single goroutine.
 	// See segments.go
 	SegmentTracer
+
+	// NewGoroutine returns a Transaction which may be used in a new
+	// goroutine.  The returned Transaction shares everything with the
+	// original except for its SegmentTracer:  Segments must be started and
+	// ended using the same Transaction, but segments timed in different
+	// goroutines do not interfere with each other.  Segment durations from
+	// all goroutines are included in the transaction's breakdown metrics
+	// and events.  Segments from other goroutines do not reduce the
+	// exclusive time of the transaction or of other segments since they
+	// run concurrently.  Call NewGoroutine once for each goroutine:
+	//
+	//	go func(txn newrelic.Transaction) {
+	//		defer txn.EndSegment(txn.StartSegment(), "async")
+	//		// do the work
+	//	}(txn.NewGoroutine())
+	//
+	// Segments which have not been ended when the original Transaction's
+	// End method is called are not recorded.
+	NewGoroutine() Transaction
 }
@@ -0,0 +1,38 @@
+// +build go1.7
+
+package newrelic
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKeyType is unexported to prevent collisions with context keys
+// defined in other packages.
+type contextKeyType struct{}
+
+var contextKey = contextKeyType(struct{}{})
+
+// NewContext returns a new Context that carries the provided Transaction.
+func NewContext(ctx context.Context, txn Transaction) context.Context {
+	return context.WithValue(ctx, contextKey, txn)
+}
+
+// FromContext returns the Transaction from the context if present, and nil
+// otherwise.
+func FromContext(ctx context.Context) Transaction {
+	if nil == ctx {
+		return nil
+	}
+	txn, _ := ctx.Value(contextKey).(Transaction)
+	return txn
+}
+
+func requestWithTransactionContext(req *http.Request, txn Transaction) *http.Request {
+	ctx := NewContext(req.Context(), txn)
+	return req.WithContext(ctx)
+}
+
+func transactionFromRequestContext(req *http.Request) Transaction {
+	return FromContext(req.Context())
+}
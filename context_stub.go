@@ -0,0 +1,16 @@
+// +build !go1.7
+
+package newrelic
+
+import "net/http"
+
+// The context package and http.Request.Context were introduced in go1.7.  In
+// earlier versions, transactions are not added to request contexts.
+
+func requestWithTransactionContext(req *http.Request, txn Transaction) *http.Request {
+	return req
+}
+
+func transactionFromRequestContext(req *http.Request) Transaction {
+	return nil
+}
@@ -1,6 +1,13 @@
 package newrelic
 
-import "net/http"
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/newrelic/go-agent/internal"
+)
 
 // instrumentation.go contains helpers built on the lower level API.
 
@@ -13,9 +20,18 @@ import "net/http"
 //
 //    http.Handle(newrelic.WrapHandle(app, "/foo", fooHandler))
 //
-// The Transaction is passed to the handler in place of the original
-// http.ResponseWriter, so it can be accessed using type assertion.
-// For example, to rename the transaction:
+// The Transaction is added to the request's context and can be accessed using
+// FromContext (go1.7 and later).  For example, to rename the transaction:
+//
+//	// 'r' is the variable name of the *http.Request.
+//	if txn := newrelic.FromContext(r.Context()); nil != txn {
+//		txn.SetName("other-name")
+//	}
+//
+// The Transaction is also passed to the handler in place of the original
+// http.ResponseWriter, so it can be accessed using type assertion.  However,
+// this type assertion will fail if another middleware wraps the
+// http.ResponseWriter.
 //
 //	// 'w' is the variable name of the http.ResponseWriter.
 //	if txn, ok := w.(newrelic.Transaction); ok {
@@ -27,6 +43,8 @@ func WrapHandle(app Application, pattern string, handler http.Handler) (string,
 		txn := app.StartTransaction(pattern, w, r)
 		defer txn.End()
 
+		r = requestWithTransactionContext(r, txn)
+
 		handler.ServeHTTP(txn, r)
 	})
 }
@@ -47,21 +65,156 @@ func WrapHandleFunc(app Application, pattern string, handler func(http.ResponseW
 //   client.Transport = newrelic.NewRoundTripper(txn, nil)
 //   resp, err := client.Get("http://example.com/")
 //
+// If txn is nil, the Transaction is found using FromContext on each request's
+// context (go1.7 and later).  This allows a single http.Client to be shared by
+// all transactions.  Requests without a Transaction in their context are not
+// instrumented.
+//
+//   client := &http.Client{}
+//   client.Transport = newrelic.NewRoundTripper(nil, nil)
+//   request = request.WithContext(newrelic.NewContext(ctx, txn))
+//   resp, err := client.Do(request)
+//
 func NewRoundTripper(txn Transaction, original http.RoundTripper) http.RoundTripper {
+	if nil == original {
+		original = http.DefaultTransport
+	}
 	return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
-		token := txn.StartSegment()
-		txn.PrepareRequest(token, request)
-
-		if nil == original {
-			original = http.DefaultTransport
+		t := txn
+		if nil == t {
+			t = transactionFromRequestContext(request)
+		}
+		if nil == t {
+			return original.RoundTrip(request)
 		}
+
+		// A RoundTripper must not modify the request, so the tracing
+		// headers are added to a copy.
+		request = cloneRequest(request)
+
+		token := t.StartSegment()
+		t.PrepareRequest(token, request)
+
 		response, err := original.RoundTrip(request)
 
-		txn.EndRequest(token, request, response)
+		t.EndRequest(token, request, response)
 		return response, err
 	})
 }
 
+// cloneRequest makes a shallow copy of the request with its own headers.
+func cloneRequest(request *http.Request) *http.Request {
+	cpy := new(http.Request)
+	*cpy = *request
+	cpy.Header = make(http.Header, len(request.Header))
+	for key, vals := range request.Header {
+		cpy.Header[key] = append([]string(nil), vals...)
+	}
+	return cpy
+}
+
+// BrowserResponseWriter buffers a response so that the browser timing header
+// and footer can be inserted into HTML pages automatically.  Close must be
+// called to write the response:
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		txn := app.StartTransaction("page", w, r)
+//		defer txn.End()
+//
+//		bw := newrelic.NewBrowserResponseWriter(txn, txn)
+//		defer bw.Close()
+//
+//		io.WriteString(bw, page)
+//	}
+//
+// Responses which are not HTML, or which have a Content-Encoding, are written
+// unchanged.  Since the whole response is buffered, this should not be used
+// for large or streamed responses: Use Transaction.BrowserTimingHeader and
+// Transaction.BrowserTimingFooter in templates instead.
+type BrowserResponseWriter struct {
+	txn    Transaction
+	w      http.ResponseWriter
+	code   int
+	buf    bytes.Buffer
+	closed bool
+}
+
+// NewBrowserResponseWriter creates a BrowserResponseWriter which writes to w.
+// w is usually the Transaction itself so that the response code and headers
+// are recorded.
+func NewBrowserResponseWriter(txn Transaction, w http.ResponseWriter) *BrowserResponseWriter {
+	return &BrowserResponseWriter{txn: txn, w: w}
+}
+
+// Header returns the headers of the underlying http.ResponseWriter.
+func (bw *BrowserResponseWriter) Header() http.Header { return bw.w.Header() }
+
+// WriteHeader records the response code, which is written by Close.
+func (bw *BrowserResponseWriter) WriteHeader(code int) {
+	if bw.closed {
+		bw.w.WriteHeader(code)
+		return
+	}
+	if 0 == bw.code {
+		bw.code = code
+	}
+}
+
+// Write buffers the response body until Close is called.
+func (bw *BrowserResponseWriter) Write(b []byte) (int, error) {
+	if bw.closed {
+		return bw.w.Write(b)
+	}
+	if 0 == bw.code {
+		bw.code = http.StatusOK
+	}
+	return bw.buf.Write(b)
+}
+
+func (bw *BrowserResponseWriter) isHTML(body []byte) bool {
+	h := bw.w.Header()
+	if "" != h.Get("Content-Encoding") {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if "" == contentType {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if nil != err {
+		return false
+	}
+	return "text/html" == mediaType || "application/xhtml+xml" == mediaType
+}
+
+// Close inserts the browser timing header and footer into an HTML response
+// and writes the buffered response.  Subsequent writes are not buffered.
+func (bw *BrowserResponseWriter) Close() error {
+	if bw.closed {
+		return nil
+	}
+	bw.closed = true
+
+	body := bw.buf.Bytes()
+	if len(body) > 0 && bw.isHTML(body) {
+		body = internal.InsertBrowserTiming(body,
+			func() string { return string(bw.txn.BrowserTimingHeader()) },
+			func() string { return string(bw.txn.BrowserTimingFooter()) })
+		h := bw.w.Header()
+		if "" != h.Get("Content-Length") {
+			h.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+	if 0 != bw.code {
+		bw.w.WriteHeader(bw.code)
+	}
+	if len(body) > 0 {
+		_, err := bw.w.Write(body)
+		return err
+	}
+	return nil
+}
+
 type roundTripperFunc func(*http.Request) (*http.Response, error)
 
 func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
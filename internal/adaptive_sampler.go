@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptiveSampler decides which transactions that start a new distributed
+// trace are sampled, aiming for a target number of sampled transactions each
+// period.  The first target transactions of the first period are sampled.
+// Afterwards, transactions are sampled if their priority is high enough given
+// the number of transactions seen in the previous period.
+type adaptiveSampler struct {
+	sync.Mutex
+	period time.Duration
+	target uint64
+
+	periodEnd   time.Time
+	firstPeriod bool
+	seen        uint64
+	// priorityMin is the priority at or above which transactions are
+	// sampled after the first period.
+	priorityMin float32
+}
+
+func newAdaptiveSampler(period time.Duration, target uint64, now time.Time) *adaptiveSampler {
+	return &adaptiveSampler{
+		period:      period,
+		target:      target,
+		periodEnd:   now.Add(period),
+		firstPeriod: true,
+	}
+}
+
+// computeSampled returns true if a transaction with the priority given, which
+// is between zero and one, is sampled.
+func (s *adaptiveSampler) computeSampled(priority float32, now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if 0 == s.target {
+		return false
+	}
+	// A loop is used since no transactions may have been seen in the
+	// periods which have elapsed.
+	for now.After(s.periodEnd) {
+		s.periodEnd = s.periodEnd.Add(s.period)
+		s.firstPeriod = false
+		// If no transactions were seen, every transaction is sampled.
+		s.priorityMin = 0
+		if s.seen > s.target {
+			s.priorityMin = 1.0 - float32(s.target)/float32(s.seen)
+		}
+		s.seen = 0
+	}
+	s.seen++
+	if s.firstPeriod {
+		return s.seen <= s.target
+	}
+	return priority >= s.priorityMin
+}
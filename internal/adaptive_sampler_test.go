@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveSamplerFirstPeriod(t *testing.T) {
+	now := time.Now()
+	s := newAdaptiveSampler(time.Minute, 2, now)
+	for i, expect := range []bool{true, true, false, false} {
+		// The priority is ignored during the first period.
+		if sampled := s.computeSampled(0.99, now); sampled != expect {
+			t.Error(i, sampled)
+		}
+	}
+}
+
+func TestAdaptiveSamplerLaterPeriods(t *testing.T) {
+	now := time.Now()
+	s := newAdaptiveSampler(time.Minute, 2, now)
+	for i := 0; i < 10; i++ {
+		s.computeSampled(0, now)
+	}
+	// Ten transactions were seen during the first period, so the
+	// following period samples those with a priority of at least 0.8.
+	now = now.Add(time.Minute + time.Second)
+	if s.computeSampled(0.79, now) {
+		t.Error("low priority transaction sampled")
+	}
+	if !s.computeSampled(0.8, now) {
+		t.Error("high priority transaction not sampled")
+	}
+	// No transactions were seen during the third period, so every
+	// transaction is sampled during the fourth.
+	now = now.Add(2 * time.Minute)
+	if !s.computeSampled(0, now) {
+		t.Error("transaction not sampled")
+	}
+}
+
+func TestAdaptiveSamplerZeroTarget(t *testing.T) {
+	now := time.Now()
+	s := newAdaptiveSampler(time.Minute, 0, now)
+	if s.computeSampled(1, now) {
+		t.Error("transaction sampled")
+	}
+}
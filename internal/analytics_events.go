@@ -62,6 +62,10 @@ func newAnalyticsEvents(max int) *analyticsEvents {
 func (events *analyticsEvents) AddEvent(e analyticsEvent) {
 	events.numSeen++
 
+	if 0 == cap(*events.events) {
+		return
+	}
+
 	if len(*events.events) < cap(*events.events) {
 		events.events.Push(e)
 		if len(*events.events) == cap(*events.events) {
@@ -81,9 +85,11 @@ func (events *analyticsEvents) AddEvent(e analyticsEvent) {
 	heap.Push(events.events, e)
 }
 
-func (events *analyticsEvents) MergeFailed(other *analyticsEvents) {
+// MergeFailed merges events which could not be sent unless the number of
+// attempts to send them has reached the limit given.
+func (events *analyticsEvents) MergeFailed(other *analyticsEvents, attemptsLimit int) {
 	fails := other.failedHarvests + 1
-	if fails >= failedEventsAttemptsLimit {
+	if fails >= attemptsLimit {
 		log.Warn("discarding events", log.Context{"harvest_attempts": fails})
 		return
 	}
@@ -100,6 +106,31 @@ func (events *analyticsEvents) Merge(other *analyticsEvents) {
 	events.numSeen = allSeen
 }
 
+// split divides the events in half.  The events seen are divided in proportion
+// to the events in each half, and the reservoir size of each half is its
+// number of events.
+func (events *analyticsEvents) split() (*analyticsEvents, *analyticsEvents) {
+	n := len(*events.events)
+	if n < 2 {
+		return nil, nil
+	}
+	mid := n / 2
+	first := (*events.events)[0:mid:mid]
+	second := (*events.events)[mid:n:n]
+	firstSeen := events.numSeen * mid / n
+	a := &analyticsEvents{
+		numSeen:        firstSeen,
+		events:         &first,
+		failedHarvests: events.failedHarvests,
+	}
+	b := &analyticsEvents{
+		numSeen:        events.numSeen - firstSeen,
+		events:         &second,
+		failedHarvests: events.failedHarvests,
+	}
+	return a, b
+}
+
 func (events *analyticsEvents) CollectorJSON(agentRunID string) ([]byte, error) {
 	if 0 == events.numSeen {
 		return nil, nil
@@ -151,7 +151,7 @@ func TestAnalyticsEventMergeFailedSuccess(t *testing.T) {
 	e2.AddEvent(sampleAnalyticsEvent(18))
 	e2.AddEvent(sampleAnalyticsEvent(24))
 
-	e1.MergeFailed(e2)
+	e1.MergeFailed(e2, failedEventsAttemptsLimit)
 
 	json, err := e1.CollectorJSON(agentRunID)
 	if nil != err {
@@ -186,7 +186,7 @@ func TestAnalyticsEventMergeFailedLimitReached(t *testing.T) {
 
 	e2.failedHarvests = failedEventsAttemptsLimit
 
-	e1.MergeFailed(e2)
+	e1.MergeFailed(e2, failedEventsAttemptsLimit)
 
 	json, err := e1.CollectorJSON(agentRunID)
 	if nil != err {
@@ -266,3 +266,43 @@ func BenchmarkErrorEventsCollectorJSON(b *testing.B) {
 	}
 	analyticsEventBenchmarkHelper(b, event)
 }
+
+func TestAnalyticsEventsSplit(t *testing.T) {
+	events := newAnalyticsEvents(10)
+	for i := 0; i < 5; i++ {
+		events.AddEvent(sampleAnalyticsEvent(i))
+	}
+	events.numSeen = 20
+	events.failedHarvests = 1
+
+	first, second := events.split()
+	js, err := first.CollectorJSON(agentRunID)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if string(js) != `["12345",{"reservoir_size":2,"events_seen":8},[0,1]]` {
+		t.Error(string(js))
+	}
+	js, err = second.CollectorJSON(agentRunID)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if string(js) != `["12345",{"reservoir_size":3,"events_seen":12},[2,3,4]]` {
+		t.Error(string(js))
+	}
+	if first.failedHarvests != 1 || second.failedHarvests != 1 {
+		t.Error(first.failedHarvests, second.failedHarvests)
+	}
+
+	// Merging a half does not affect the other.
+	first.AddEvent(sampleAnalyticsEvent(9))
+	if len(*second.events) != 3 || (*second.events)[0].stamp != 2 {
+		t.Error(*second.events)
+	}
+
+	single := newAnalyticsEvents(10)
+	single.AddEvent(sampleAnalyticsEvent(1))
+	if first, second := single.split(); nil != first || nil != second {
+		t.Error(first, second)
+	}
+}
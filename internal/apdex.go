@@ -11,13 +11,19 @@ const (
 	apdexFailing
 )
 
+// apdexFailingThreshold calculates the threshold at which the transaction is
+// considered a failure.
+func apdexFailingThreshold(threshold time.Duration) time.Duration {
+	return 4 * threshold
+}
+
 // Note that this does not take into account whether or not the transaction
 // had an error.  That is expected to be done by the caller.
 func calculateApdexZone(threshold, duration time.Duration) apdexZone {
 	if duration <= threshold {
 		return apdexSatisfying
 	}
-	if duration <= (4 * threshold) {
+	if duration <= apdexFailingThreshold(threshold) {
 		return apdexTolerating
 	}
 	return apdexFailing
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"math/rand"
 	"net/http"
 	"sync"
 	"time"
@@ -19,6 +20,36 @@ import (
 type appRun struct {
 	*ConnectReply
 	collector string
+	// eventHarvestPeriod and limits are the effective harvest
+	// configuration of the run.
+	eventHarvestPeriod time.Duration
+	limits             harvestLimits
+}
+
+// newAppRun creates an appRun, applying the event harvest configuration of
+// the reply.  The collector may lengthen the event harvest period and lower
+// the event limits, but it may not exceed the local configuration.
+func newAppRun(config *api.Config, reply *ConnectReply, collector string) *appRun {
+	period := configEventHarvestPeriod(config)
+	if ms := reply.EventData.ReportPeriodMs; nil != ms {
+		if p := time.Duration(*ms) * time.Millisecond; p > period {
+			period = p
+		}
+		if period > harvestPeriod {
+			period = harvestPeriod
+		}
+	}
+	limits := harvestLimitsFromConfig(config, period)
+	serverLimits := reply.EventData.Limits
+	limits.txnEvents = clampEventLimit(limits.txnEvents, serverLimits.TxnEvents)
+	limits.customEvents = clampEventLimit(limits.customEvents, serverLimits.CustomEvents)
+	limits.errorEvents = clampEventLimit(limits.errorEvents, serverLimits.ErrorEvents)
+	return &appRun{
+		ConnectReply:       reply,
+		collector:          collector,
+		eventHarvestPeriod: period,
+		limits:             limits,
+	}
 }
 
 type appData struct {
@@ -35,15 +66,48 @@ type App struct {
 
 	harvestTicker      *time.Ticker
 	harvestChan        <-chan time.Time
+	eventHarvestTicker *time.Ticker
+	eventHarvestChan   <-chan time.Time
+	// harvestTypes are the types sent on each harvestChan tick.  Events
+	// are included when they are not harvested separately.
+	harvestTypes       harvestTypes
 	dataChan           chan appData
 	collectorErrorChan chan error
 	connectChan        chan *appRun
+	// spool is non-nil if the spool is configured.
+	spool *spool
+	// sampler decides which transactions starting a distributed trace are
+	// sampled.
+	sampler *adaptiveSampler
+
+	// shutdownStarted is closed when Shutdown is called.  Once it is
+	// closed, goroutines spawned by the app should exit and API calls
+	// should no longer record data.  shutdownComplete is closed by the
+	// processor goroutine once the final harvest has been sent.
+	shutdownStarted  chan struct{}
+	shutdownComplete chan struct{}
+	shutdownOnce     sync.Once
+	// harvestWait tracks harvest goroutines which may have outstanding
+	// collector calls.
+	harvestWait sync.WaitGroup
 
 	// run is non-nil when the app is successfully connected.  It is
 	// immutable.  It is assigned by the processor goroutine and accessed by
 	// goroutines calling app API methods.  It should be accessed using
 	// getRun and SetRun.
 	run *appRun
+	// err is non-nil if the app has been permanently disconnected from the
+	// collector, such as by a license exception.  It is assigned by the
+	// processor goroutine and returned by WaitForConnection.
+	err error
+	// stateChanged is closed and replaced each time run or err is
+	// assigned, waking goroutines blocked in WaitForConnection.
+	stateChanged chan struct{}
+	// harvestBackoff is the time before which harvests are not sent,
+	// requested by the Retry-After header of a collector response.  It is
+	// assigned by harvest goroutines and accessed by the processor
+	// goroutine.
+	harvestBackoff time.Time
 	sync.RWMutex
 }
 
@@ -63,80 +127,344 @@ func shouldSaveFailedHarvest(e error) bool {
 	if e == ErrPayloadTooLarge || e == ErrUnsupportedMedia {
 		return false
 	}
-	return true
+	return !hasAction(e, collectorDiscard)
 }
 
-func (app *App) doHarvest(h *harvest, harvestStart time.Time, run *appRun) {
-	h.createFinalMetrics()
-	h.applyMetricRules(run.MetricRules)
+// payloadMetric is a supportability metric recorded while sending a harvest.
+type payloadMetric string
 
-	payloads := h.payloads()
-	for cmd, p := range payloads {
+func (m payloadMetric) mergeIntoHarvest(h *harvest) {
+	h.metrics.addSingleCount(string(m), forced)
+}
 
-		data, err := p.Data(run.RunID.String(), harvestStart)
+// payloadSizeMetrics records the uncompressed and compressed sizes of a
+// request sent to the collector.
+type payloadSizeMetrics struct {
+	cmd          string
+	uncompressed int
+	compressed   int
+}
 
-		if nil == data && nil == err {
-			continue
-		}
+func (m payloadSizeMetrics) mergeIntoHarvest(h *harvest) {
+	h.metrics.addValue(payloadUncompressedMetric(m.cmd), "", float64(m.uncompressed), forced)
+	h.metrics.addValue(payloadCompressedMetric(m.cmd), "", float64(m.compressed), forced)
+}
 
-		if nil == err {
-			call := rpmCmd{
-				UseTLS:    app.config.UseTLS,
-				Collector: run.collector,
-				License:   app.config.License,
-				RunID:     run.RunID.String(),
-				Name:      cmd,
-				Data:      data,
-			}
+// payloadSizes records the sizes of several requests, such as those made while
+// connecting, which are recorded once the application is connected.
+type payloadSizes []payloadSizeMetrics
 
-			// The reply from harvest calls is always unused.
-			_, err = collectorRequest(call, app.client)
-		}
+// add records the size of a request if its body was compressed.
+func (ps *payloadSizes) add(cmd string, uncompressed, compressed int) {
+	if nil != ps && compressed > 0 {
+		*ps = append(*ps, payloadSizeMetrics{
+			cmd:          cmd,
+			uncompressed: uncompressed,
+			compressed:   compressed,
+		})
+	}
+}
 
-		if nil == err {
-			continue
+func (ps payloadSizes) mergeIntoHarvest(h *harvest) {
+	for _, m := range ps {
+		m.mergeIntoHarvest(h)
+	}
+}
+
+// harvestPayload sends a single payload to the collector.  A fatal collector
+// error is returned so that the caller may pass it to the processor goroutine.
+// Payloads which are too large are split.  Payloads which failed because the
+// collector is unreachable are spooled if the spool is configured.  Other
+// failed payloads are merged into the next harvest when possible.
+func (app *App) harvestPayload(cmd string, p payloadCreator, harvestStart time.Time, run *appRun) error {
+	data, err := p.Data(run.RunID.String(), harvestStart)
+
+	if nil == data && nil == err {
+		return nil
+	}
+
+	if nil == err && app.exceedsMaxPayload(cmd, len(data)) {
+		return app.splitPayload(cmd, p, run, func(half payloadCreator) error {
+			return app.harvestPayload(cmd, half, harvestStart, run)
+		})
+	}
+
+	if nil == err {
+		err = app.sendPayload(cmd, data, run)
+	}
+
+	if nil == err {
+		return nil
+	}
+
+	if isFatalHarvestError(err) {
+		return err
+	}
+
+	if delay := retryAfter(err); delay > 0 {
+		app.setHarvestBackoff(time.Now().Add(delay))
+	}
+
+	if ErrPayloadTooLarge == err {
+		return app.splitPayload(cmd, p, run, func(half payloadCreator) error {
+			return app.harvestPayload(cmd, half, harvestStart, run)
+		})
+	}
+
+	log.Warn("harvest failure", log.Context{
+		"cmd":   cmd,
+		"error": err.Error(),
+	})
+
+	if nil != app.spool && isCollectorUnreachable(err) {
+		app.spool.write(cmd, data, time.Now())
+		return nil
+	}
+
+	if shouldSaveFailedHarvest(err) {
+		app.consume(run.RunID, p)
+	}
+	return nil
+}
+
+// sendPayload sends a payload to the collector using the run given and records
+// its size.
+func (app *App) sendPayload(cmd string, data []byte, run *appRun) error {
+	// The reply from harvest calls is always unused.
+	_, compressed, err := collectorRequestSize(rpmCmd{
+		UseTLS:      app.config.UseTLS,
+		Collector:   run.collector,
+		License:     app.config.License,
+		RunID:       run.RunID.String(),
+		Name:        cmd,
+		Data:        data,
+		Compression: app.config.Compression,
+	}, app.client)
+	if compressed > 0 {
+		app.consume(run.RunID, payloadSizeMetrics{
+			cmd:          cmd,
+			uncompressed: len(data),
+			compressed:   compressed,
+		})
+	}
+	return err
+}
+
+// exceedsMaxPayload returns true if a payload of the command and size given is
+// larger than Config.MaxPayloadBytes allows.  Only payloads which can be split
+// are limited:  Others are sent, and discarded if the collector rejects them.
+func (app *App) exceedsMaxPayload(cmd string, size int) bool {
+	return splittableCmds[cmd] && app.config.MaxPayloadBytes > 0 &&
+		size > app.config.MaxPayloadBytes
+}
+
+// splitPayload divides a payload which is too large to be sent in a single
+// request, and passes each half to the function given.  Payloads which cannot
+// be split are discarded.
+func (app *App) splitPayload(cmd string, p payloadCreator, run *appRun, send func(payloadCreator) error) error {
+	if s, ok := p.(splittablePayload); ok {
+		if first, second := s.split(); nil != first {
+			app.consume(run.RunID, payloadMetric(payloadSplitMetric(cmd)))
+			if err := send(first); nil != err {
+				return err
+			}
+			return send(second)
 		}
+	}
+	app.dropPayload(cmd, run)
+	return nil
+}
 
-		if isFatalHarvestError(err) {
-			app.collectorErrorChan <- err
-			return
+// dropPayload records a payload discarded because it is too large.
+func (app *App) dropPayload(cmd string, run *appRun) {
+	log.Warn("discarding payload too large", log.Context{
+		"cmd":               cmd,
+		"max_payload_bytes": app.config.MaxPayloadBytes,
+	})
+	app.consume(run.RunID, payloadMetric(payloadDroppedMetric(cmd)))
+}
+
+// doHarvest sends the payloads of a harvest returned by harvest.ready to the
+// collector.  A fatal collector error is returned so that the caller may pass
+// it to the processor goroutine.
+func (app *App) doHarvest(h *harvest, harvestStart time.Time, run *appRun) error {
+	h.applyMetricRules(run.MetricRules)
+
+	for cmd, p := range h.payloads() {
+		if err := app.harvestPayload(cmd, p, harvestStart, run); nil != err {
+			return err
 		}
+	}
+	return nil
+}
+
+// harvestRoutine sends a harvest of the types given.  The spool is replayed
+// after the metrics are sent successfully, rather than after each event
+// harvest, so that the spool is read at most once a minute.
+func (app *App) harvestRoutine(h *harvest, types harvestTypes, harvestStart time.Time, run *appRun) {
+	defer app.harvestWait.Done()
+
+	if err := app.doHarvest(h, harvestStart, run); nil != err {
+		app.reportCollectorError(err)
+		return
+	}
+	if 0 != types&harvestMetricsTraces {
+		app.replaySpool(run)
+	}
+}
+
+// spoolRoutine spools the payloads of a harvest which cannot be sent because
+// its run has ended.
+func (app *App) spoolRoutine(h *harvest, harvestStart time.Time, run *appRun) {
+	defer app.harvestWait.Done()
+
+	app.spoolHarvest(h, harvestStart, run)
+}
+
+func (app *App) spoolHarvest(h *harvest, harvestStart time.Time, run *appRun) {
+	h.applyMetricRules(run.MetricRules)
+
+	for cmd, p := range h.payloads() {
+		app.spoolPayload(cmd, p, harvestStart, run)
+	}
+}
 
-		log.Warn("harvest failure", log.Context{
-			"cmd":   cmd,
-			"error": err.Error(),
+// spoolPayload spools a single payload.  Payloads which are too large are
+// split before they are spooled, as they are before they are sent, so that
+// they can be replayed.
+func (app *App) spoolPayload(cmd string, p payloadCreator, harvestStart time.Time, run *appRun) error {
+	data, err := p.Data(run.RunID.String(), harvestStart)
+	if nil == data || nil != err {
+		return nil
+	}
+	if app.exceedsMaxPayload(cmd, len(data)) {
+		return app.splitPayload(cmd, p, run, func(half payloadCreator) error {
+			return app.spoolPayload(cmd, half, harvestStart, run)
 		})
+	}
+	app.spool.write(cmd, data, harvestStart)
+	return nil
+}
 
-		if shouldSaveFailedHarvest(err) {
-			app.consume(run.RunID, p)
+// replaySpool sends the spooled payloads using the run given.  Spooled
+// payloads cannot be split:  Those which are too large, for example because
+// they were spooled by a process with a larger Config.MaxPayloadBytes, are
+// discarded.
+func (app *App) replaySpool(run *appRun) {
+	if nil == app.spool {
+		return
+	}
+	app.spool.replay(time.Now(), func(cmd string, data []byte) error {
+		data, err := replaceRunID(data, run.RunID.String())
+		if nil != err {
+			return err
+		}
+		if app.exceedsMaxPayload(cmd, len(data)) {
+			err = ErrPayloadTooLarge
+		} else {
+			err = app.sendPayload(cmd, data, run)
 		}
+		if ErrPayloadTooLarge == err {
+			app.dropPayload(cmd, run)
+		}
+		return err
+	})
+}
+
+// reportCollectorError passes a fatal collector error to the processor
+// goroutine unless the app is shutting down.
+func (app *App) reportCollectorError(err error) {
+	select {
+	case app.collectorErrorChan <- err:
+	case <-app.shutdownStarted:
 	}
 }
 
+func (app *App) isShutdown() bool {
+	select {
+	case <-app.shutdownStarted:
+		return true
+	default:
+		return false
+	}
+}
+
+// connectBackoffs are the delays between failed connect attempts.  The last
+// delay is repeated.
+var connectBackoffs = []time.Duration{
+	15 * time.Second,
+	30 * time.Second,
+	1 * time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+}
+
+// backoffRand randomizes connect backoff delays.  A private source seeded
+// with the time is used since the global source of older Go versions always
+// starts from the same seed, which would give every process the same delays.
+var backoffRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// connectBackoff returns the delay following the number of consecutive failed
+// connect attempts given.  The delay is randomized between the scheduled delay
+// and one and a half times the scheduled delay so that applications do not
+// reconnect in lock-step after a collector outage.  A delay requested by the
+// collector using the Retry-After header is honored if it is longer.
+func connectBackoff(failures int, err error) time.Duration {
+	if failures > len(connectBackoffs) {
+		failures = len(connectBackoffs)
+	}
+	scheduled := connectBackoffs[failures-1]
+	backoffRand.Lock()
+	jitter := time.Duration(backoffRand.Int63n(int64(scheduled/2) + 1))
+	backoffRand.Unlock()
+	delay := scheduled + jitter
+	if requested := retryAfter(err); requested > delay {
+		delay = requested
+	}
+	return delay
+}
+
 func (app *App) connectRoutine() {
-	for {
-		collector, reply, err := connectAttempt(&app.config, app.client)
+	// The sizes of the connect requests are recorded by the run which
+	// they connect, once the processor goroutine has started it.
+	var sizes payloadSizes
+	for failures := 1; ; failures++ {
+		collector, reply, err := connectAttempt(&app.config, app.client, &sizes)
 		if nil == err {
-			app.connectChan <- &appRun{reply, collector}
+			run := newAppRun(&app.config, reply, collector)
+			select {
+			case app.connectChan <- run:
+				app.consume(run.RunID, sizes)
+			case <-app.shutdownStarted:
+			}
 			return
 		}
 
 		if isDisconnect(err) || isLicenseException(err) {
-			app.collectorErrorChan <- err
+			app.reportCollectorError(err)
 			return
 		}
 
+		delay := connectBackoff(failures, err)
 		log.Warn("application connect failure", log.Context{
-			"error": err.Error(),
+			"error":        err.Error(),
+			"next_attempt": time.Now().Add(delay).Format(time.RFC3339),
 		})
 
-		time.Sleep(connectBackoff)
+		select {
+		case <-time.After(delay):
+		case <-app.shutdownStarted:
+			return
+		}
 	}
 }
 
 func debug(data harvestable) {
 	now := time.Now()
-	h := newHarvest(now)
+	h := newHarvest(now, defaultHarvestLimits)
 	data.mergeIntoHarvest(h)
 	ps := h.payloads()
 	for cmd, p := range ps {
@@ -158,53 +486,233 @@ func debug(data harvestable) {
 	}
 }
 
+func (app *App) setHarvestBackoff(until time.Time) {
+	app.Lock()
+	defer app.Unlock()
+
+	if until.After(app.harvestBackoff) {
+		app.harvestBackoff = until
+		log.Warn("harvest backoff requested", log.Context{
+			"app":          app.config.AppName,
+			"next_attempt": until.Format(time.RFC3339),
+		})
+	}
+}
+
+func (app *App) inHarvestBackoff(now time.Time) bool {
+	app.RLock()
+	defer app.RUnlock()
+
+	return now.Before(app.harvestBackoff)
+}
+
+// setEventHarvestPeriod starts the event harvest ticker.  Events harvested
+// once a minute are sent with the metrics rather than using a separate
+// ticker, so that both are harvested at the same time.
+func (app *App) setEventHarvestPeriod(period time.Duration) {
+	if nil != app.eventHarvestTicker {
+		app.eventHarvestTicker.Stop()
+		app.eventHarvestTicker = nil
+		app.eventHarvestChan = nil
+	}
+	if period >= harvestPeriod {
+		app.harvestTypes = harvestAll
+		return
+	}
+	app.harvestTypes = harvestMetricsTraces
+	app.eventHarvestTicker = time.NewTicker(period)
+	app.eventHarvestChan = app.eventHarvestTicker.C
+}
+
+// startHarvest sends the payloads of the types given in a new goroutine.  The
+// data remains in the harvest if the collector has requested a delay.
+func (app *App) startHarvest(h *harvest, types harvestTypes) {
+	run := app.getRun()
+	if "" != run.RunID && nil != h && !app.inHarvestBackoff(time.Now()) {
+		if nil != app.spool && 0 != types&harvestMetricsTraces {
+			app.spool.mergeIntoHarvest(h)
+		}
+		now := time.Now()
+		app.harvestWait.Add(1)
+		go app.harvestRoutine(h.ready(types, now), types, now, run)
+	}
+}
+
+// offlineHarvest collects the data recorded while the app is not connected,
+// which is spooled so that it is sent once the app connects.
+type offlineHarvest struct {
+	h     *harvest
+	empty bool
+}
+
+// newOfflineHarvest returns nil if the spool is not configured, in which case
+// data recorded while the app is not connected is discarded.
+func (app *App) newOfflineHarvest(now time.Time) *offlineHarvest {
+	if nil == app.spool {
+		return nil
+	}
+	return &offlineHarvest{
+		h:     newHarvest(now, harvestLimitsFromConfig(&app.config, harvestPeriod)),
+		empty: true,
+	}
+}
+
+// take removes the data recorded since the last take.  nil is returned if no
+// data has been recorded.
+func (o *offlineHarvest) take(now time.Time) *harvest {
+	if nil == o || o.empty {
+		return nil
+	}
+	o.empty = true
+	return o.h.ready(harvestAll, now)
+}
+
+// mergeData merges data into the current harvest if it was recorded by the
+// current run or before the app connected.  If the app is not connected, the
+// data is merged into the offline harvest, including data recorded by a
+// previous run.
+func (app *App) mergeData(d appData, h *harvest, offline *offlineHarvest) {
+	switch {
+	case nil != h:
+		if run := app.getRun(); "" == d.id || run.RunID == d.id {
+			d.data.mergeIntoHarvest(h)
+		}
+	case nil != offline:
+		d.data.mergeIntoHarvest(offline.h)
+		offline.empty = false
+	}
+}
+
 func (app *App) process() {
 	var h *harvest
+	offline := app.newOfflineHarvest(time.Now())
 
 	for {
 		select {
 		case <-app.harvestChan:
-			run := app.getRun()
-			if "" != run.RunID && nil != h {
-				now := time.Now()
-				go app.doHarvest(h, now, run)
-				h = newHarvest(now)
+			app.startHarvest(h, app.harvestTypes)
+			now := time.Now()
+			if ready := offline.take(now); nil != ready {
+				app.harvestWait.Add(1)
+				go app.spoolRoutine(ready, now, placeholderRun)
 			}
+		case <-app.eventHarvestChan:
+			app.startHarvest(h, harvestEvents)
 		case d := <-app.dataChan:
-			run := app.getRun()
-			if "" != d.id && nil != h && run.RunID == d.id {
-				d.data.mergeIntoHarvest(h)
-			}
+			app.mergeData(d, h, offline)
 
 		case err := <-app.collectorErrorChan:
+			// The data of a restarted run would otherwise be
+			// discarded.
+			if run := app.getRun(); nil != app.spool && isRestartException(err) &&
+				"" != run.RunID && nil != h {
+				now := time.Now()
+				app.harvestWait.Add(1)
+				go app.spoolRoutine(h.ready(harvestAll, now), now, run)
+			}
 			h = nil
-			app.setRun(nil)
+			app.setState(nil, nil)
+			offline = nil
+			if isRestartException(err) {
+				offline = app.newOfflineHarvest(time.Now())
+			}
 
 			switch {
 			case isDisconnect(err):
+				app.setState(nil, err)
 				log.Error("application disconnected by New Relic", log.Context{
-					"app": app.config.AppName,
+					"app":   app.config.AppName,
+					"error": err.Error(),
 				})
 			case isLicenseException(err):
+				app.setState(nil, err)
 				log.Error("invalid license", log.Context{
 					"app":     app.config.AppName,
 					"license": app.config.License,
 				})
 			case isRestartException(err):
 				log.Info("application restarted", log.Context{
-					"app": app.config.AppName,
+					"app":   app.config.AppName,
+					"error": err.Error(),
 				})
 				go app.connectRoutine()
 			}
 		case r := <-app.connectChan:
-			h = newHarvest(time.Now())
-			app.setRun(r)
+			h = newHarvest(time.Now(), r.limits)
+			app.setState(r, nil)
+			app.setEventHarvestPeriod(r.eventHarvestPeriod)
 			log.Info("application connected", log.Context{
 				"app": app.config.AppName,
 				"run": r.RunID.String(),
 			})
+			log.Info("event harvest configuration", log.Context{
+				"app":                 app.config.AppName,
+				"report_period_ms":    durationToIntMilliseconds(r.eventHarvestPeriod),
+				"analytic_event_data": r.limits.txnEvents,
+				"custom_event_data":   r.limits.customEvents,
+				"error_event_data":    r.limits.errorEvents,
+			})
+			if nil != app.spool {
+				// The data recorded before the app connected is
+				// spooled before the replay so that it is sent.
+				now := time.Now()
+				pending := offline.take(now)
+				app.harvestWait.Add(1)
+				go func(run *appRun) {
+					defer app.harvestWait.Done()
+					if nil != pending {
+						app.spoolHarvest(pending, now, placeholderRun)
+					}
+					app.replaySpool(run)
+				}(r)
+			}
+			offline = nil
+		case <-app.shutdownStarted:
+			app.finalHarvest(h, offline)
+			close(app.shutdownComplete)
+			return
+		}
+	}
+}
+
+// finalHarvest merges any data still waiting in the data channel, sends the
+// in-progress harvest or spools the offline harvest, and waits for harvests
+// already in flight.
+func (app *App) finalHarvest(h *harvest, offline *offlineHarvest) {
+	app.harvestTicker.Stop()
+	// Stop the event harvest ticker.
+	app.setEventHarvestPeriod(harvestPeriod)
+
+	run := app.getRun()
+	// The processor goroutine is the only reader of dataChan, so the
+	// length check is safe.
+	for len(app.dataChan) > 0 {
+		app.mergeData(<-app.dataChan, h, offline)
+	}
+
+	now := time.Now()
+	if ready := offline.take(now); nil != ready {
+		app.spoolHarvest(ready, now, placeholderRun)
+	}
+
+	if "" != run.RunID && nil != h {
+		if nil != app.spool {
+			app.spool.mergeIntoHarvest(h)
+		}
+		now := time.Now()
+		if err := app.doHarvest(h.ready(harvestAll, now), now, run); nil != err {
+			log.Warn("final harvest failure", log.Context{
+				"app":   app.config.AppName,
+				"error": err.Error(),
+			})
 		}
 	}
+
+	app.harvestWait.Wait()
+
+	log.Info("application shutdown", log.Context{
+		"app": app.config.AppName,
+	})
 }
 
 func makeSHA256(key string) string {
@@ -235,11 +743,17 @@ func NewAppInternal(c api.Config) (api.Application, error) {
 			attributes:        c.Attributes,
 			errorCollector:    c.ErrorCollector.Attributes,
 			transactionEvents: c.TransactionEvents.Attributes,
+			transactionTracer: c.TransactionTracer.Attributes,
+			browserMonitoring: c.BrowserMonitoring.Attributes,
 		}),
 
 		connectChan:        make(chan *appRun),
 		collectorErrorChan: make(chan error),
 		dataChan:           make(chan appData, appDataChanSize),
+		shutdownStarted:    make(chan struct{}),
+		shutdownComplete:   make(chan struct{}),
+		stateChanged:       make(chan struct{}),
+		sampler:            newAdaptiveSampler(harvestPeriod, samplerTarget, time.Now()),
 		client: &http.Client{
 			Transport: c.Transport,
 			Timeout:   collectorTimeout,
@@ -256,8 +770,23 @@ func NewAppInternal(c api.Config) (api.Application, error) {
 		return app, nil
 	}
 
+	if "" != c.Spool.Dir {
+		dir := spoolDir(&c)
+		s, err := newSpool(dir, c.Spool.MaxBytes, c.Spool.MaxAge)
+		if nil != err {
+			log.Error("unable to create spool", log.Context{
+				"app":   app.config.AppName,
+				"dir":   dir,
+				"error": err.Error(),
+			})
+		} else {
+			app.spool = s
+		}
+	}
+
 	app.harvestTicker = time.NewTicker(harvestPeriod)
 	app.harvestChan = app.harvestTicker.C
+	app.setEventHarvestPeriod(configEventHarvestPeriod(&c))
 
 	go app.process()
 	go app.connectRoutine()
@@ -294,13 +823,16 @@ func NewTestApp(replyfn func(*ConnectReply), cfg api.Config) (ExpectApp, error)
 		return nil, err
 	}
 	app := application.(*App)
+	reply := connectReplyDefaults()
 	if nil != replyfn {
-		reply := connectReplyDefaults()
 		replyfn(reply)
-		app.setRun(&appRun{ConnectReply: reply})
+	}
+	run := newAppRun(&app.config, reply, "")
+	if nil != replyfn {
+		app.setRun(run)
 	}
 
-	app.testHarvest = newHarvest(time.Now())
+	app.testHarvest = newHarvest(time.Now(), run.limits)
 
 	return app, nil
 }
@@ -322,17 +854,99 @@ func (app *App) setRun(run *appRun) {
 	app.run = run
 }
 
+func (app *App) getState() (*appRun, error) {
+	app.RLock()
+	defer app.RUnlock()
+
+	run := app.run
+	if nil == run {
+		run = placeholderRun
+	}
+	return run, app.err
+}
+
+func (app *App) setState(run *appRun, err error) {
+	app.Lock()
+	defer app.Unlock()
+
+	app.run = run
+	app.err = err
+	close(app.stateChanged)
+	app.stateChanged = make(chan struct{})
+}
+
+// ErrConnectionTimeout is returned by app.WaitForConnection if the
+// application does not connect within the timeout.
+var ErrConnectionTimeout = errors.New("timeout waiting for connection")
+
+// ErrApplicationShutdown is returned by app.WaitForConnection if the
+// application is shut down before it connects.
+var ErrApplicationShutdown = errors.New("application shut down")
+
+// WaitForConnection implements newrelic.Application's WaitForConnection.
+func (app *App) WaitForConnection(timeout time.Duration) error {
+	if !app.config.Enabled {
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		app.RLock()
+		run, err, changed := app.run, app.err, app.stateChanged
+		app.RUnlock()
+
+		if nil != err {
+			return err
+		}
+		if nil != run && "" != run.RunID {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-app.shutdownStarted:
+			return ErrApplicationShutdown
+		case <-timer.C:
+			return ErrConnectionTimeout
+		}
+	}
+}
+
 // StartTransaction implements newrelic.Application's StartTransaction.
 func (app *App) StartTransaction(name string, w http.ResponseWriter, r *http.Request) api.Transaction {
 	run := app.getRun()
-	return upgradeTxn(newTxn(txnInput{
+	txn := newTxn(txnInput{
 		Config:     app.config,
 		Reply:      run.ConnectReply,
 		Request:    r,
 		W:          w,
 		Consumer:   app,
+		Sampler:    app.sampler,
 		attrConfig: app.attrConfig,
-	}, name))
+	}, name)
+	if app.isShutdown() {
+		txn.ignore = true
+	}
+	return upgradeTxn(txn)
+}
+
+// Shutdown implements newrelic.Application's Shutdown.
+func (app *App) Shutdown(timeout time.Duration) {
+	app.shutdownOnce.Do(func() {
+		close(app.shutdownStarted)
+	})
+
+	if !app.config.Enabled {
+		return
+	}
+
+	select {
+	case <-app.shutdownComplete:
+	case <-time.After(timeout):
+		log.Warn("application shutdown timeout", log.Context{
+			"app":     app.config.AppName,
+			"timeout": timeout.String(),
+		})
+	}
 }
 
 var (
@@ -349,6 +963,10 @@ var (
 
 // RecordCustomEvent implements newrelic.Application's RecordCustomEvent.
 func (app *App) RecordCustomEvent(eventType string, params map[string]interface{}) error {
+	if app.isShutdown() {
+		return nil
+	}
+
 	if app.config.HighSecurity {
 		return ErrHighSecurityEnabled
 	}
@@ -372,6 +990,83 @@ func (app *App) RecordCustomEvent(eventType string, params map[string]interface{
 	return nil
 }
 
+// NoticeError implements newrelic.Application's NoticeError.
+func (app *App) NoticeError(err error, attrs map[string]interface{}) error {
+	if app.isShutdown() {
+		return nil
+	}
+
+	if nil == err {
+		return ErrNilError
+	}
+
+	e := txnErrorFromError(err)
+	if errorIgnored(&app.config, &e) {
+		return nil
+	}
+	e.expected = errorExpected(&app.config, &e)
+
+	if !app.config.ErrorCollector.Enabled {
+		return ErrorsLocallyDisabled
+	}
+
+	run := app.getRun()
+	if !run.CollectErrors {
+		return ErrorsRemotelyDisabled
+	}
+
+	a := newAttributes(app.attrConfig)
+	for key, val := range attrs {
+		if err := addUserAttribute(a, key, val, destError); nil != err {
+			return err
+		}
+	}
+
+	if nil == e.stack {
+		e.stack = getStackTrace(1)
+	}
+	if app.config.HighSecurity {
+		e.msg = HighSecurityErrorMsg
+	}
+	e.when = time.Now()
+	e.userAttrs = errorUserAttributes(app.attrConfig, err)
+
+	app.consume(run.RunID, &appError{
+		txnError:    e,
+		attrs:       a,
+		errorEvents: app.config.ErrorCollector.CaptureEvents && run.CollectErrorEvents,
+	})
+
+	return nil
+}
+
+func (app *App) recordCustomMetric(m *customMetric, err error) error {
+	if app.isShutdown() {
+		return nil
+	}
+	if nil != err {
+		return err
+	}
+	app.consume(app.getRun().RunID, m)
+	return nil
+}
+
+// RecordCustomMetric implements newrelic.Application's RecordCustomMetric.
+func (app *App) RecordCustomMetric(name string, value float64) error {
+	return app.recordCustomMetric(customMetricValue(name, value))
+}
+
+// IncrementCustomMetric implements newrelic.Application's
+// IncrementCustomMetric.
+func (app *App) IncrementCustomMetric(name string, count int) error {
+	return app.recordCustomMetric(customMetricCount(name, count))
+}
+
+// RecordCustomTiming implements newrelic.Application's RecordCustomTiming.
+func (app *App) RecordCustomTiming(name string, duration time.Duration) error {
+	return app.recordCustomMetric(customMetricDuration(name, duration))
+}
+
 func (app *App) consume(id AgentRunID, data harvestable) {
 	if "" != debugLogging {
 		debug(data)
@@ -382,9 +1077,14 @@ func (app *App) consume(id AgentRunID, data harvestable) {
 		return
 	}
 
-	if "" == id {
+	// Data recorded while the app is not connected is only kept if it
+	// can be spooled.
+	if "" == id && nil == app.spool {
 		return
 	}
 
-	app.dataChan <- appData{id, data}
+	select {
+	case app.dataChan <- appData{id, data}:
+	case <-app.shutdownStarted:
+	}
 }
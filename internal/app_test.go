@@ -0,0 +1,599 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/api"
+)
+
+// harvestMockRoundTripper accepts connect and redirect calls and records the
+// commands of all subsequent data calls.
+type harvestMockRoundTripper struct {
+	sync.Mutex
+	cmds []string
+}
+
+func (m *harvestMockRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	cmd := r.URL.Query().Get("method")
+	switch cmd {
+	case cmdRedirect:
+		return makeResponse(200, redirectBody), nil
+	case cmdConnect:
+		return makeResponse(200, connectBody), nil
+	}
+	if nil != r.Body {
+		ioutil.ReadAll(r.Body)
+	}
+	m.Lock()
+	m.cmds = append(m.cmds, cmd)
+	m.Unlock()
+	return makeResponse(200, `{"return_value":null}`), nil
+}
+
+func (m *harvestMockRoundTripper) CancelRequest(req *http.Request) {}
+
+func (m *harvestMockRoundTripper) sent(cmd string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, c := range m.cmds {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+func testEnabledApp(t *testing.T, transport http.RoundTripper) *App {
+	return testEnabledAppConfig(t, transport, nil)
+}
+
+func testEnabledAppConfig(t *testing.T, transport http.RoundTripper, cfgfn func(*api.Config)) *App {
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	cfg.Utilization.DetectAWS = false
+	cfg.Utilization.DetectDocker = false
+	cfg.RuntimeSampler.Enabled = false
+	cfg.Transport = transport
+	if nil != cfgfn {
+		cfgfn(&cfg)
+	}
+	application, err := NewAppInternal(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return application.(*App)
+}
+
+func waitForRun(t *testing.T, app *App) {
+	if err := app.WaitForConnection(5 * time.Second); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForConnectionSuccess(t *testing.T) {
+	app := testEnabledApp(t, &harvestMockRoundTripper{})
+	defer app.Shutdown(time.Second)
+
+	if err := app.WaitForConnection(5 * time.Second); nil != err {
+		t.Fatal(err)
+	}
+	if run := app.getRun(); run.RunID != "my_agent_run_id" {
+		t.Error(run.RunID)
+	}
+}
+
+func TestWaitForConnectionLicenseException(t *testing.T) {
+	app := testEnabledApp(t, connectMockRoundTripper{
+		redirect: endpointResult{response: makeResponse(200, licenseBody)},
+	})
+	defer app.Shutdown(time.Second)
+
+	err := app.WaitForConnection(5 * time.Second)
+	if !isLicenseException(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForConnectionTimeout(t *testing.T) {
+	app := testEnabledApp(t, connectMockRoundTripper{
+		redirect: endpointResult{response: makeResponse(500, "")},
+	})
+	defer app.Shutdown(time.Second)
+
+	if err := app.WaitForConnection(10 * time.Millisecond); err != ErrConnectionTimeout {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForConnectionShutdown(t *testing.T) {
+	app := testEnabledApp(t, connectMockRoundTripper{
+		redirect: endpointResult{response: makeResponse(500, "")},
+	})
+	go app.Shutdown(time.Second)
+
+	start := time.Now()
+	if err := app.WaitForConnection(10 * time.Second); err != ErrApplicationShutdown {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("WaitForConnection did not return once shutdown started")
+	}
+}
+
+func TestWaitForConnectionDisabled(t *testing.T) {
+	cfg := api.NewConfig("my app", "")
+	cfg.Enabled = false
+	app, err := NewAppInternal(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := app.WaitForConnection(time.Second); nil != err {
+		t.Error(err)
+	}
+}
+
+func TestShutdownSendsFinalHarvest(t *testing.T) {
+	transport := &harvestMockRoundTripper{}
+	app := testEnabledApp(t, transport)
+	waitForRun(t, app)
+
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.End()
+	if err := app.RecordCustomEvent("myType", map[string]interface{}{"zip": 1}); nil != err {
+		t.Fatal(err)
+	}
+
+	app.Shutdown(5 * time.Second)
+
+	select {
+	case <-app.shutdownComplete:
+	default:
+		t.Fatal("shutdown not complete")
+	}
+	for _, cmd := range []string{cmdMetrics, cmdTxnEvents, cmdCustomEvents} {
+		if !transport.sent(cmd) {
+			t.Error("command not sent", cmd)
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	transport := &harvestMockRoundTripper{}
+	app := testEnabledApp(t, transport)
+	waitForRun(t, app)
+
+	// Block the processor goroutine from completing the final harvest.
+	app.harvestWait.Add(1)
+	defer app.harvestWait.Done()
+
+	start := time.Now()
+	app.Shutdown(10 * time.Millisecond)
+	if time.Since(start) > 5*time.Second {
+		t.Error("shutdown did not respect timeout")
+	}
+	select {
+	case <-app.shutdownComplete:
+		t.Error("shutdown should not be complete")
+	default:
+	}
+}
+
+func TestShutdownDisabledApp(t *testing.T) {
+	cfg := api.NewConfig("my app", "")
+	cfg.Enabled = false
+	application, err := NewAppInternal(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+	app := application.(*App)
+	app.Shutdown(time.Second)
+	app.Shutdown(time.Second)
+	if !app.isShutdown() {
+		t.Error("app not shutdown")
+	}
+}
+
+func TestNewAppRunEventHarvestConfig(t *testing.T) {
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	cfg.HarvestLimits.MaxCustomEvents = 120
+	reply := connectReplyDefaults()
+	js := `{
+		"event_harvest_config":{
+			"report_period_ms":10000,
+			"harvest_limits":{
+				"analytic_event_data":500,
+				"custom_event_data":1000,
+				"error_event_data":-1
+			}
+		}
+	}`
+	if err := json.Unmarshal([]byte(js), reply); nil != err {
+		t.Fatal(err)
+	}
+	run := newAppRun(&cfg, reply, "collector")
+	if run.eventHarvestPeriod != 10*time.Second {
+		t.Error(run.eventHarvestPeriod)
+	}
+	expect := defaultHarvestLimits
+	// The collector may lower limits.
+	expect.txnEvents = 500
+	// The collector may not exceed local limits.
+	expect.customEvents = 20
+	// Invalid limits are ignored.
+	expect.errorEvents = 16
+	expect.failedEventAttempts = 6 * failedEventsAttemptsLimit
+	if run.limits != expect {
+		t.Error(run.limits, expect)
+	}
+}
+
+func TestNewAppRunEventHarvestPeriodClamped(t *testing.T) {
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	reply := connectReplyDefaults()
+	for _, tc := range []struct {
+		ms     int
+		expect time.Duration
+	}{
+		{1000, cfg.EventHarvestPeriod},
+		{120 * 1000, harvestPeriod},
+	} {
+		ms := tc.ms
+		reply.EventData.ReportPeriodMs = &ms
+		if run := newAppRun(&cfg, reply, ""); run.eventHarvestPeriod != tc.expect {
+			t.Error(tc.ms, run.eventHarvestPeriod)
+		}
+	}
+}
+
+func TestNewAppRunWithoutEventHarvestConfig(t *testing.T) {
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	run := newAppRun(&cfg, connectReplyDefaults(), "")
+	if run.eventHarvestPeriod != cfg.EventHarvestPeriod {
+		t.Error(run.eventHarvestPeriod)
+	}
+	if expect := harvestLimitsFromConfig(&cfg, cfg.EventHarvestPeriod); run.limits != expect {
+		t.Error(run.limits, expect)
+	}
+}
+
+func TestEventHarvestCycle(t *testing.T) {
+	transport := &harvestMockRoundTripper{}
+	app := testEnabledAppConfig(t, transport, func(cfg *api.Config) {
+		cfg.EventHarvestPeriod = time.Second
+	})
+	defer app.Shutdown(time.Second)
+	waitForRun(t, app)
+
+	if err := app.RecordCustomEvent("myType", map[string]interface{}{"zip": 1}); nil != err {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !transport.sent(cmdCustomEvents) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !transport.sent(cmdCustomEvents) {
+		t.Error("events not sent")
+	}
+	if transport.sent(cmdMetrics) {
+		t.Error("metrics sent before the metric harvest")
+	}
+}
+
+func TestEventHarvestPeriodZero(t *testing.T) {
+	app := &App{}
+	app.setEventHarvestPeriod(time.Second)
+	if nil == app.eventHarvestChan || app.harvestTypes != harvestMetricsTraces {
+		t.Error(app.harvestTypes)
+	}
+	// Events harvested once a minute are sent with the metrics.
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	cfg.EventHarvestPeriod = 0
+	app.setEventHarvestPeriod(configEventHarvestPeriod(&cfg))
+	if nil != app.eventHarvestChan || nil != app.eventHarvestTicker ||
+		app.harvestTypes != harvestAll {
+		t.Error(app.harvestTypes)
+	}
+}
+
+func testPayloadApp(t *testing.T, transport http.RoundTripper, maxPayloadBytes int) *App {
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	cfg.Transport = transport
+	cfg.MaxPayloadBytes = maxPayloadBytes
+	app, err := NewTestApp(func(*ConnectReply) {}, cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return app.(*App)
+}
+
+func testCustomEvents(t *testing.T, num int, size int) *customEvents {
+	events := newCustomEvents(num)
+	for i := 0; i < num; i++ {
+		e, err := createCustomEvent("myType", map[string]interface{}{
+			"zip": strings.Repeat("x", size),
+			"zap": strings.Repeat("x", size),
+		}, time.Now())
+		if nil != err {
+			t.Fatal(err)
+		}
+		events.Add(e)
+	}
+	return events
+}
+
+// sentEvents returns the number of events in the payloads given.
+func sentEvents(t *testing.T, payloads []string) int {
+	num := 0
+	for _, p := range payloads {
+		var fields []json.RawMessage
+		if err := json.Unmarshal([]byte(p), &fields); nil != err || 3 != len(fields) {
+			t.Fatal(p, err)
+		}
+		var events []json.RawMessage
+		if err := json.Unmarshal(fields[2], &events); nil != err {
+			t.Fatal(p, err)
+		}
+		num += len(events)
+	}
+	return num
+}
+
+func payloadMetricCount(app *App, name string) float64 {
+	if m := app.testHarvest.metrics.metrics[metricID{Name: name}]; nil != m {
+		return m.data.countSatisfied
+	}
+	return 0
+}
+
+func TestHarvestPayloadTooLargeSplit(t *testing.T) {
+	transport := &offlineRoundTripper{maxPayload: 400, payloads: make(map[string][]string)}
+	app := testPayloadApp(t, transport, 0)
+	err := app.harvestPayload(cmdCustomEvents, testCustomEvents(t, 10, 30), time.Now(), app.getRun())
+	if nil != err {
+		t.Fatal(err)
+	}
+	sent := transport.sent(cmdCustomEvents)
+	if len(sent) < 2 || 10 != sentEvents(t, sent) {
+		t.Error(sent)
+	}
+	if n := payloadMetricCount(app, payloadSplitMetric(cmdCustomEvents)); n < 1 {
+		t.Error(n)
+	}
+	if n := payloadMetricCount(app, payloadDroppedMetric(cmdCustomEvents)); 0 != n {
+		t.Error(n)
+	}
+}
+
+func TestHarvestPayloadTooLargeDropped(t *testing.T) {
+	transport := &offlineRoundTripper{maxPayload: 400, payloads: make(map[string][]string)}
+	app := testPayloadApp(t, transport, 0)
+	err := app.harvestPayload(cmdCustomEvents, testCustomEvents(t, 1, 250), time.Now(), app.getRun())
+	if nil != err {
+		t.Fatal(err)
+	}
+	if sent := transport.sent(cmdCustomEvents); 0 != len(sent) {
+		t.Error(sent)
+	}
+	if n := payloadMetricCount(app, payloadDroppedMetric(cmdCustomEvents)); 1 != n {
+		t.Error(n)
+	}
+	// The dropped payload is not merged into the next harvest.
+	if n := app.testHarvest.customEvents.numSaved(); 0 != n {
+		t.Error(n)
+	}
+}
+
+func TestHarvestMaxPayloadBytes(t *testing.T) {
+	transport := &offlineRoundTripper{payloads: make(map[string][]string)}
+	app := testPayloadApp(t, transport, 400)
+	err := app.harvestPayload(cmdCustomEvents, testCustomEvents(t, 10, 30), time.Now(), app.getRun())
+	if nil != err {
+		t.Fatal(err)
+	}
+	sent := transport.sent(cmdCustomEvents)
+	for _, p := range sent {
+		if len(p) > 400 {
+			t.Error(len(p), p)
+		}
+	}
+	if len(sent) < 2 || 10 != sentEvents(t, sent) {
+		t.Error(sent)
+	}
+
+	// Metric payloads are split too.
+	mt := newMetricTable(100, time.Now())
+	for i := 0; i < 20; i++ {
+		mt.addSingleCount(strings.Repeat("x", 20)+strconv.Itoa(i), forced)
+	}
+	if err := app.harvestPayload(cmdMetrics, mt, time.Now(), app.getRun()); nil != err {
+		t.Fatal(err)
+	}
+	if sent := transport.sent(cmdMetrics); len(sent) < 2 {
+		t.Error(sent)
+	}
+	if n := payloadMetricCount(app, payloadSplitMetric(cmdMetrics)); n < 1 {
+		t.Error(n)
+	}
+}
+
+func TestHarvestMaxPayloadBytesUnsplittable(t *testing.T) {
+	transport := &offlineRoundTripper{payloads: make(map[string][]string)}
+	app := testPayloadApp(t, transport, 100)
+	errs := newHarvestErrors(maxHarvestErrors)
+	addTxnError(errs, &txnError{
+		when:  time.Now(),
+		msg:   strings.Repeat("x", 200),
+		klass: "klass",
+		stack: getStackTrace(0),
+	}, "txnName", "requestURI", nil)
+	if err := app.harvestPayload(cmdErrorData, errs, time.Now(), app.getRun()); nil != err {
+		t.Fatal(err)
+	}
+	// Payloads which cannot be split are sent regardless of their size.
+	if sent := transport.sent(cmdErrorData); 1 != len(sent) {
+		t.Error(sent)
+	}
+	if n := payloadMetricCount(app, payloadDroppedMetric(cmdErrorData)); 0 != n {
+		t.Error(n)
+	}
+}
+
+func TestConnectBackoff(t *testing.T) {
+	for failures, scheduled := range map[int]time.Duration{
+		1:  15 * time.Second,
+		2:  30 * time.Second,
+		3:  time.Minute,
+		4:  2 * time.Minute,
+		5:  5 * time.Minute,
+		20: 5 * time.Minute,
+	} {
+		for i := 0; i < 100; i++ {
+			if delay := connectBackoff(failures, nil); delay < scheduled || delay > scheduled+scheduled/2 {
+				t.Fatal(failures, delay)
+			}
+		}
+	}
+
+	requested := unexpectedStatusCodeErr{code: 503, retryAfter: 10 * time.Minute}
+	if delay := connectBackoff(1, requested); delay != 10*time.Minute {
+		t.Error(delay)
+	}
+	// A shorter requested delay does not shorten the backoff.
+	requested.retryAfter = time.Second
+	if delay := connectBackoff(2, requested); delay < 15*time.Second {
+		t.Error(delay)
+	}
+}
+
+type retryAfterRoundTripper struct{}
+
+func (m retryAfterRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp := makeResponse(429, "")
+	resp.Header = http.Header{"Retry-After": []string{"30"}}
+	return resp, nil
+}
+
+func (m retryAfterRoundTripper) CancelRequest(req *http.Request) {}
+
+func TestHarvestRetryAfter(t *testing.T) {
+	app := testPayloadApp(t, retryAfterRoundTripper{}, 0)
+	now := time.Now()
+	if app.inHarvestBackoff(now) {
+		t.Error("unexpected backoff")
+	}
+	err := app.harvestPayload(cmdCustomEvents, testCustomEvents(t, 1, 10), now, app.getRun())
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !app.inHarvestBackoff(now.Add(20*time.Second)) || app.inHarvestBackoff(now.Add(time.Minute)) {
+		t.Error(app.harvestBackoff)
+	}
+	// The payload is kept for the next harvest.
+	if n := app.testHarvest.customEvents.numSaved(); 1 != n {
+		t.Error(n)
+	}
+}
+
+func TestHarvestStatusCodeActions(t *testing.T) {
+	testcases := []struct {
+		code       int
+		reconnect  bool
+		disconnect bool
+	}{
+		{code: 401, reconnect: true},
+		{code: 409, reconnect: true},
+		{code: 410, disconnect: true},
+		{code: 500},
+		{code: 400},
+	}
+	for _, tc := range testcases {
+		var lock sync.Mutex
+		connects := 0
+		dataCalls := 0
+		srv, transport := newFakeCollector(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("method") {
+			case cmdRedirect:
+				w.Write([]byte(redirectBody))
+			case cmdConnect:
+				lock.Lock()
+				connects++
+				lock.Unlock()
+				w.Write([]byte(connectBody))
+			default:
+				lock.Lock()
+				dataCalls++
+				lock.Unlock()
+				w.WriteHeader(tc.code)
+			}
+		})
+		app := testEnabledAppConfig(t, transport, func(cfg *api.Config) {
+			cfg.EventHarvestPeriod = time.Second
+		})
+		waitForRun(t, app)
+		app.RecordCustomEvent("myType", map[string]interface{}{"zip": 1})
+
+		deadline := time.Now().Add(3 * time.Second)
+		var numConnects int
+		var err error
+		for time.Now().Before(deadline) {
+			lock.Lock()
+			numConnects = connects
+			numDataCalls := dataCalls
+			lock.Unlock()
+			_, err = app.getState()
+			if numConnects > 1 || nil != err {
+				break
+			}
+			if numDataCalls > 0 && !tc.reconnect && !tc.disconnect {
+				// Allow time for an unexpected reconnect.
+				time.Sleep(100 * time.Millisecond)
+				lock.Lock()
+				numConnects = connects
+				lock.Unlock()
+				_, err = app.getState()
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if tc.reconnect != (numConnects > 1) {
+			t.Error(tc.code, "connects", numConnects)
+		}
+		if tc.disconnect != (nil != err) || (tc.disconnect && !isDisconnect(err)) {
+			t.Error(tc.code, "error", err)
+		}
+		app.Shutdown(time.Second)
+		srv.Close()
+	}
+}
+
+func TestHarvestPayloadSizeMetrics(t *testing.T) {
+	transport := &offlineRoundTripper{payloads: make(map[string][]string)}
+	app := testPayloadApp(t, transport, 0)
+	events := testCustomEvents(t, 10, 30)
+	data, err := events.Data("", time.Now())
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := app.harvestPayload(cmdCustomEvents, events, time.Now(), app.getRun()); nil != err {
+		t.Fatal(err)
+	}
+	uncompressed := app.testHarvest.metrics.metrics[metricID{Name: payloadUncompressedMetric(cmdCustomEvents)}]
+	compressed := app.testHarvest.metrics.metrics[metricID{Name: payloadCompressedMetric(cmdCustomEvents)}]
+	if nil == uncompressed || nil == compressed {
+		t.Fatal(uncompressed, compressed)
+	}
+	if uncompressed.data.countSatisfied != 1 || uncompressed.data.totalTolerated != float64(len(data)) {
+		t.Error(uncompressed.data)
+	}
+	if compressed.data.countSatisfied != 1 || compressed.data.totalTolerated <= 0 ||
+		compressed.data.totalTolerated >= uncompressed.data.totalTolerated {
+		t.Error(compressed.data)
+	}
+}
@@ -145,6 +145,7 @@ var (
 		attributes:        api.AttributeDestinationConfig{Enabled: true},
 		errorCollector:    api.AttributeDestinationConfig{Enabled: true},
 		transactionEvents: api.AttributeDestinationConfig{Enabled: true},
+		transactionTracer: api.AttributeDestinationConfig{Enabled: true},
 	}
 )
 
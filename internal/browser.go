@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"time"
+)
+
+// https://source.datanerd.us/agents/agent-specs/blob/master/Browser-Monitoring-PORTED.md
+
+const (
+	browserScriptStart = `<script type="text/javascript">`
+	browserScriptEnd   = `</script>`
+	browserInfoPrefix  = `window.NREUM||(NREUM={});NREUM.info=`
+
+	// browserObfuscationKeyLength is the number of bytes of the license
+	// key used to obfuscate the footer's transaction name and attributes.
+	browserObfuscationKeyLength = 13
+
+	// browserSearchLimit bounds the amount of a response searched for
+	// the header insertion location.
+	browserSearchLimit = 64 * 1024
+)
+
+// browserInfo is the configuration of the JavaScript agent contained in the
+// browser timing footer.
+type browserInfo struct {
+	Beacon          string `json:"beacon"`
+	LicenseKey      string `json:"licenseKey"`
+	ApplicationID   string `json:"applicationID"`
+	TransactionName string `json:"transactionName"`
+	QueueTimeMillis int64  `json:"queueTime"`
+	AppTimeMillis   int64  `json:"applicationTime"`
+	Attributes      string `json:"atts"`
+	ErrorBeacon     string `json:"errorBeacon"`
+	Agent           string `json:"agent"`
+}
+
+func browserObfuscationKey(license string) string {
+	if len(license) < browserObfuscationKeyLength {
+		return license
+	}
+	return license[0:browserObfuscationKeyLength]
+}
+
+// browserAttributes returns the attributes destined for the browser in the
+// format expected by the JavaScript agent.  An empty string is returned if
+// there are no such attributes.
+func browserAttributes(a *attributes) []byte {
+	user := userAttributesStringJSON(a, destBrowser)
+	agent := agentAttributesStringJSON(a, destBrowser)
+	if "{}" == string(user) && "{}" == string(agent) {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	if "{}" != string(user) {
+		w.addKey("u")
+		buf.WriteString(string(user))
+	}
+	if "{}" != string(agent) {
+		w.addKey("a")
+		buf.WriteString(string(agent))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+func newBrowserInfo(reply *ConnectReply, license string, txnName string, queuing time.Duration, appTime time.Duration, a *attributes) *browserInfo {
+	key := browserObfuscationKey(license)
+	info := &browserInfo{
+		Beacon:          reply.Beacon,
+		LicenseKey:      reply.BrowserKey,
+		ApplicationID:   reply.AppID,
+		TransactionName: catObfuscate([]byte(txnName), key),
+		QueueTimeMillis: durationToIntMilliseconds(queuing),
+		AppTimeMillis:   durationToIntMilliseconds(appTime),
+		ErrorBeacon:     reply.ErrorBeacon,
+		Agent:           reply.JSAgentFile,
+	}
+	if atts := browserAttributes(a); nil != atts {
+		info.Attributes = catObfuscate(atts, key)
+	}
+	return info
+}
+
+func browserTimingHeader(reply *ConnectReply) string {
+	return browserScriptStart + reply.AgentLoader + browserScriptEnd
+}
+
+func browserTimingFooter(info *browserInfo) string {
+	// json.Marshal escapes '<' and '>', so the values cannot end the
+	// script early.
+	js, err := json.Marshal(info)
+	if nil != err {
+		return ""
+	}
+	return browserScriptStart + browserInfoPrefix + string(js) + browserScriptEnd
+}
+
+var (
+	browserBodyRegex        = regexp.MustCompile(`(?i)<body`)
+	browserHeadRegex        = regexp.MustCompile(`(?i)<head[^>]*>`)
+	browserXUAMetaRegex     = regexp.MustCompile(`(?i)<\s*meta[^>]+http-equiv\s*=\s*['"]X-UA-Compatible['"][^>]*>`)
+	browserCharsetMetaRegex = regexp.MustCompile(`(?i)<\s*meta[^>]+charset\s*=[^>]*>`)
+	browserAttachmentRegex  = regexp.MustCompile(`(?i)<\s*meta[^>]+http-equiv\s*=\s*['"]content-disposition['"][^>]*content\s*=\s*['"]\s*attachment`)
+	browserBodyCloseRegex   = regexp.MustCompile(`(?i)</body>`)
+)
+
+// browserHeaderLocation returns the index of a page at which the browser
+// timing header is inserted, or -1 if no location is found:  The header is
+// placed after the last of the first X-UA-Compatible and charset meta tags,
+// otherwise after the head tag, otherwise before the body tag.  The body tag is
+// required so that the whole head is searched.
+func browserHeaderLocation(page []byte) int {
+	search := page
+	if len(search) > browserSearchLimit {
+		search = search[0:browserSearchLimit]
+	}
+	body := browserBodyRegex.FindIndex(search)
+	if nil == body {
+		return -1
+	}
+	head := page[0:body[0]]
+	if browserAttachmentRegex.Match(head) {
+		return -1
+	}
+	idx := -1
+	if loc := browserXUAMetaRegex.FindIndex(head); nil != loc {
+		idx = loc[1]
+	}
+	if loc := browserCharsetMetaRegex.FindIndex(head); nil != loc && loc[1] > idx {
+		idx = loc[1]
+	}
+	if idx >= 0 {
+		return idx
+	}
+	if loc := browserHeadRegex.FindIndex(head); nil != loc {
+		return loc[1]
+	}
+	return body[0]
+}
+
+// browserFooterLocation returns the index of the last closing body tag of a
+// page, or -1 if there is none.
+func browserFooterLocation(page []byte) int {
+	locs := browserBodyCloseRegex.FindAllIndex(page, -1)
+	if 0 == len(locs) {
+		return -1
+	}
+	return locs[len(locs)-1][0]
+}
+
+func insertAt(page []byte, idx int, snippet string) []byte {
+	out := make([]byte, 0, len(page)+len(snippet))
+	out = append(out, page[0:idx]...)
+	out = append(out, snippet...)
+	return append(out, page[idx:]...)
+}
+
+// InsertBrowserTiming adds the browser timing header and footer returned by
+// the functions given to an HTML page.  The footer function is only called if
+// the header has been inserted.  The page is returned unchanged if the
+// locations cannot be found.
+func InsertBrowserTiming(page []byte, header func() string, footer func() string) []byte {
+	headerIdx := browserHeaderLocation(page)
+	if headerIdx < 0 {
+		return page
+	}
+	h := header()
+	if "" == h {
+		return page
+	}
+	page = insertAt(page, headerIdx, h)
+	if footerIdx := browserFooterLocation(page[headerIdx+len(h):]); footerIdx >= 0 {
+		if f := footer(); "" != f {
+			page = insertAt(page, headerIdx+len(h)+footerIdx, f)
+		}
+	}
+	return page
+}
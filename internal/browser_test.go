@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/internal/crossagent"
+)
+
+func TestCrossAgentRUMClientConfig(t *testing.T) {
+	var tcs []struct {
+		Name              string                 `json:"testname"`
+		AppTimeMillis     int64                  `json:"apptime_milliseconds"`
+		QueueTimeMillis   int64                  `json:"queuetime_milliseconds"`
+		AttributesEnabled bool                   `json:"browser_monitoring.attributes.enabled"`
+		TxnName           string                 `json:"transaction_name"`
+		License           string                 `json:"license_key"`
+		ConnectReply      ConnectReply           `json:"connect_reply"`
+		UserAttributes    map[string]interface{} `json:"user_attributes"`
+		Expected          map[string]interface{} `json:"expected"`
+	}
+
+	err := crossagent.ReadJSON("rum_client_config.json", &tcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tcs {
+		input := sampleAttributeConfigInput
+		input.browserMonitoring.Enabled = tc.AttributesEnabled
+		attrs := newAttributes(createAttributeConfig(input))
+		for key, val := range tc.UserAttributes {
+			addUserAttribute(attrs, key, val, destAll)
+		}
+
+		info := newBrowserInfo(&tc.ConnectReply, tc.License, tc.TxnName,
+			time.Duration(tc.QueueTimeMillis)*time.Millisecond,
+			time.Duration(tc.AppTimeMillis)*time.Millisecond, attrs)
+		js, err := json.Marshal(info)
+		if nil != err {
+			t.Fatal(tc.Name, err)
+		}
+		var actual map[string]interface{}
+		if err := json.Unmarshal(js, &actual); nil != err {
+			t.Fatal(tc.Name, err)
+		}
+		if !reflect.DeepEqual(actual, tc.Expected) {
+			t.Error(tc.Name, actual, tc.Expected)
+		}
+	}
+}
+
+func testBrowserLocations(t *testing.T, dir string, marker string, location func([]byte) int) {
+	files, err := crossagent.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		content, err := crossagent.ReadFile(filepath.Join(dir, name))
+		if nil != err {
+			t.Fatal(name, err)
+		}
+		expect := bytes.Index(content, []byte(marker))
+		if expect < 0 {
+			// Some files have no expected location.
+			continue
+		}
+		page := bytes.Replace(content, []byte(marker), nil, 1)
+		if idx := location(page); idx != expect {
+			t.Error(name, idx, expect)
+		}
+	}
+}
+
+func TestCrossAgentRUMLoaderInsertionLocation(t *testing.T) {
+	testBrowserLocations(t, "rum_loader_insertion_location",
+		"EXPECTED_RUM_LOADER_LOCATION", browserHeaderLocation)
+}
+
+func TestCrossAgentRUMFooterInsertionLocation(t *testing.T) {
+	testBrowserLocations(t, "rum_footer_insertion_location",
+		"EXPECTED_RUM_FOOTER_LOCATION", browserFooterLocation)
+}
+
+func TestBrowserHeaderLocationMissing(t *testing.T) {
+	for _, page := range []string{
+		"",
+		"<html><head></head></html>",
+		`<html><head><meta http-equiv="Content-Disposition" content="attachment; filename=x.html"></head><body></body></html>`,
+		`<html><head></head>` + strings.Repeat(" ", browserSearchLimit) + `<body></body></html>`,
+	} {
+		if idx := browserHeaderLocation([]byte(page)); -1 != idx {
+			t.Error(page, idx)
+		}
+	}
+}
+
+func TestInsertBrowserTiming(t *testing.T) {
+	page := []byte(`<html><head><title>hi</title></head><body>hello</body></html>`)
+	header := func() string { return "HEADER" }
+	footer := func() string { return "FOOTER" }
+	out := InsertBrowserTiming(page, header, footer)
+	if string(out) != `<html><head>HEADER<title>hi</title></head><body>helloFOOTER</body></html>` {
+		t.Error(string(out))
+	}
+
+	// The footer is not inserted without the header.
+	out = InsertBrowserTiming(page, func() string { return "" }, footer)
+	if string(out) != string(page) {
+		t.Error(string(out))
+	}
+
+	noBody := []byte(`<html><head></head></html>`)
+	out = InsertBrowserTiming(noBody, header, footer)
+	if string(out) != string(noBody) {
+		t.Error(string(out))
+	}
+}
+
+func TestBrowserTimingFooterEscaping(t *testing.T) {
+	footer := browserTimingFooter(&browserInfo{Beacon: "</script><script>alert(1)"})
+	if strings.Count(footer, "</script>") != 1 || !strings.HasSuffix(footer, browserScriptEnd) {
+		t.Error(footer)
+	}
+}
+
+func TestBrowserAttributesAgentOnly(t *testing.T) {
+	input := sampleAttributeConfigInput
+	input.browserMonitoring = api.AttributeDestinationConfig{
+		Enabled: true,
+		Include: []string{"request.method"},
+	}
+	attrs := newAttributes(createAttributeConfig(input))
+	attrs.agent.RequestMethod = "GET"
+	if js := string(browserAttributes(attrs)); js != `{"a":{"request.method":"GET"}}` {
+		t.Error(js)
+	}
+	attrs.agent.RequestMethod = ""
+	if js := browserAttributes(attrs); nil != js {
+		t.Error(string(js))
+	}
+}
@@ -0,0 +1,419 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newrelic/go-agent/internal/jsonx"
+)
+
+// https://source.datanerd.us/agents/agent-specs/blob/master/Cross-Application-Tracing-PORTED.md
+
+const (
+	catIDHeader          = "X-NewRelic-ID"
+	catTransactionHeader = "X-NewRelic-Transaction"
+	catAppDataHeader     = "X-NewRelic-App-Data"
+)
+
+var (
+	errCATUntrustedAccount = errors.New("cross process id is not from a trusted account")
+	errCATMalformedID      = errors.New("malformed cross process id")
+	errCATMalformedPayload = errors.New("malformed cross application tracing payload")
+)
+
+// catObfuscate encodes a header value by xoring it with the encoding key and
+// base64 encoding the result.
+func catObfuscate(input []byte, key string) string {
+	if "" == key {
+		return ""
+	}
+	out := make([]byte, len(input))
+	for i, b := range input {
+		out[i] = b ^ key[i%len(key)]
+	}
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+// catDeobfuscate reverses catObfuscate.
+func catDeobfuscate(input string, key string) ([]byte, error) {
+	if "" == key {
+		return nil, errors.New("missing encoding key")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(input)
+	if nil != err {
+		return nil, err
+	}
+	for i := range decoded {
+		decoded[i] ^= key[i%len(key)]
+	}
+	return decoded, nil
+}
+
+// catAccountID returns the account id portion of a cross process id, which has
+// the form "account#application".
+func catAccountID(crossProcessID string) (int, error) {
+	parts := strings.Split(crossProcessID, "#")
+	if 2 != len(parts) {
+		return 0, errCATMalformedID
+	}
+	account, err := strconv.Atoi(parts[0])
+	if nil != err {
+		return 0, errCATMalformedID
+	}
+	return account, nil
+}
+
+func catTrusted(reply *ConnectReply, crossProcessID string) error {
+	account, err := catAccountID(crossProcessID)
+	if nil != err {
+		return err
+	}
+	for _, trusted := range reply.TrustedAccounts {
+		if account == trusted {
+			return nil
+		}
+	}
+	return errCATUntrustedAccount
+}
+
+// catPathHash identifies a transaction's position within a chain of cross
+// application calls.
+func catPathHash(appName, txnName string, referringPathHash string) string {
+	var ref uint32
+	if "" != referringPathHash {
+		if x, err := strconv.ParseUint(referringPathHash, 16, 32); nil == err {
+			ref = uint32(x)
+		}
+	}
+	rotated := (ref << 1) | (ref >> 31)
+	sum := md5.Sum([]byte(appName + ";" + txnName))
+	hash := rotated ^ binary.BigEndian.Uint32(sum[12:16])
+	return fmt.Sprintf("%08x", hash)
+}
+
+// catPrimaryAppName returns the application name used in path hashes: When
+// multiple application names are configured, only the first is used.
+func catPrimaryAppName(appName string) string {
+	if idx := strings.Index(appName, ";"); idx >= 0 {
+		return appName[0:idx]
+	}
+	return appName
+}
+
+// catTxnPayload is the content of the X-NewRelic-Transaction header:
+// [guid, recordTxnTrace, tripID, pathHash].
+type catTxnPayload struct {
+	guid     string
+	tripID   string
+	pathHash string
+}
+
+// optionalString unmarshals a string field of a header payload which may be
+// missing or null.
+func optionalString(fields []json.RawMessage, i int) (string, error) {
+	if i >= len(fields) {
+		return "", nil
+	}
+	var s *string
+	if err := json.Unmarshal(fields[i], &s); nil != err {
+		return "", errCATMalformedPayload
+	}
+	if nil == s {
+		return "", nil
+	}
+	return *s, nil
+}
+
+func parseCATTxnPayload(data []byte) (*catTxnPayload, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); nil != err {
+		return nil, errCATMalformedPayload
+	}
+	if len(fields) < 1 {
+		return nil, errCATMalformedPayload
+	}
+	var guid string
+	if err := json.Unmarshal(fields[0], &guid); nil != err {
+		return nil, errCATMalformedPayload
+	}
+	tripID, err := optionalString(fields, 2)
+	if nil != err {
+		return nil, err
+	}
+	pathHash, err := optionalString(fields, 3)
+	if nil != err {
+		return nil, err
+	}
+	return &catTxnPayload{
+		guid:     guid,
+		tripID:   tripID,
+		pathHash: pathHash,
+	}, nil
+}
+
+func (p *catTxnPayload) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('[')
+	jsonx.AppendString(buf, p.guid)
+	buf.WriteString(`,false,`)
+	jsonx.AppendString(buf, p.tripID)
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, p.pathHash)
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// catAppData is the content of the X-NewRelic-App-Data response header:
+// [crossProcessID, txnName, queueSeconds, responseSeconds, contentLength,
+// guid, recordTxnTrace].
+type catAppData struct {
+	crossProcessID string
+	txnName        string
+	queuing        time.Duration
+	response       time.Duration
+	contentLength  int64
+	guid           string
+}
+
+func parseCATAppData(data []byte) (*catAppData, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); nil != err {
+		return nil, errCATMalformedPayload
+	}
+	if len(fields) < 2 {
+		return nil, errCATMalformedPayload
+	}
+	appData := &catAppData{}
+	if err := json.Unmarshal(fields[0], &appData.crossProcessID); nil != err {
+		return nil, errCATMalformedPayload
+	}
+	if err := json.Unmarshal(fields[1], &appData.txnName); nil != err {
+		return nil, errCATMalformedPayload
+	}
+	guid, err := optionalString(fields, 5)
+	if nil != err {
+		return nil, err
+	}
+	appData.guid = guid
+	return appData, nil
+}
+
+// catAppDataFromHeader deobfuscates and validates the X-NewRelic-App-Data
+// header of an external response.  nil is returned if the header is absent.
+func catAppDataFromHeader(reply *ConnectReply, header string) (*catAppData, error) {
+	if "" == header {
+		return nil, nil
+	}
+	data, err := catDeobfuscate(header, reply.EncodingKey)
+	if nil != err {
+		return nil, err
+	}
+	appData, err := parseCATAppData(data)
+	if nil != err {
+		return nil, err
+	}
+	if err := catTrusted(reply, appData.crossProcessID); nil != err {
+		return nil, err
+	}
+	return appData, nil
+}
+
+func (d *catAppData) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('[')
+	jsonx.AppendString(buf, d.crossProcessID)
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, d.txnName)
+	buf.WriteByte(',')
+	jsonx.AppendFloat(buf, d.queuing.Seconds())
+	buf.WriteByte(',')
+	jsonx.AppendFloat(buf, d.response.Seconds())
+	buf.WriteByte(',')
+	jsonx.AppendInt(buf, d.contentLength)
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, d.guid)
+	buf.WriteString(`,false]`)
+	return buf.Bytes(), nil
+}
+
+// newCATGUID creates the random 16 hex digit identifier of a transaction.
+func newCATGUID() string {
+	return fmt.Sprintf("%08x%08x", rand.Uint32(), rand.Uint32())
+}
+
+// txnCrossProcess holds a transaction's cross application tracing state.
+type txnCrossProcess struct {
+	// guid is created lazily since most transactions are not involved in
+	// cross application tracing.
+	guid string
+
+	// Inbound fields are populated if the transaction's request contains
+	// valid headers from a trusted account.
+	inbound              bool
+	clientCrossProcessID string
+	referringGUID        string
+	referringPathHash    string
+	tripID               string
+
+	outbound            bool
+	alternatePathHashes map[string]struct{}
+}
+
+func (cp *txnCrossProcess) getGUID() string {
+	if "" == cp.guid {
+		cp.guid = newCATGUID()
+	}
+	return cp.guid
+}
+
+func (cp *txnCrossProcess) used() bool {
+	return cp.inbound || cp.outbound
+}
+
+func (cp *txnCrossProcess) getTripID() string {
+	if "" != cp.tripID {
+		return cp.tripID
+	}
+	return cp.getGUID()
+}
+
+// handleInboundPayload records the deobfuscated X-NewRelic-Transaction header
+// of a request whose X-NewRelic-ID header has already been validated.
+func (cp *txnCrossProcess) handleInboundPayload(clientCrossProcessID string, data []byte) error {
+	payload, err := parseCATTxnPayload(data)
+	if nil != err {
+		return err
+	}
+	cp.inbound = true
+	cp.clientCrossProcessID = clientCrossProcessID
+	cp.referringGUID = payload.guid
+	cp.tripID = payload.tripID
+	cp.referringPathHash = payload.pathHash
+	return nil
+}
+
+// handleInbound validates and records the cross application tracing headers
+// of an incoming request.
+func (cp *txnCrossProcess) handleInbound(reply *ConnectReply, idHeader, txnHeader string) error {
+	id, err := catDeobfuscate(idHeader, reply.EncodingKey)
+	if nil != err {
+		return err
+	}
+	if err := catTrusted(reply, string(id)); nil != err {
+		return err
+	}
+	data, err := catDeobfuscate(txnHeader, reply.EncodingKey)
+	if nil != err {
+		return err
+	}
+	return cp.handleInboundPayload(string(id), data)
+}
+
+func (cp *txnCrossProcess) pathHash(appName, txnName string) string {
+	return catPathHash(catPrimaryAppName(appName), txnName, cp.referringPathHash)
+}
+
+// outboundPayload creates the X-NewRelic-Transaction payload of an external
+// call made while the transaction has the name given.
+func (cp *txnCrossProcess) outboundPayload(appName, txnName string) *catTxnPayload {
+	cp.outbound = true
+	hash := cp.pathHash(appName, txnName)
+	if nil == cp.alternatePathHashes {
+		cp.alternatePathHashes = make(map[string]struct{})
+	}
+	if len(cp.alternatePathHashes) < maxAlternatePathHashes {
+		cp.alternatePathHashes[hash] = struct{}{}
+	}
+	return &catTxnPayload{
+		guid:     cp.getGUID(),
+		tripID:   cp.getTripID(),
+		pathHash: hash,
+	}
+}
+
+// catIntrinsics are added to events and traces of transactions involved in
+// cross application tracing.
+type catIntrinsics struct {
+	guid                 string
+	tripID               string
+	pathHash             string
+	referringGUID        string
+	referringPathHash    string
+	alternatePathHashes  string
+	clientCrossProcessID string
+}
+
+func (cp *txnCrossProcess) intrinsics(appName, finalName string) *catIntrinsics {
+	if !cp.used() {
+		return nil
+	}
+	hash := cp.pathHash(appName, finalName)
+	alternates := make([]string, 0, len(cp.alternatePathHashes))
+	for h := range cp.alternatePathHashes {
+		if h != hash {
+			alternates = append(alternates, h)
+		}
+	}
+	sort.Strings(alternates)
+	return &catIntrinsics{
+		guid:                 cp.getGUID(),
+		tripID:               cp.getTripID(),
+		pathHash:             hash,
+		referringGUID:        cp.referringGUID,
+		referringPathHash:    cp.referringPathHash,
+		alternatePathHashes:  strings.Join(alternates, ","),
+		clientCrossProcessID: cp.clientCrossProcessID,
+	}
+}
+
+// writeTxnEventFields adds the intrinsics to a transaction event.  The
+// buffer must be within an object which already has fields.
+func (c *catIntrinsics) writeTxnEventFields(buf *bytes.Buffer) {
+	w := jsonFieldsWriter{buf: buf, needsComma: true}
+	w.stringField("nr.guid", c.guid)
+	w.stringField("nr.tripId", c.tripID)
+	w.stringField("nr.pathHash", c.pathHash)
+	if "" != c.referringGUID {
+		w.stringField("nr.referringTransactionGuid", c.referringGUID)
+	}
+	if "" != c.referringPathHash {
+		w.stringField("nr.referringPathHash", c.referringPathHash)
+	}
+	if "" != c.alternatePathHashes {
+		w.stringField("nr.alternatePathHashes", c.alternatePathHashes)
+	}
+}
+
+// writeErrorEventFields adds the intrinsics to an error event.
+func (c *catIntrinsics) writeErrorEventFields(buf *bytes.Buffer) {
+	w := jsonFieldsWriter{buf: buf, needsComma: true}
+	w.stringField("nr.transactionGuid", c.guid)
+	if "" != c.referringGUID {
+		w.stringField("nr.referringTransactionGuid", c.referringGUID)
+	}
+}
+
+// writeTraceIntrinsics adds the intrinsics to a transaction trace.
+func (c *catIntrinsics) writeTraceIntrinsics(w *jsonFieldsWriter) {
+	if nil == c {
+		return
+	}
+	w.stringField("trip_id", c.tripID)
+	w.stringField("path_hash", c.pathHash)
+	if "" != c.referringGUID {
+		w.stringField("referring_transaction_guid", c.referringGUID)
+	}
+	if "" != c.clientCrossProcessID {
+		w.stringField("client_cross_process_id", c.clientCrossProcessID)
+	}
+}
@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/internal/crossagent"
+)
+
+func TestCATObfuscation(t *testing.T) {
+	key := "d67afc830dab717fd163bfcb0b8b88423e9a1a3b"
+	input := `["b854df4feb2b1f06",false,"7e249074f277923d","5d2957be"]`
+	obfuscated := catObfuscate([]byte(input), key)
+	if obfuscated == input || "" == obfuscated {
+		t.Fatal(obfuscated)
+	}
+	out, err := catDeobfuscate(obfuscated, key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if string(out) != input {
+		t.Error(string(out))
+	}
+	if _, err := catDeobfuscate("not base64!", key); nil == err {
+		t.Error("expected error for invalid base64")
+	}
+	if _, err := catDeobfuscate(obfuscated, ""); nil == err {
+		t.Error("expected error for missing key")
+	}
+}
+
+func TestCATTrusted(t *testing.T) {
+	reply := &ConnectReply{TrustedAccounts: []int{123, 456}}
+	if err := catTrusted(reply, "456#789"); nil != err {
+		t.Error(err)
+	}
+	if err := catTrusted(reply, "789#456"); err != errCATUntrustedAccount {
+		t.Error(err)
+	}
+	for _, id := range []string{"", "456", "456#789#1", "abc#789"} {
+		if err := catTrusted(reply, id); err != errCATMalformedID {
+			t.Error(id, err)
+		}
+	}
+}
+
+func TestCrossAgentCATPathHash(t *testing.T) {
+	var tcs []struct {
+		Name              string  `json:"name"`
+		ReferringPathHash *string `json:"referringPathHash"`
+		ApplicationName   string  `json:"applicationName"`
+		TransactionName   string  `json:"transactionName"`
+		ExpectedPathHash  string  `json:"expectedPathHash"`
+	}
+
+	err := crossagent.ReadJSON("cat/path_hashing.json", &tcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tcs {
+		referring := ""
+		if nil != tc.ReferringPathHash {
+			referring = *tc.ReferringPathHash
+		}
+		hash := catPathHash(tc.ApplicationName, tc.TransactionName, referring)
+		if hash != tc.ExpectedPathHash {
+			t.Error(tc.Name, hash, tc.ExpectedPathHash)
+		}
+	}
+}
+
+func TestCrossAgentCATMap(t *testing.T) {
+	var tcs []struct {
+		Name             string          `json:"name"`
+		AppName          string          `json:"appName"`
+		TransactionName  string          `json:"transactionName"`
+		TransactionGUID  string          `json:"transactionGuid"`
+		InboundPayload   json.RawMessage `json:"inboundPayload"`
+		OutboundRequests []struct {
+			OutboundTxnName         string          `json:"outboundTxnName"`
+			ExpectedOutboundPayload json.RawMessage `json:"expectedOutboundPayload"`
+		} `json:"outboundRequests"`
+		ExpectedIntrinsicFields    map[string]string `json:"expectedIntrinsicFields"`
+		NonExpectedIntrinsicFields []string          `json:"nonExpectedIntrinsicFields"`
+	}
+
+	err := crossagent.ReadJSON("cat/cat_map.json", &tcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tcs {
+		cp := txnCrossProcess{guid: tc.TransactionGUID}
+		if "null" != string(tc.InboundPayload) {
+			// Malformed payloads are ignored.
+			cp.handleInboundPayload("123#456", tc.InboundPayload)
+		}
+		for _, req := range tc.OutboundRequests {
+			js, err := cp.outboundPayload(tc.AppName, req.OutboundTxnName).MarshalJSON()
+			if nil != err {
+				t.Fatal(tc.Name, err)
+			}
+			var actual, expected interface{}
+			json.Unmarshal(js, &actual)
+			json.Unmarshal(req.ExpectedOutboundPayload, &expected)
+			if !reflect.DeepEqual(actual, expected) {
+				t.Error(tc.Name, string(js), string(req.ExpectedOutboundPayload))
+			}
+		}
+
+		event := &txnEvent{
+			Name:  tc.TransactionName,
+			zone:  apdexNone,
+			attrs: nil,
+			cat:   cp.intrinsics(tc.AppName, tc.TransactionName),
+		}
+		js, err := event.MarshalJSON()
+		if nil != err {
+			t.Fatal(tc.Name, err)
+		}
+		var fields []map[string]interface{}
+		if err := json.Unmarshal(js, &fields); nil != err {
+			t.Fatal(tc.Name, string(js), err)
+		}
+		intrinsics := fields[0]
+		for key, val := range tc.ExpectedIntrinsicFields {
+			if actual, ok := intrinsics[key]; !ok || actual != val {
+				t.Error(tc.Name, key, actual, val)
+			}
+		}
+		for _, key := range tc.NonExpectedIntrinsicFields {
+			if actual, ok := intrinsics[key]; ok {
+				t.Error(tc.Name, key, actual)
+			}
+		}
+	}
+}
+
+func TestParseCATTxnPayload(t *testing.T) {
+	tcs := []struct {
+		input string
+		valid bool
+	}{
+		{`["guid",false,"trip","hash"]`, true},
+		{`["guid"]`, true},
+		{`["guid",false,null,null]`, true},
+		{`[]`, false},
+		{`{}`, false},
+		{`[1,false,"trip","hash"]`, false},
+		{`["guid",false,1,"hash"]`, false},
+		{`["guid",false,"trip",{}]`, false},
+	}
+	for _, tc := range tcs {
+		_, err := parseCATTxnPayload([]byte(tc.input))
+		if (nil == err) != tc.valid {
+			t.Error(tc.input, err)
+		}
+	}
+}
+
+func TestCATAppDataRoundTrip(t *testing.T) {
+	reply := &ConnectReply{
+		EncodingKey:     "my key",
+		TrustedAccounts: []int{123},
+	}
+	appData := &catAppData{
+		crossProcessID: "123#456",
+		txnName:        "WebTransaction/Go/hello",
+		queuing:        2 * time.Second,
+		response:       500 * time.Millisecond,
+		contentLength:  -1,
+		guid:           "9323dc260548ed0e",
+	}
+	js, _ := appData.MarshalJSON()
+	if string(js) != `["123#456","WebTransaction/Go/hello",2,0.5,-1,"9323dc260548ed0e",false]` {
+		t.Error(string(js))
+	}
+	header := catObfuscate(js, reply.EncodingKey)
+	parsed, err := catAppDataFromHeader(reply, header)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if parsed.crossProcessID != appData.crossProcessID ||
+		parsed.txnName != appData.txnName ||
+		parsed.guid != appData.guid {
+		t.Error(parsed)
+	}
+
+	reply.TrustedAccounts = []int{789}
+	if _, err := catAppDataFromHeader(reply, header); err != errCATUntrustedAccount {
+		t.Error(err)
+	}
+	if appData, err := catAppDataFromHeader(reply, ""); nil != appData || nil != err {
+		t.Error(appData, err)
+	}
+}
+
+func TestCATAlternatePathHashLimit(t *testing.T) {
+	cp := txnCrossProcess{guid: "9323dc260548ed0e"}
+	for i := 0; i < 2*maxAlternatePathHashes; i++ {
+		cp.outboundPayload("app", "WebTransaction/Go/"+string('a'+byte(i)))
+	}
+	if len(cp.alternatePathHashes) != maxAlternatePathHashes {
+		t.Error(len(cp.alternatePathHashes))
+	}
+}
+
+func TestCATIntrinsicsUnused(t *testing.T) {
+	cp := txnCrossProcess{}
+	if c := cp.intrinsics("app", "WebTransaction/Go/hello"); nil != c {
+		t.Error(c)
+	}
+}
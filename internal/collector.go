@@ -6,9 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/newrelic/go-agent/api"
 	"github.com/newrelic/go-agent/log"
@@ -27,6 +30,9 @@ const (
 	cmdTxnEvents    = "analytic_event_data"
 	cmdErrorEvents  = "error_event_data"
 	cmdErrorData    = "error_data"
+	cmdTxnTraces    = "transaction_sample_data"
+	cmdSlowSQLs     = "sql_trace_data"
+	cmdSpanEvents   = "span_event_data"
 )
 
 var (
@@ -39,12 +45,13 @@ var (
 )
 
 type rpmCmd struct {
-	Name      string
-	UseTLS    bool
-	Collector string
-	License   string
-	RunID     string
-	Data      []byte
+	Name        string
+	UseTLS      bool
+	Collector   string
+	License     string
+	RunID       string
+	Data        []byte
+	Compression api.CompressionConfig
 }
 
 func (cmd *rpmCmd) url() string {
@@ -75,19 +82,57 @@ func (cmd *rpmCmd) url() string {
 
 type unexpectedStatusCodeErr struct {
 	code int
+	// retryAfter is the delay requested by the Retry-After header of 429
+	// and 503 responses.
+	retryAfter time.Duration
 }
 
 func (e unexpectedStatusCodeErr) Error() string {
 	return fmt.Sprintf("unexpected HTTP status code: %d", e.code)
 }
 
-func collectorRequestInternal(url string, data []byte, client *http.Client) ([]byte, error) {
-	deflated, err := compress(data)
-	if nil != err {
-		return nil, err
+// collectorAction is the agent's response to a failed collector request.
+type collectorAction int
+
+const (
+	// collectorRetry keeps the data so that it is sent with a later
+	// request.
+	collectorRetry collectorAction = iota
+	// collectorDiscard discards the data.
+	collectorDiscard
+	// collectorRestart reconnects the application.
+	collectorRestart
+	// collectorShutdown stops communication with the collector.
+	collectorShutdown
+)
+
+// statusCodeAction classifies the status codes of collector responses.
+// Unlisted codes are retried.
+func statusCodeAction(code int) collectorAction {
+	switch code {
+	case 400, 403, 404, 405, 407, 411, 413, 414, 415, 417, 431:
+		return collectorDiscard
+	case 401, 409:
+		return collectorRestart
+	case 410:
+		return collectorShutdown
+	default:
+		return collectorRetry
 	}
+}
+
+func (e unexpectedStatusCodeErr) action() collectorAction {
+	return statusCodeAction(e.code)
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(deflated))
+// hasAction returns true if the error is a status code with the action given.
+func hasAction(e error, expected collectorAction) bool {
+	code, ok := e.(unexpectedStatusCodeErr)
+	return ok && code.action() == expected
+}
+
+func collectorRequestInternal(url string, compressed []byte, encoding string, client *http.Client) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(compressed))
 	if nil != err {
 		return nil, err
 	}
@@ -95,7 +140,7 @@ func collectorRequestInternal(url string, data []byte, client *http.Client) ([]b
 	req.Header.Add("Accept-Encoding", "identity, deflate")
 	req.Header.Add("Content-Type", "application/octet-stream")
 	req.Header.Add("User-Agent", userAgent)
-	req.Header.Add("Content-Encoding", "deflate")
+	req.Header.Add("Content-Encoding", encoding)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -112,10 +157,19 @@ func collectorRequestInternal(url string, data []byte, client *http.Client) ([]b
 		return nil, ErrUnsupportedMedia
 	}
 
+	// A 202 response has no body.
+	if 202 == resp.StatusCode {
+		return nil, nil
+	}
+
 	// If the response code is not 200, then the collector may not return
 	// valid JSON.
 	if 200 != resp.StatusCode {
-		return nil, unexpectedStatusCodeErr{code: resp.StatusCode}
+		err := unexpectedStatusCodeErr{code: resp.StatusCode}
+		if 429 == resp.StatusCode || 503 == resp.StatusCode {
+			err.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return nil, err
 	}
 
 	b, err := ioutil.ReadAll(resp.Body)
@@ -125,7 +179,49 @@ func collectorRequestInternal(url string, data []byte, client *http.Client) ([]b
 	return parseResponse(b)
 }
 
+// parseRetryAfter parses a Retry-After header, which contains either a number
+// of seconds or an HTTP date.  Zero is returned if the header is missing or
+// invalid.  The delay is limited to maxRetryAfter.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if "" == header {
+		return 0
+	}
+	var delay time.Duration
+	if secs, err := strconv.ParseInt(header, 10, 64); nil == err {
+		if secs > int64(maxRetryAfter/time.Second) {
+			return maxRetryAfter
+		}
+		delay = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(header); nil == err {
+		delay = t.Sub(now)
+	}
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return delay
+}
+
+// retryAfter returns the delay requested by the collector before the next
+// request, or zero if none was requested.
+func retryAfter(e error) time.Duration {
+	if code, ok := e.(unexpectedStatusCodeErr); ok {
+		return code.retryAfter
+	}
+	return 0
+}
+
 func collectorRequest(cmd rpmCmd, client *http.Client) ([]byte, error) {
+	resp, _, err := collectorRequestSize(cmd, client)
+	return resp, err
+}
+
+// collectorRequestSize sends a command to the collector and returns the reply
+// and the size of the compressed request body.
+func collectorRequestSize(cmd rpmCmd, client *http.Client) ([]byte, int, error) {
 	url := cmd.url()
 
 	if log.DebugEnabled() {
@@ -136,7 +232,13 @@ func collectorRequest(cmd rpmCmd, client *http.Client) ([]byte, error) {
 		})
 	}
 
-	resp, err := collectorRequestInternal(url, cmd.Data, client)
+	compressed, err := compressPayload(cmd.Data, cmd.Compression)
+	if nil != err {
+		return nil, 0, err
+	}
+
+	resp, err := collectorRequestInternal(url, compressed,
+		contentEncoding(cmd.Compression.Format), client)
 	if err != nil {
 		log.Debug("rpm failure", log.Context{
 			"command": cmd.Name,
@@ -153,7 +255,7 @@ func collectorRequest(cmd rpmCmd, client *http.Client) ([]byte, error) {
 		})
 	}
 
-	return resp, err
+	return resp, len(compressed), err
 }
 
 type rpmException struct {
@@ -181,10 +283,23 @@ const (
 	runtimeType        = "RuntimeError"
 )
 
-func isRestartException(e error) bool { return hasType(e, forceRestartType) }
+// Restart and disconnect are requested either by an exception in the response
+// or by the response status code.
+func isRestartException(e error) bool {
+	return hasType(e, forceRestartType) || hasAction(e, collectorRestart)
+}
 func isLicenseException(e error) bool { return hasType(e, licenseInvalidType) }
 func isRuntime(e error) bool          { return hasType(e, runtimeType) }
-func isDisconnect(e error) bool       { return hasType(e, disconnectType) }
+func isDisconnect(e error) bool {
+	return hasType(e, disconnectType) || hasAction(e, collectorShutdown)
+}
+
+// isCollectorUnreachable returns true if the request failed before a response
+// was received from the collector.
+func isCollectorUnreachable(e error) bool {
+	_, ok := e.(net.Error)
+	return ok
+}
 
 func parseResponse(b []byte) ([]byte, error) {
 	var r struct {
@@ -234,21 +349,25 @@ func processConnectMessages(reply []byte) {
 	}
 }
 
-func connectAttempt(cfg *api.Config, client *http.Client) (string, *ConnectReply, error) {
+// connectAttempt connects to the collector.  The sizes of the requests sent
+// are added to sizes.
+func connectAttempt(cfg *api.Config, client *http.Client, sizes *payloadSizes) (string, *ConnectReply, error) {
 	js, err := configConnectJSON(cfg)
 	if nil != err {
 		return "", nil, err
 	}
 
 	call := rpmCmd{
-		Name:      cmdRedirect,
-		UseTLS:    cfg.UseTLS,
-		Collector: redirectHost,
-		License:   cfg.License,
-		Data:      []byte("[]"),
+		Name:        cmdRedirect,
+		UseTLS:      cfg.UseTLS,
+		Collector:   redirectHost,
+		License:     cfg.License,
+		Data:        []byte("[]"),
+		Compression: cfg.Compression,
 	}
 
-	out, err := collectorRequest(call, client)
+	out, compressed, err := collectorRequestSize(call, client)
+	sizes.add(call.Name, len(call.Data), compressed)
 	if nil != err {
 		// err is intentionally unmodified:  We do not want to change
 		// the type of these collector errors.
@@ -265,7 +384,8 @@ func connectAttempt(cfg *api.Config, client *http.Client) (string, *ConnectReply
 	call.Data = js
 	call.Name = cmdConnect
 
-	rawReply, err := collectorRequest(call, client)
+	rawReply, compressed, err := collectorRequestSize(call, client)
+	sizes.add(call.Name, len(call.Data), compressed)
 	if nil != err {
 		// err is intentionally unmodified:  We do not want to change
 		// the type of these collector errors.
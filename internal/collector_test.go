@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/newrelic/go-agent/api"
 )
@@ -272,7 +274,7 @@ func testConnectHelper(transport http.RoundTripper) (string, *ConnectReply, erro
 	cfg.Utilization.DetectDocker = false
 	cfg.Transport = transport
 	client := &http.Client{Transport: cfg.Transport}
-	return connectAttempt(&cfg, client)
+	return connectAttempt(&cfg, client, nil)
 }
 
 func TestConnectAttemptSuccess(t *testing.T) {
@@ -291,6 +293,28 @@ func TestConnectAttemptSuccess(t *testing.T) {
 	}
 }
 
+func TestConnectAttemptPayloadSizes(t *testing.T) {
+	cfg := api.NewConfig("my appname", "0123456789012345678901234567890123456789")
+	cfg.Utilization.DetectAWS = false
+	cfg.Utilization.DetectDocker = false
+	client := &http.Client{Transport: connectMockRoundTripper{
+		redirect: endpointResult{response: makeResponse(200, redirectBody)},
+		connect:  endpointResult{response: makeResponse(200, connectBody)},
+	}}
+	var sizes payloadSizes
+	if _, _, err := connectAttempt(&cfg, client, &sizes); nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(sizes) || cmdRedirect != sizes[0].cmd || cmdConnect != sizes[1].cmd {
+		t.Fatal(sizes)
+	}
+	for _, m := range sizes {
+		if m.uncompressed <= 0 || m.compressed <= 0 {
+			t.Error(m)
+		}
+	}
+}
+
 func TestConnectAttemptDisconnectOnRedirect(t *testing.T) {
 	collector, reply, err := testConnectHelper(connectMockRoundTripper{
 		redirect: endpointResult{response: makeResponse(200, disconnectBody)},
@@ -433,3 +457,175 @@ func TestConnectAttemptMissingRunID(t *testing.T) {
 		t.Fatal("missing error")
 	}
 }
+
+func TestConnectAttemptRetryAfter(t *testing.T) {
+	for code, expect := range map[int]time.Duration{
+		429: 2 * time.Minute,
+		503: 2 * time.Minute,
+		500: 0,
+	} {
+		resp := makeResponse(code, "")
+		resp.Header = http.Header{"Retry-After": []string{"120"}}
+		_, _, err := testConnectHelper(connectMockRoundTripper{
+			redirect: endpointResult{response: resp},
+		})
+		if _, ok := err.(unexpectedStatusCodeErr); !ok {
+			t.Fatal(code, err)
+		}
+		if delay := retryAfter(err); delay != expect {
+			t.Error(code, delay)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+	testcases := []struct {
+		header string
+		expect time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{" 30 ", 30 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{"100000000000000", maxRetryAfter},
+		{"7200", maxRetryAfter},
+		{"Wed, 21 Oct 2015 07:30:00 GMT", 2 * time.Minute},
+		{"Wed, 21 Oct 2015 07:00:00 GMT", 0},
+		{"Wed, 21 Oct 2015 09:30:00 GMT", maxRetryAfter},
+	}
+	for _, tc := range testcases {
+		if delay := parseRetryAfter(tc.header, now); delay != tc.expect {
+			t.Error(tc.header, delay, tc.expect)
+		}
+	}
+	if delay := retryAfter(errors.New("other")); 0 != delay {
+		t.Error(delay)
+	}
+}
+
+// fakeCollectorTransport sends all requests to a fake collector server.
+type fakeCollectorTransport struct {
+	url *url.URL
+}
+
+func (tr fakeCollectorTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme = tr.url.Scheme
+	r.URL.Host = tr.url.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func newFakeCollector(t *testing.T, handler http.HandlerFunc) (*httptest.Server, http.RoundTripper) {
+	srv := httptest.NewServer(handler)
+	u, err := url.Parse(srv.URL)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return srv, fakeCollectorTransport{url: u}
+}
+
+func TestCollectorStatusCodes(t *testing.T) {
+	testcases := []struct {
+		code       int
+		success    bool
+		save       bool
+		restart    bool
+		disconnect bool
+	}{
+		{code: 200, success: true},
+		{code: 202, success: true},
+		{code: 400},
+		{code: 403},
+		{code: 404},
+		{code: 405},
+		{code: 407},
+		{code: 411},
+		{code: 413},
+		{code: 414},
+		{code: 415},
+		{code: 417},
+		{code: 431},
+		{code: 408, save: true},
+		{code: 429, save: true},
+		{code: 500, save: true},
+		{code: 502, save: true},
+		{code: 503, save: true},
+		{code: 401, save: true, restart: true},
+		{code: 409, save: true, restart: true},
+		{code: 410, save: true, disconnect: true},
+	}
+
+	var code int
+	srv, transport := newFakeCollector(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		if 200 == code {
+			w.Write([]byte(`{"return_value":null}`))
+		}
+	})
+	defer srv.Close()
+	client := &http.Client{Transport: transport}
+
+	for _, tc := range testcases {
+		code = tc.code
+		_, err := collectorRequest(rpmCmd{
+			Name:      cmdMetrics,
+			UseTLS:    true,
+			Collector: "collector.newrelic.com",
+			License:   "0123456789012345678901234567890123456789",
+			RunID:     "run",
+			Data:      []byte("[]"),
+		}, client)
+		if tc.success != (nil == err) {
+			t.Error(tc.code, err)
+			continue
+		}
+		if tc.success {
+			continue
+		}
+		if save := shouldSaveFailedHarvest(err); save != tc.save {
+			t.Error(tc.code, "save", save)
+		}
+		if restart := isRestartException(err); restart != tc.restart {
+			t.Error(tc.code, "restart", restart)
+		}
+		if disconnect := isDisconnect(err); disconnect != tc.disconnect {
+			t.Error(tc.code, "disconnect", disconnect)
+		}
+		if fatal := isFatalHarvestError(err); fatal != (tc.restart || tc.disconnect) {
+			t.Error(tc.code, "fatal", fatal)
+		}
+	}
+}
+
+func TestCollectorRequestCompression(t *testing.T) {
+	var encoding string
+	var body []byte
+	srv, transport := newFakeCollector(t, func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"return_value":null}`))
+	})
+	defer srv.Close()
+	client := &http.Client{Transport: transport}
+
+	for _, format := range []string{"", api.CompressionDeflate, api.CompressionGzip} {
+		_, compressed, err := collectorRequestSize(rpmCmd{
+			Name:        cmdMetrics,
+			Collector:   "collector.newrelic.com",
+			Data:        []byte(`["run",1,2,[]]`),
+			Compression: api.CompressionConfig{Format: format},
+		}, client)
+		if nil != err {
+			t.Fatal(format, err)
+		}
+		if compressed != len(body) || encoding != contentEncoding(format) {
+			t.Error(format, compressed, len(body), encoding)
+		}
+		data, err := uncompressPayload(body, encoding)
+		if nil != err || string(data) != `["run",1,2,[]]` {
+			t.Error(format, string(data), err)
+		}
+	}
+}
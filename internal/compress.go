@@ -2,15 +2,40 @@ package internal
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"compress/zlib"
 	"encoding/base64"
+	"io"
 	"io/ioutil"
+
+	"github.com/newrelic/go-agent/api"
 )
 
 func compress(b []byte) ([]byte, error) {
+	return compressPayload(b, api.CompressionConfig{})
+}
+
+// compressPayload compresses a collector request body using the format and
+// level configured.
+func compressPayload(b []byte, c api.CompressionConfig) ([]byte, error) {
+	level := c.Level
+	if 0 == level {
+		level = flate.DefaultCompression
+	}
+
 	buf := bytes.Buffer{}
-	w := zlib.NewWriter(&buf)
-	_, err := w.Write(b)
+	var w io.WriteCloser
+	var err error
+	if api.CompressionGzip == c.Format {
+		w, err = gzip.NewWriterLevel(&buf, level)
+	} else {
+		w, err = zlib.NewWriterLevel(&buf, level)
+	}
+	if nil != err {
+		return nil, err
+	}
+	_, err = w.Write(b)
 	w.Close()
 
 	if nil != err {
@@ -20,9 +45,30 @@ func compress(b []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// contentEncoding returns the Content-Encoding header of requests compressed
+// using the format given.
+func contentEncoding(format string) string {
+	if api.CompressionGzip == format {
+		return "gzip"
+	}
+	return "deflate"
+}
+
 func uncompress(b []byte) ([]byte, error) {
+	return uncompressPayload(b, "deflate")
+}
+
+// uncompressPayload uncompresses a collector request body with the
+// Content-Encoding given.
+func uncompressPayload(b []byte, encoding string) ([]byte, error) {
 	buf := bytes.NewBuffer(b)
-	r, err := zlib.NewReader(buf)
+	var r io.ReadCloser
+	var err error
+	if "gzip" == encoding {
+		r, err = gzip.NewReader(buf)
+	} else {
+		r, err = zlib.NewReader(buf)
+	}
 	if nil != err {
 		return nil, err
 	}
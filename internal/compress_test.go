@@ -1,7 +1,10 @@
 package internal
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/newrelic/go-agent/api"
 )
 
 type compressEncodeTestcase struct {
@@ -34,3 +37,31 @@ func TestCompressEncode(t *testing.T) {
 		}
 	}
 }
+
+func TestCompressPayload(t *testing.T) {
+	input := []byte(strings.Repeat("zipzap", 100))
+	for _, c := range []api.CompressionConfig{
+		{},
+		{Format: api.CompressionDeflate, Level: 1},
+		{Format: api.CompressionGzip},
+		{Format: api.CompressionGzip, Level: 9},
+	} {
+		compressed, err := compressPayload(input, c)
+		if nil != err {
+			t.Fatal(c, err)
+		}
+		if len(compressed) >= len(input) {
+			t.Error(c, len(compressed))
+		}
+		out, err := uncompressPayload(compressed, contentEncoding(c.Format))
+		if nil != err || string(out) != string(input) {
+			t.Error(c, string(out), err)
+		}
+	}
+	if enc := contentEncoding(""); "deflate" != enc {
+		t.Error(enc)
+	}
+	if enc := contentEncoding(api.CompressionGzip); "gzip" != enc {
+		t.Error(enc)
+	}
+}
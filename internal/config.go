@@ -38,10 +38,31 @@ func copyConfigReferenceFields(cfg api.Config) api.Config {
 		copy(ignored, cfg.ErrorCollector.IgnoreStatusCodes)
 		cp.ErrorCollector.IgnoreStatusCodes = ignored
 	}
+	if nil != cfg.ErrorCollector.ExpectedStatusCodes {
+		expected := make([]int, len(cfg.ErrorCollector.ExpectedStatusCodes))
+		copy(expected, cfg.ErrorCollector.ExpectedStatusCodes)
+		cp.ErrorCollector.ExpectedStatusCodes = expected
+	}
+	if nil != cfg.ErrorCollector.IgnoreClasses {
+		cp.ErrorCollector.IgnoreClasses = make([]string, len(cfg.ErrorCollector.IgnoreClasses))
+		copy(cp.ErrorCollector.IgnoreClasses, cfg.ErrorCollector.IgnoreClasses)
+	}
+	if nil != cfg.ErrorCollector.ExpectedClasses {
+		cp.ErrorCollector.ExpectedClasses = make([]string, len(cfg.ErrorCollector.ExpectedClasses))
+		copy(cp.ErrorCollector.ExpectedClasses, cfg.ErrorCollector.ExpectedClasses)
+	}
+	if nil != cfg.ErrorCollector.IgnoreMessages {
+		cp.ErrorCollector.IgnoreMessages = make(map[string][]string, len(cfg.ErrorCollector.IgnoreMessages))
+		for klass, msgs := range cfg.ErrorCollector.IgnoreMessages {
+			cp.ErrorCollector.IgnoreMessages[klass] = append([]string(nil), msgs...)
+		}
+	}
 
 	cp.Attributes = copyDestConfig(cfg.Attributes)
 	cp.ErrorCollector.Attributes = copyDestConfig(cfg.ErrorCollector.Attributes)
 	cp.TransactionEvents.Attributes = copyDestConfig(cfg.TransactionEvents.Attributes)
+	cp.TransactionTracer.Attributes = copyDestConfig(cfg.TransactionTracer.Attributes)
+	cp.BrowserMonitoring.Attributes = copyDestConfig(cfg.BrowserMonitoring.Attributes)
 
 	return cp
 }
@@ -100,20 +121,46 @@ func (s *settings) MarshalJSON() ([]byte, error) {
 	return json.Marshal(fields)
 }
 
+// eventHarvestConfig reports the event harvest period and the sizes of the
+// event reservoirs of each event harvest in the connect payload.
+type eventHarvestConfig struct {
+	ReportPeriodMs int64 `json:"report_period_ms"`
+	Limits         struct {
+		TxnEvents    int `json:"analytic_event_data"`
+		CustomEvents int `json:"custom_event_data"`
+		ErrorEvents  int `json:"error_event_data"`
+		SpanEvents   int `json:"span_event_data"`
+	} `json:"harvest_limits"`
+}
+
+func newEventHarvestConfig(c *api.Config) eventHarvestConfig {
+	period := configEventHarvestPeriod(c)
+	limits := harvestLimitsFromConfig(c, period)
+	cfg := eventHarvestConfig{
+		ReportPeriodMs: durationToIntMilliseconds(period),
+	}
+	cfg.Limits.TxnEvents = limits.txnEvents
+	cfg.Limits.CustomEvents = limits.customEvents
+	cfg.Limits.ErrorEvents = limits.errorEvents
+	cfg.Limits.SpanEvents = limits.spanEvents
+	return cfg
+}
+
 func configConnectJSONInternal(c *api.Config, pid int, util *utilization.Data, e environment, version string) ([]byte, error) {
 	return json.Marshal([]interface{}{struct {
-		Pid             int               `json:"pid"`
-		Language        string            `json:"language"`
-		Version         string            `json:"agent_version"`
-		Host            string            `json:"host"`
-		HostDisplayName string            `json:"display_host,omitempty"`
-		Settings        interface{}       `json:"settings"`
-		AppName         []string          `json:"app_name"`
-		HighSecurity    bool              `json:"high_security"`
-		Labels          labels            `json:"labels,omitempty"`
-		Environment     environment       `json:"environment"`
-		Identifier      string            `json:"identifier"`
-		Util            *utilization.Data `json:"utilization"`
+		Pid             int                `json:"pid"`
+		Language        string             `json:"language"`
+		Version         string             `json:"agent_version"`
+		Host            string             `json:"host"`
+		HostDisplayName string             `json:"display_host,omitempty"`
+		Settings        interface{}        `json:"settings"`
+		AppName         []string           `json:"app_name"`
+		HighSecurity    bool               `json:"high_security"`
+		Labels          labels             `json:"labels,omitempty"`
+		Environment     environment        `json:"environment"`
+		Identifier      string             `json:"identifier"`
+		Util            *utilization.Data  `json:"utilization"`
+		EventData       eventHarvestConfig `json:"event_harvest_config"`
 	}{
 		Pid:             pid,
 		Language:        agentLanguage,
@@ -138,6 +185,7 @@ func configConnectJSONInternal(c *api.Config, pid int, util *utilization.Data, e
 		// allows users more flexibility in using application rollups.
 		Identifier: c.AppName,
 		Util:       util,
+		EventData:  newEventHarvestConfig(c),
 	}})
 }
 
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"testing"
+	"time"
 
 	"github.com/newrelic/go-agent/api"
 	"github.com/newrelic/go-agent/internal/utilization"
@@ -11,23 +12,40 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 	cfg := api.NewConfig("my appname", "0123456789012345678901234567890123456789")
 	cfg.Labels["zip"] = "zap"
 	cfg.ErrorCollector.IgnoreStatusCodes = append(cfg.ErrorCollector.IgnoreStatusCodes, 405)
+	cfg.ErrorCollector.ExpectedStatusCodes = append(cfg.ErrorCollector.ExpectedStatusCodes, 409)
+	cfg.ErrorCollector.IgnoreClasses = append(cfg.ErrorCollector.IgnoreClasses, "*errors.errorString")
+	cfg.ErrorCollector.ExpectedClasses = append(cfg.ErrorCollector.ExpectedClasses, "*net.OpError")
+	cfg.ErrorCollector.IgnoreMessages = map[string][]string{"*os.PathError": {"not found"}}
 	cfg.Attributes.Include = append(cfg.Attributes.Include, "1")
 	cfg.Attributes.Exclude = append(cfg.Attributes.Exclude, "2")
 	cfg.TransactionEvents.Attributes.Include = append(cfg.TransactionEvents.Attributes.Include, "3")
 	cfg.TransactionEvents.Attributes.Exclude = append(cfg.TransactionEvents.Attributes.Exclude, "4")
 	cfg.ErrorCollector.Attributes.Include = append(cfg.ErrorCollector.Attributes.Include, "5")
 	cfg.ErrorCollector.Attributes.Exclude = append(cfg.ErrorCollector.Attributes.Exclude, "6")
+	cfg.TransactionTracer.Attributes.Include = append(cfg.TransactionTracer.Attributes.Include, "7")
+	cfg.TransactionTracer.Attributes.Exclude = append(cfg.TransactionTracer.Attributes.Exclude, "8")
+	cfg.BrowserMonitoring.Attributes.Include = append(cfg.BrowserMonitoring.Attributes.Include, "9")
+	cfg.BrowserMonitoring.Attributes.Exclude = append(cfg.BrowserMonitoring.Attributes.Exclude, "10")
 
 	cp := copyConfigReferenceFields(cfg)
 
 	cfg.Labels["zop"] = "zup"
 	cfg.ErrorCollector.IgnoreStatusCodes[0] = 201
+	cfg.ErrorCollector.ExpectedStatusCodes[0] = 201
+	cfg.ErrorCollector.IgnoreClasses[0] = "zap"
+	cfg.ErrorCollector.ExpectedClasses[0] = "zap"
+	cfg.ErrorCollector.IgnoreMessages["*os.PathError"][0] = "zap"
+	cfg.ErrorCollector.IgnoreMessages["zap"] = nil
 	cfg.Attributes.Include[0] = "zap"
 	cfg.Attributes.Exclude[0] = "zap"
 	cfg.TransactionEvents.Attributes.Include[0] = "zap"
 	cfg.TransactionEvents.Attributes.Exclude[0] = "zap"
 	cfg.ErrorCollector.Attributes.Include[0] = "zap"
 	cfg.ErrorCollector.Attributes.Exclude[0] = "zap"
+	cfg.TransactionTracer.Attributes.Include[0] = "zap"
+	cfg.TransactionTracer.Attributes.Exclude[0] = "zap"
+	cfg.BrowserMonitoring.Attributes.Include[0] = "zap"
+	cfg.BrowserMonitoring.Attributes.Exclude[0] = "zap"
 
 	expect := compactJSONString(`[
 	{
@@ -39,22 +57,56 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 			"AppName":"my appname",
 			"Attributes":{"Enabled":true,"Exclude":["2"],"Include":["1"]},
 			"BetaToken":"",
+			"BrowserMonitoring":{
+				"Attributes":{"Enabled":false,"Exclude":["10"],"Include":["9"]},
+				"Enabled":true
+			},
+			"Compression":{"Format":"deflate","Level":0},
+			"CrossApplicationTracer":{"Enabled":true},
 			"CustomInsightsEvents":{"Enabled":true},
+			"DatastoreTracer":{
+				"SlowQuery":{"Enabled":true,"Threshold":10000000}
+			},
+			"DistributedTracer":{"Enabled":false},
 			"Enabled":true,
 			"ErrorCollector":{
 				"Attributes":{"Enabled":true,"Exclude":["6"],"Include":["5"]},
 				"CaptureEvents":true,
 				"Enabled":true,
+				"ExpectedClasses":["*net.OpError"],
+				"ExpectedStatusCodes":[409],
+				"IgnoreClasses":["*errors.errorString"],
+				"IgnoreMessages":{"*os.PathError":["not found"]},
 				"IgnoreStatusCodes":[404,405]
 			},
+			"EventHarvestPeriod":5000000000,
+			"HarvestLimits":{
+				"MaxCustomEvents":10000,
+				"MaxErrorEvents":100,
+				"MaxErrors":20,
+				"MaxMetrics":2000,
+				"MaxSpanEvents":1000,
+				"MaxTxnErrors":5,
+				"MaxTxnEvents":10000
+			},
 			"HighSecurity":false,
 			"HostDisplayName":"",
 			"Labels":{"zip":"zap"},
+			"MaxPayloadBytes":1000000,
 			"RuntimeSampler":{"Enabled":true},
+			"SpanEvents":{"Enabled":true},
+			"Spool":{"Dir":"","MaxAge":3600000000000,"MaxBytes":10485760},
 			"TransactionEvents":{
 				"Attributes":{"Enabled":true,"Exclude":["4"],"Include":["3"]},
 				"Enabled":true
 			},
+			"TransactionTracer":{
+				"Attributes":{"Enabled":true,"Exclude":["8"],"Include":["7"]},
+				"Enabled":true,
+				"MaxSegments":2000,
+				"SegmentThreshold":2000000,
+				"Threshold":{"Duration":500000000,"IsApdexFailing":true}
+			},
 			"Transport":null,
 			"UseTLS":true,
 			"Utilization":{"DetectAWS":true,"DetectDocker":true}
@@ -69,6 +121,15 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 			"logical_processors":16,
 			"total_ram_mib":1024,
 			"hostname":"my-hostname"
+		},
+		"event_harvest_config":{
+			"report_period_ms":5000,
+			"harvest_limits":{
+				"analytic_event_data":833,
+				"custom_event_data":833,
+				"error_event_data":8,
+				"span_event_data":1000
+			}
 		}
 	}]`)
 
@@ -98,22 +159,56 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 			"AppName":"my appname",
 			"Attributes":{"Enabled":true,"Exclude":null,"Include":null},
 			"BetaToken":"",
+			"BrowserMonitoring":{
+				"Attributes":{"Enabled":false,"Exclude":null,"Include":null},
+				"Enabled":true
+			},
+			"Compression":{"Format":"deflate","Level":0},
+			"CrossApplicationTracer":{"Enabled":true},
 			"CustomInsightsEvents":{"Enabled":true},
+			"DatastoreTracer":{
+				"SlowQuery":{"Enabled":true,"Threshold":10000000}
+			},
+			"DistributedTracer":{"Enabled":false},
 			"Enabled":true,
 			"ErrorCollector":{
 				"Attributes":{"Enabled":true,"Exclude":null,"Include":null},
 				"CaptureEvents":true,
 				"Enabled":true,
+				"ExpectedClasses":null,
+				"ExpectedStatusCodes":null,
+				"IgnoreClasses":null,
+				"IgnoreMessages":null,
 				"IgnoreStatusCodes":null
 			},
+			"EventHarvestPeriod":5000000000,
+			"HarvestLimits":{
+				"MaxCustomEvents":10000,
+				"MaxErrorEvents":100,
+				"MaxErrors":20,
+				"MaxMetrics":2000,
+				"MaxSpanEvents":1000,
+				"MaxTxnErrors":5,
+				"MaxTxnEvents":10000
+			},
 			"HighSecurity":false,
 			"HostDisplayName":"",
 			"Labels":null,
+			"MaxPayloadBytes":1000000,
 			"RuntimeSampler":{"Enabled":true},
+			"SpanEvents":{"Enabled":true},
+			"Spool":{"Dir":"","MaxAge":3600000000000,"MaxBytes":10485760},
 			"TransactionEvents":{
 				"Attributes":{"Enabled":true,"Exclude":null,"Include":null},
 				"Enabled":true
 			},
+			"TransactionTracer":{
+				"Attributes":{"Enabled":true,"Exclude":null,"Include":null},
+				"Enabled":true,
+				"MaxSegments":2000,
+				"SegmentThreshold":2000000,
+				"Threshold":{"Duration":500000000,"IsApdexFailing":true}
+			},
 			"Transport":null,
 			"UseTLS":true,
 			"Utilization":{"DetectAWS":true,"DetectDocker":true}
@@ -127,6 +222,15 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 			"logical_processors":16,
 			"total_ram_mib":1024,
 			"hostname":"my-hostname"
+		},
+		"event_harvest_config":{
+			"report_period_ms":5000,
+			"harvest_limits":{
+				"analytic_event_data":833,
+				"custom_event_data":833,
+				"error_event_data":8,
+				"span_event_data":1000
+			}
 		}
 	}]`)
 
@@ -207,4 +311,62 @@ func TestValidate(t *testing.T) {
 	if err := c.Validate(); err != nil {
 		t.Error(err)
 	}
+	c = api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	c.HarvestLimits.MaxTxnEvents = -1
+	if err := c.Validate(); err != api.ErrHarvestLimits {
+		t.Error(err)
+	}
+	c.HarvestLimits.MaxTxnEvents = 0
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+	c.EventHarvestPeriod = 500 * time.Millisecond
+	if err := c.Validate(); err != api.ErrEventHarvestPeriod {
+		t.Error(err)
+	}
+	c.EventHarvestPeriod = 2 * time.Minute
+	if err := c.Validate(); err != api.ErrEventHarvestPeriod {
+		t.Error(err)
+	}
+	c.EventHarvestPeriod = 0
+	c.Spool.Dir = "spool"
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+	c.Spool.MaxBytes = 0
+	if err := c.Validate(); err != api.ErrSpool {
+		t.Error(err)
+	}
+	c.Spool.Dir = ""
+	c.MaxPayloadBytes = -1
+	if err := c.Validate(); err != api.ErrMaxPayloadBytes {
+		t.Error(err)
+	}
+	c.MaxPayloadBytes = 0
+	c.Compression = api.CompressionConfig{Format: api.CompressionGzip, Level: 9}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+	c.Compression = api.CompressionConfig{Format: "br"}
+	if err := c.Validate(); err != api.ErrCompression {
+		t.Error(err)
+	}
+	c.Compression = api.CompressionConfig{Level: 10}
+	if err := c.Validate(); err != api.ErrCompression {
+		t.Error(err)
+	}
+}
+
+func TestHarvestLimitsDefaults(t *testing.T) {
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	if limits := harvestLimitsFromConfig(&cfg, harvestPeriod); limits != defaultHarvestLimits {
+		t.Error(limits, defaultHarvestLimits)
+	}
+	if cfg.HarvestLimits.MaxTxnErrors != maxTxnErrors {
+		t.Error(cfg.HarvestLimits.MaxTxnErrors)
+	}
+	var zero api.Config
+	if limits := harvestLimitsFromConfig(&zero, harvestPeriod); limits != defaultHarvestLimits {
+		t.Error(limits, defaultHarvestLimits)
+	}
 }
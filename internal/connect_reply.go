@@ -28,6 +28,11 @@ type ConnectReply struct {
 	CrossProcessID  string `json:"cross_process_id"`
 	TrustedAccounts []int  `json:"trusted_account_ids"`
 
+	// Distributed Tracing
+	AccountID         string `json:"account_id"`
+	TrustedAccountKey string `json:"trusted_account_key"`
+	PrimaryAppID      string `json:"primary_application_id"`
+
 	// Settings
 	KeyTxnApdex            map[string]float64 `json:"web_transactions_apdex"`
 	ApdexThresholdSeconds  float64            `json:"apdex_t"`
@@ -36,6 +41,19 @@ type ConnectReply struct {
 	CollectTraces          bool               `json:"collect_traces"`
 	CollectErrors          bool               `json:"collect_errors"`
 	CollectErrorEvents     bool               `json:"collect_error_events"`
+	CollectSpanEvents      bool               `json:"collect_span_events"`
+
+	// EventData is the event harvest configuration requested by the
+	// collector.  Missing values are nil.  It is clamped by the local
+	// configuration, see newAppRun.
+	EventData struct {
+		ReportPeriodMs *int `json:"report_period_ms"`
+		Limits         struct {
+			TxnEvents    *int `json:"analytic_event_data"`
+			CustomEvents *int `json:"custom_event_data"`
+			ErrorEvents  *int `json:"error_event_data"`
+		} `json:"harvest_limits"`
+	} `json:"event_harvest_config"`
 
 	// RUM
 	AgentLoader string `json:"js_agent_loader"`
@@ -54,6 +72,7 @@ func connectReplyDefaults() *ConnectReply {
 		CollectTraces:          true,
 		CollectErrors:          true,
 		CollectErrorEvents:     true,
+		CollectSpanEvents:      true,
 	}
 }
 
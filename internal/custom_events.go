@@ -21,12 +21,20 @@ func (cs *customEvents) Add(e *customEvent) {
 }
 
 func (cs *customEvents) mergeIntoHarvest(h *harvest) {
-	h.customEvents.events.MergeFailed(cs.events)
+	h.customEvents.events.MergeFailed(cs.events, h.limits.failedEventAttempts)
 }
 
 func (cs *customEvents) Data(agentRunID string, harvestStart time.Time) ([]byte, error) {
 	return cs.events.CollectorJSON(agentRunID)
 }
 
+func (cs *customEvents) split() (payloadCreator, payloadCreator) {
+	first, second := cs.events.split()
+	if nil == first {
+		return nil, nil
+	}
+	return &customEvents{events: first}, &customEvents{events: second}
+}
+
 func (cs *customEvents) numSeen() float64  { return cs.events.NumSeen() }
 func (cs *customEvents) numSaved() float64 { return cs.events.NumSaved() }
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const customMetricPrefix = "Custom/"
+
+var (
+	errCustomMetricNameEmpty  = fmt.Errorf("custom metric name is empty")
+	errCustomMetricNameLength = fmt.Errorf("custom metric name exceeds length limit of %d",
+		customMetricNameLengthLimit)
+	errCustomMetricValue = fmt.Errorf("custom metric value is not finite")
+)
+
+// customMetric is a metric recorded using the application's custom metric
+// methods.  Custom metrics are unforced:  They are dropped if the metric table
+// is full.
+type customMetric struct {
+	name string
+	data metricData
+}
+
+func (m *customMetric) mergeIntoHarvest(h *harvest) {
+	h.metrics.add(m.name, "", m.data, unforced)
+}
+
+func newCustomMetric(name string, data metricData) (*customMetric, error) {
+	if "" == name {
+		return nil, errCustomMetricNameEmpty
+	}
+	name = customMetricPrefix + name
+	if len(name) > customMetricNameLengthLimit {
+		return nil, errCustomMetricNameLength
+	}
+	return &customMetric{name: name, data: data}, nil
+}
+
+func customMetricValue(name string, value float64) (*customMetric, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, errCustomMetricValue
+	}
+	return newCustomMetric(name, metricData{
+		countSatisfied:  1,
+		totalTolerated:  value,
+		exclusiveFailed: value,
+		min:             value,
+		max:             value,
+		sumSquares:      value * value,
+	})
+}
+
+func customMetricCount(name string, count int) (*customMetric, error) {
+	return newCustomMetric(name, metricData{countSatisfied: float64(count)})
+}
+
+func customMetricDuration(name string, duration time.Duration) (*customMetric, error) {
+	return newCustomMetric(name, metricDataFromDuration(duration, duration))
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCustomMetricName(t *testing.T) {
+	if _, err := customMetricValue("", 1); err != errCustomMetricNameEmpty {
+		t.Error(err)
+	}
+	long := strings.Repeat("a", customMetricNameLengthLimit-len(customMetricPrefix))
+	if m, err := customMetricValue(long, 1); nil != err || m.name != customMetricPrefix+long {
+		t.Error(m, err)
+	}
+	if _, err := customMetricValue(long+"a", 1); err != errCustomMetricNameLength {
+		t.Error(err)
+	}
+}
+
+func TestCustomMetricValue(t *testing.T) {
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := customMetricValue("value", v); err != errCustomMetricValue {
+			t.Error(v, err)
+		}
+	}
+}
+
+func TestCustomMetricsMerge(t *testing.T) {
+	h := newHarvest(time.Now(), defaultHarvestLimits)
+	for _, v := range []float64{2, 3} {
+		m, _ := customMetricValue("value", v)
+		m.mergeIntoHarvest(h)
+	}
+	m, _ := customMetricCount("count", 4)
+	m.mergeIntoHarvest(h)
+	m, _ = customMetricDuration("timing", 2*time.Second)
+	m.mergeIntoHarvest(h)
+	expectMetrics(t, h.metrics, []WantMetric{
+		{"Custom/value", "", false, []float64{2, 5, 5, 2, 3, 13}},
+		{"Custom/count", "", false, []float64{4, 0, 0, 0, 0, 0}},
+		{"Custom/timing", "", false, []float64{1, 2, 2, 2, 2, 4}},
+	})
+}
+
+func TestCustomMetricsUnforced(t *testing.T) {
+	h := newHarvest(time.Now(), defaultHarvestLimits)
+	h.metrics.maxTableSize = 0
+	m, _ := customMetricValue("value", 1)
+	m.mergeIntoHarvest(h)
+	if 0 != len(h.metrics.metrics) || 1 != h.metrics.numDropped {
+		t.Error(h.metrics.metrics, h.metrics.numDropped)
+	}
+}
+
+func TestCustomMetricsRules(t *testing.T) {
+	js := `[{
+		"match_expression":"^Custom/secret.*",
+		"replacement":"Custom/redacted",
+		"ignore":false,
+		"eval_order":0
+	}]`
+	var rules metricRules
+	if err := json.Unmarshal([]byte(js), &rules); nil != err {
+		t.Fatal(err)
+	}
+	h := newHarvest(time.Now(), defaultHarvestLimits)
+	m, _ := customMetricValue("secret/123", 1)
+	m.mergeIntoHarvest(h)
+	h.applyMetricRules(rules)
+	expectMetrics(t, h.metrics, []WantMetric{
+		{"Custom/redacted", "", false, []float64{1, 1, 1, 1, 1, 1}},
+	})
+}
@@ -0,0 +1,348 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://www.w3.org/TR/trace-context/
+
+const (
+	traceParentHeader = "traceparent"
+	traceStateHeader  = "tracestate"
+
+	// traceParentVersion is the version of the traceparent header created.
+	traceParentVersion = "00"
+	// traceStateVersion and traceStateParentTypeApp are the first fields
+	// of the New Relic tracestate entry.
+	traceStateVersion       = "0"
+	traceStateParentTypeApp = "0"
+	traceStateVendorSuffix  = "@nr"
+
+	traceFlagSampled = 0x01
+
+	// maxTraceStateEntries is the W3C limit on the number of list members
+	// of a tracestate header.
+	maxTraceStateEntries = 32
+)
+
+var (
+	errTraceParentMalformed = errors.New("malformed traceparent header")
+	errTraceStateMalformed  = errors.New("malformed New Relic tracestate entry")
+)
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAllZeros(s string) bool {
+	return "" == strings.Trim(s, "0")
+}
+
+// newTraceID creates a random 32 hex digit trace id.
+func newTraceID() string {
+	return fmt.Sprintf("%08x%08x%08x%08x", rand.Uint32(), rand.Uint32(), rand.Uint32(), rand.Uint32())
+}
+
+// newSpanID creates a random 16 hex digit span id.  The same format is used
+// for transaction ids.
+func newSpanID() string {
+	return fmt.Sprintf("%08x%08x", rand.Uint32(), rand.Uint32())
+}
+
+// traceParent is the content of the traceparent header:
+// {version}-{trace id}-{parent span id}-{flags}.
+type traceParent struct {
+	traceID  string
+	parentID string
+	sampled  bool
+}
+
+func parseTraceParent(header string) (*traceParent, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return nil, errTraceParentMalformed
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if 2 != len(version) || !isLowerHex(version) || "ff" == version {
+		return nil, errTraceParentMalformed
+	}
+	// Future versions may append fields, but version 00 has exactly
+	// four.
+	if traceParentVersion == version && 4 != len(parts) {
+		return nil, errTraceParentMalformed
+	}
+	if 32 != len(traceID) || !isLowerHex(traceID) || isAllZeros(traceID) {
+		return nil, errTraceParentMalformed
+	}
+	if 16 != len(parentID) || !isLowerHex(parentID) || isAllZeros(parentID) {
+		return nil, errTraceParentMalformed
+	}
+	if 2 != len(flags) || !isLowerHex(flags) {
+		return nil, errTraceParentMalformed
+	}
+	f, _ := strconv.ParseUint(flags, 16, 8)
+	return &traceParent{
+		traceID:  traceID,
+		parentID: parentID,
+		sampled:  0 != f&traceFlagSampled,
+	}, nil
+}
+
+// nrTraceState is the New Relic tracestate entry:
+// {trust key}@nr={version}-{parent type}-{account}-{app}-{span id}-{txn id}-
+// {sampled}-{priority}-{timestamp}.  The span id, transaction id, sampled and
+// priority fields are optional.
+type nrTraceState struct {
+	parentType  string
+	accountID   string
+	appID       string
+	spanID      string
+	txnID       string
+	hasSampled  bool
+	sampled     bool
+	hasPriority bool
+	priority    float32
+	timestamp   time.Time
+}
+
+func parseNRTraceState(value string) (*nrTraceState, error) {
+	fields := strings.Split(value, "-")
+	if len(fields) < 9 {
+		return nil, errTraceStateMalformed
+	}
+	state := &nrTraceState{
+		parentType: fields[1],
+		accountID:  fields[2],
+		appID:      fields[3],
+		spanID:     fields[4],
+		txnID:      fields[5],
+	}
+	if "" == state.parentType || "" == state.accountID || "" == state.appID {
+		return nil, errTraceStateMalformed
+	}
+	switch fields[6] {
+	case "1":
+		state.hasSampled, state.sampled = true, true
+	case "0":
+		state.hasSampled, state.sampled = true, false
+	}
+	if "" != fields[7] {
+		if p, err := strconv.ParseFloat(fields[7], 32); nil == err {
+			state.hasPriority = true
+			state.priority = float32(p)
+		}
+	}
+	ms, err := strconv.ParseInt(fields[8], 10, 64)
+	if nil != err {
+		return nil, errTraceStateMalformed
+	}
+	state.timestamp = timeFromUnixMilliseconds(ms)
+	return state, nil
+}
+
+// splitTraceState separates the entry for this account's trust key from the
+// other vendors' entries, which are propagated unchanged.
+func splitTraceState(header string, trustKey string) (nrValue string, others []string) {
+	nrKey := trustKey + traceStateVendorSuffix
+	for _, member := range strings.Split(header, ",") {
+		member = strings.TrimSpace(member)
+		if "" == member {
+			continue
+		}
+		if "" != trustKey && strings.HasPrefix(member, nrKey+"=") {
+			if "" == nrValue {
+				nrValue = member[len(nrKey)+1:]
+			}
+			continue
+		}
+		others = append(others, member)
+	}
+	return
+}
+
+func timeFromUnixMilliseconds(ms int64) time.Time {
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+func timeToUnixMilliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// txnDistributedTrace holds a transaction's trace context.
+type txnDistributedTrace struct {
+	traceID string
+	// txnID is reported as the guid of the transaction.
+	txnID      string
+	rootSpanID string
+	sampled    bool
+	priority   float32
+
+	// Inbound fields are populated if the transaction's request contains
+	// a valid traceparent header.
+	inbound      bool
+	parentSpanID string
+	// The parent fields are populated if the tracestate header contains
+	// a valid entry for the account's trust key.
+	parentTxnID     string
+	parentType      string
+	parentAccountID string
+	parentAppID     string
+	// traceState contains the tracestate entries of other vendors.
+	traceState []string
+}
+
+func traceStateTrustKey(reply *ConnectReply) string {
+	if "" != reply.TrustedAccountKey {
+		return reply.TrustedAccountKey
+	}
+	return reply.AccountID
+}
+
+// newTxnDistributedTrace creates the trace context of a transaction, which
+// continues the trace of the request's traceparent header if present.  The
+// sampler decides whether a transaction starting a new trace is sampled.
+func newTxnDistributedTrace(reply *ConnectReply, h http.Header, sampler *adaptiveSampler) txnDistributedTrace {
+	dt := txnDistributedTrace{
+		txnID:      newSpanID(),
+		rootSpanID: newSpanID(),
+	}
+
+	var parent *traceParent
+	if nil != h {
+		if header := h.Get(traceParentHeader); "" != header {
+			var err error
+			if parent, err = parseTraceParent(header); nil != err {
+				parent = nil
+			}
+		}
+	}
+
+	if nil == parent {
+		dt.traceID = newTraceID()
+		dt.priority = rand.Float32()
+		dt.sampled = sampler.computeSampled(dt.priority, time.Now())
+		if dt.sampled {
+			dt.priority++
+		}
+		return dt
+	}
+
+	dt.inbound = true
+	dt.traceID = parent.traceID
+	dt.parentSpanID = parent.parentID
+	dt.sampled = parent.sampled
+
+	nrValue, others := splitTraceState(h.Get(traceStateHeader), traceStateTrustKey(reply))
+	dt.traceState = others
+	var state *nrTraceState
+	if "" != nrValue {
+		state, _ = parseNRTraceState(nrValue)
+	}
+	if nil != state {
+		dt.parentTxnID = state.txnID
+		dt.parentType = state.parentType
+		dt.parentAccountID = state.accountID
+		dt.parentAppID = state.appID
+		if state.hasSampled {
+			dt.sampled = state.sampled
+		}
+	}
+	if nil != state && state.hasPriority {
+		dt.priority = state.priority
+	} else {
+		dt.priority = rand.Float32()
+		if dt.sampled {
+			dt.priority++
+		}
+	}
+	return dt
+}
+
+func formatPriority(p float32) string {
+	return strconv.FormatFloat(float64(p), 'f', -1, 32)
+}
+
+// outboundHeaders creates the traceparent and tracestate headers of an
+// external call made within the span given.  The New Relic tracestate entry is
+// omitted if the connect reply does not contain the account ids.
+func (dt *txnDistributedTrace) outboundHeaders(reply *ConnectReply, spanID string, now time.Time) (string, string) {
+	flags := 0
+	if dt.sampled {
+		flags |= traceFlagSampled
+	}
+	parent := fmt.Sprintf("%s-%s-%s-%02x", traceParentVersion, dt.traceID, spanID, flags)
+
+	members := make([]string, 0, len(dt.traceState)+1)
+	trustKey := traceStateTrustKey(reply)
+	if "" != trustKey && "" != reply.AccountID && "" != reply.PrimaryAppID {
+		sampled := "0"
+		if dt.sampled {
+			sampled = "1"
+		}
+		members = append(members, trustKey+traceStateVendorSuffix+"="+strings.Join([]string{
+			traceStateVersion,
+			traceStateParentTypeApp,
+			reply.AccountID,
+			reply.PrimaryAppID,
+			spanID,
+			dt.txnID,
+			sampled,
+			formatPriority(dt.priority),
+			strconv.FormatInt(timeToUnixMilliseconds(now), 10),
+		}, "-"))
+	}
+	for _, m := range dt.traceState {
+		if len(members) >= maxTraceStateEntries {
+			break
+		}
+		members = append(members, m)
+	}
+	return parent, strings.Join(members, ",")
+}
+
+// writeIntrinsics adds the trace context to a transaction or error event.
+// The buffer must be within an object which already has fields.
+func (dt *txnDistributedTrace) writeIntrinsics(buf *bytes.Buffer) {
+	w := jsonFieldsWriter{buf: buf, needsComma: true}
+	w.stringField("traceId", dt.traceID)
+	w.stringField("guid", dt.txnID)
+	w.boolField("sampled", dt.sampled)
+	w.floatField("priority", float64(dt.priority))
+	if "" != dt.parentTxnID {
+		w.stringField("parentId", dt.parentTxnID)
+	}
+	if "" != dt.parentSpanID {
+		w.stringField("parentSpanId", dt.parentSpanID)
+	}
+	if "" != dt.parentType {
+		w.stringField("parent.type", traceStateParentTypeName(dt.parentType))
+		w.stringField("parent.account", dt.parentAccountID)
+		w.stringField("parent.app", dt.parentAppID)
+	}
+}
+
+var traceStateParentTypeNames = map[string]string{
+	"0": "App",
+	"1": "Browser",
+	"2": "Mobile",
+}
+
+func traceStateParentTypeName(parentType string) string {
+	if name, ok := traceStateParentTypeNames[parentType]; ok {
+		return name
+	}
+	return "Unknown"
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tcs := []struct {
+		input   string
+		valid   bool
+		sampled bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true, true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", true, false},
+		{" 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-03 ", true, true},
+		// Future versions may have additional fields.
+		{"cc-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-what", true, true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-what", false, false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false, false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", false, false},
+		{"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c8031-b7ad6b7169203331-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-1", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331", false, false},
+		{"", false, false},
+	}
+	for _, tc := range tcs {
+		p, err := parseTraceParent(tc.input)
+		if (nil == err) != tc.valid {
+			t.Error(tc.input, err)
+			continue
+		}
+		if nil != p && p.sampled != tc.sampled {
+			t.Error(tc.input, p.sampled)
+		}
+	}
+}
+
+func TestParseNRTraceState(t *testing.T) {
+	state, err := parseNRTraceState("0-0-33-5043-27ddd2d8890283b4-5569065a5b1313bd-1-1.23456-1518469636025")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if state.parentType != "0" || state.accountID != "33" || state.appID != "5043" ||
+		state.spanID != "27ddd2d8890283b4" || state.txnID != "5569065a5b1313bd" ||
+		!state.hasSampled || !state.sampled || !state.hasPriority ||
+		state.priority != 1.23456 || timeToUnixMilliseconds(state.timestamp) != 1518469636025 {
+		t.Errorf("%+v", state)
+	}
+
+	// The span id, transaction id, sampled and priority fields are
+	// optional.
+	state, err = parseNRTraceState("0-0-33-5043-----1518469636025")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if state.hasSampled || state.hasPriority || "" != state.txnID {
+		t.Errorf("%+v", state)
+	}
+
+	for _, input := range []string{
+		"",
+		"0-0-33-5043-27ddd2d8890283b4-5569065a5b1313bd-1-1.23456",
+		"0--33-5043-27ddd2d8890283b4-5569065a5b1313bd-1-1.23456-1518469636025",
+		"0-0-33-5043-27ddd2d8890283b4-5569065a5b1313bd-1-1.23456-notatime",
+	} {
+		if _, err := parseNRTraceState(input); nil == err {
+			t.Error(input)
+		}
+	}
+}
+
+func TestSplitTraceState(t *testing.T) {
+	nr, others := splitTraceState("rojo=00f067aa0ba902b7, 33@nr=value,,congo=t61rcWkgMzE,33@nr=second", "33")
+	if nr != "value" {
+		t.Error(nr)
+	}
+	if strings.Join(others, ",") != "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE" {
+		t.Error(others)
+	}
+	nr, others = splitTraceState("44@nr=value", "33")
+	if "" != nr || 1 != len(others) {
+		t.Error(nr, others)
+	}
+}
+
+func dtTestReply() *ConnectReply {
+	reply := connectReplyDefaults()
+	reply.AccountID = "123"
+	reply.TrustedAccountKey = "33"
+	reply.PrimaryAppID = "456"
+	return reply
+}
+
+func TestNewTxnDistributedTraceNoHeaders(t *testing.T) {
+	dt := newTxnDistributedTrace(dtTestReply(), nil, newAdaptiveSampler(time.Minute, 10, time.Now()))
+	if dt.inbound || !dt.sampled || dt.priority < 1.0 ||
+		32 != len(dt.traceID) || 16 != len(dt.txnID) || 16 != len(dt.rootSpanID) {
+		t.Errorf("%+v", dt)
+	}
+}
+
+func TestNewTxnDistributedTraceNotSampled(t *testing.T) {
+	sampler := newAdaptiveSampler(time.Minute, 1, time.Now())
+	first := newTxnDistributedTrace(dtTestReply(), nil, sampler)
+	second := newTxnDistributedTrace(dtTestReply(), nil, sampler)
+	if !first.sampled || first.priority < 1.0 {
+		t.Errorf("%+v", first)
+	}
+	if second.sampled || second.priority >= 1.0 {
+		t.Errorf("%+v", second)
+	}
+	parent, _ := second.outboundHeaders(dtTestReply(), second.rootSpanID, time.Now())
+	if !strings.HasSuffix(parent, "-00") {
+		t.Error(parent)
+	}
+}
+
+func TestNewTxnDistributedTraceInbound(t *testing.T) {
+	h := make(http.Header)
+	h.Set(traceParentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	h.Set(traceStateHeader, "rojo=00f067aa0ba902b7,33@nr=0-0-33-5043-27ddd2d8890283b4-5569065a5b1313bd-0-0.5-1518469636025")
+	dt := newTxnDistributedTrace(dtTestReply(), h, newAdaptiveSampler(time.Minute, 10, time.Now()))
+	if !dt.inbound || dt.traceID != "0af7651916cd43dd8448eb211c80319c" ||
+		dt.parentSpanID != "b7ad6b7169203331" || dt.parentTxnID != "5569065a5b1313bd" ||
+		dt.parentAccountID != "33" || dt.parentAppID != "5043" ||
+		dt.sampled || dt.priority != 0.5 {
+		t.Errorf("%+v", dt)
+	}
+	if 1 != len(dt.traceState) || dt.traceState[0] != "rojo=00f067aa0ba902b7" {
+		t.Error(dt.traceState)
+	}
+}
+
+func TestNewTxnDistributedTraceInboundW3COnly(t *testing.T) {
+	h := make(http.Header)
+	h.Set(traceParentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	h.Set(traceStateHeader, "rojo=00f067aa0ba902b7")
+	dt := newTxnDistributedTrace(dtTestReply(), h, newAdaptiveSampler(time.Minute, 10, time.Now()))
+	if !dt.inbound || !dt.sampled || dt.priority < 1.0 || "" != dt.parentTxnID {
+		t.Errorf("%+v", dt)
+	}
+}
+
+func TestNewTxnDistributedTraceInvalidParent(t *testing.T) {
+	h := make(http.Header)
+	h.Set(traceParentHeader, "garbage")
+	h.Set(traceStateHeader, "33@nr=0-0-33-5043-27ddd2d8890283b4-5569065a5b1313bd-0-0.5-1518469636025")
+	dt := newTxnDistributedTrace(dtTestReply(), h, newAdaptiveSampler(time.Minute, 10, time.Now()))
+	if dt.inbound || "" != dt.parentTxnID || 0 != len(dt.traceState) {
+		t.Errorf("%+v", dt)
+	}
+}
+
+func TestOutboundHeaders(t *testing.T) {
+	dt := txnDistributedTrace{
+		traceID:    "0af7651916cd43dd8448eb211c80319c",
+		txnID:      "5569065a5b1313bd",
+		sampled:    true,
+		priority:   1.5,
+		traceState: []string{"rojo=00f067aa0ba902b7"},
+	}
+	now := timeFromUnixMilliseconds(1518469636025)
+	parent, state := dt.outboundHeaders(dtTestReply(), "27ddd2d8890283b4", now)
+	if parent != "00-0af7651916cd43dd8448eb211c80319c-27ddd2d8890283b4-01" {
+		t.Error(parent)
+	}
+	if state != "33@nr=0-0-123-456-27ddd2d8890283b4-5569065a5b1313bd-1-1.5-1518469636025,rojo=00f067aa0ba902b7" {
+		t.Error(state)
+	}
+
+	// The New Relic entry requires the account ids.
+	dt.sampled = false
+	parent, state = dt.outboundHeaders(connectReplyDefaults(), "27ddd2d8890283b4", now)
+	if parent != "00-0af7651916cd43dd8448eb211c80319c-27ddd2d8890283b4-00" {
+		t.Error(parent)
+	}
+	if state != "rojo=00f067aa0ba902b7" {
+		t.Error(state)
+	}
+}
+
+func TestOutboundHeadersTraceStateLimit(t *testing.T) {
+	dt := txnDistributedTrace{
+		traceID: "0af7651916cd43dd8448eb211c80319c",
+		txnID:   "5569065a5b1313bd",
+	}
+	for i := 0; i < 2*maxTraceStateEntries; i++ {
+		dt.traceState = append(dt.traceState, "vendor=value")
+	}
+	_, state := dt.outboundHeaders(dtTestReply(), "27ddd2d8890283b4", time.Now())
+	if n := len(strings.Split(state, ",")); n != maxTraceStateEntries {
+		t.Error(n)
+	}
+}
+
+func TestDistributedTraceIntrinsics(t *testing.T) {
+	dt := txnDistributedTrace{
+		traceID:         "0af7651916cd43dd8448eb211c80319c",
+		txnID:           "5569065a5b1313bd",
+		sampled:         true,
+		priority:        1.5,
+		parentSpanID:    "b7ad6b7169203331",
+		parentTxnID:     "27ddd2d8890283b4",
+		parentType:      "0",
+		parentAccountID: "33",
+		parentAppID:     "5043",
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"type":"Transaction"`)
+	dt.writeIntrinsics(buf)
+	buf.WriteByte('}')
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); nil != err {
+		t.Fatal(buf.String(), err)
+	}
+	expect := map[string]interface{}{
+		"type":           "Transaction",
+		"traceId":        "0af7651916cd43dd8448eb211c80319c",
+		"guid":           "5569065a5b1313bd",
+		"sampled":        true,
+		"priority":       1.5,
+		"parentId":       "27ddd2d8890283b4",
+		"parentSpanId":   "b7ad6b7169203331",
+		"parent.type":    "App",
+		"parent.account": "33",
+		"parent.app":     "5043",
+	}
+	if len(fields) != len(expect) {
+		t.Error(fields)
+	}
+	for key, val := range expect {
+		if fields[key] != val {
+			t.Error(key, fields[key], val)
+		}
+	}
+}
@@ -16,6 +16,11 @@ type errorEvent struct {
 	duration time.Duration
 	queuing  time.Duration
 	attrs    *attributes
+	expected bool
+	// userAttrs are the attributes provided by the error.
+	userAttrs map[string]userAttribute
+	cat       *catIntrinsics
+	dt        *txnDistributedTrace
 	datastoreExternalTotals
 }
 
@@ -65,9 +70,21 @@ func (e *errorEvent) WriteJSON(buf *bytes.Buffer) {
 		jsonx.AppendFloat(buf, e.datastoreDuration.Seconds())
 	}
 
+	if e.expected {
+		buf.WriteString(`,"error.expected":true`)
+	}
+
+	if nil != e.cat {
+		e.cat.writeErrorEventFields(buf)
+	}
+
+	if nil != e.dt {
+		e.dt.writeIntrinsics(buf)
+	}
+
 	buf.WriteByte('}')
 	buf.WriteByte(',')
-	userAttributesJSON(e.attrs, buf, destError)
+	errorUserAttributesJSON(e.attrs, e.userAttrs, buf)
 	buf.WriteByte(',')
 	agentAttributesJSON(e.attrs, buf, destError)
 	buf.WriteByte(']')
@@ -89,12 +106,20 @@ func (events *errorEvents) Add(e *errorEvent) {
 }
 
 func (events *errorEvents) mergeIntoHarvest(h *harvest) {
-	h.errorEvents.events.MergeFailed(events.events)
+	h.errorEvents.events.MergeFailed(events.events, h.limits.failedEventAttempts)
 }
 
 func (events *errorEvents) Data(agentRunID string, harvestStart time.Time) ([]byte, error) {
 	return events.events.CollectorJSON(agentRunID)
 }
 
+func (events *errorEvents) split() (payloadCreator, payloadCreator) {
+	first, second := events.events.split()
+	if nil == first {
+		return nil, nil
+	}
+	return &errorEvents{events: first}, &errorEvents{events: second}
+}
+
 func (events *errorEvents) numSeen() float64  { return events.events.NumSeen() }
 func (events *errorEvents) numSaved() float64 { return events.events.NumSaved() }
@@ -91,6 +91,26 @@ func TestErrorEventMarshal(t *testing.T) {
 		{},
 		{}
 	]`)
+	testErrorEventJSON(t, &errorEvent{
+		klass:    "*errors.errorString",
+		msg:      "hello",
+		when:     time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC),
+		txnName:  "myName",
+		duration: 3 * time.Second,
+		expected: true,
+	}, `[
+		{
+			"type":"TransactionError",
+			"error.class":"*errors.errorString",
+			"error.message":"hello",
+			"timestamp":1.41713646e+09,
+			"transactionName":"myName",
+			"duration":3,
+			"error.expected":true
+		},
+		{},
+		{}
+	]`)
 }
 
 func TestErrorEventAttributes(t *testing.T) {
@@ -1,18 +1,26 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
+
+	"github.com/newrelic/go-agent/api"
 )
 
 const (
 	// PanicErrorKlass is the error klass used for errors generated by
 	// recovering panics in txn.End.
 	PanicErrorKlass = "panic"
+
+	// unknownTxnName is the transaction name of errors noticed outside of
+	// a transaction.
+	unknownTxnName = backgroundMetricPrefix + "/unknown"
 )
 
 func panicValueMsg(v interface{}) string {
@@ -31,11 +39,114 @@ func txnErrorFromPanic(v interface{}) txnError {
 	}
 }
 
+type unwrapper interface {
+	Unwrap() error
+}
+
+// errorCauses returns the error followed by the chain of errors which caused
+// it, as returned by their Unwrap methods.
+func errorCauses(err error) []error {
+	causes := []error{err}
+	for len(causes) < maxErrorCauses {
+		u, ok := err.(unwrapper)
+		if !ok {
+			break
+		}
+		if err = u.Unwrap(); nil == err {
+			break
+		}
+		causes = append(causes, err)
+	}
+	return causes
+}
+
+// txnErrorFromError creates a txnError using the optional interfaces of the
+// api package implemented by the error and its causes.  The stack is only
+// set if provided by the error.
 func txnErrorFromError(err error) txnError {
-	return txnError{
+	causes := errorCauses(err)
+	root := causes[len(causes)-1]
+	e := txnError{
 		msg:   err.Error(),
-		klass: reflect.TypeOf(err).String(),
+		klass: reflect.TypeOf(root).String(),
+	}
+	for _, cause := range causes {
+		if c, ok := cause.(api.ErrorClasser); ok {
+			if klass := c.ErrorClass(); "" != klass {
+				e.klass = klass
+				break
+			}
+		}
+	}
+	for i := len(causes) - 1; i >= 0; i-- {
+		if s, ok := causes[i].(api.StackTracer); ok {
+			if callers := s.StackTrace(); len(callers) > 0 {
+				if len(callers) > maxStackTraceFrames {
+					callers = callers[0:maxStackTraceFrames]
+				}
+				e.stack = &stackTrace{callers: callers, written: len(callers)}
+				break
+			}
+		}
+	}
+	return e
+}
+
+// errorUserAttributes returns the valid attributes provided by the error and
+// its causes which are destined for errors.
+func errorUserAttributes(config *attributeConfig, err error) map[string]userAttribute {
+	a := &attributes{config: config}
+	causes := errorCauses(err)
+	for i := len(causes) - 1; i >= 0; i-- {
+		if c, ok := causes[i].(api.ErrorAttributer); ok {
+			for key, val := range c.ErrorAttributes() {
+				addUserAttribute(a, key, val, destError)
+			}
+		}
+	}
+	return a.user
+}
+
+// errorUserAttributesJSON writes the user attributes of a transaction together
+// with those of one of its errors, which take precedence.  The merged
+// attributes are limited to attributeUserLimit:  Transaction attributes are
+// only added while there is room after the error's, in the order of their
+// keys so that the same attributes are kept each time the error is written.
+func errorUserAttributesJSON(a *attributes, errorAttrs map[string]userAttribute, buf *bytes.Buffer) {
+	if 0 == len(errorAttrs) {
+		userAttributesJSON(a, buf, destError)
+		return
+	}
+	merged := &attributes{user: make(map[string]userAttribute)}
+	for key, val := range errorAttrs {
+		merged.user[key] = val
+	}
+	if nil != a {
+		var keys []string
+		for key, val := range a.user {
+			if _, exists := merged.user[key]; !exists && 0 != val.dests&destError {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if len(merged.user) >= attributeUserLimit {
+				break
+			}
+			merged.user[key] = a.user[key]
+		}
 	}
+	userAttributesJSON(merged, buf, destError)
+}
+
+func getErrorUserAttributes(a *attributes, errorAttrs map[string]userAttribute) (map[string]interface{}, error) {
+	buf := &bytes.Buffer{}
+	errorUserAttributesJSON(a, errorAttrs, buf)
+	v := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &v); nil != err {
+		return nil, err
+	}
+	return v, nil
 }
 
 func txnErrorFromResponseCode(code int) txnError {
@@ -50,6 +161,11 @@ type txnError struct {
 	stack *stackTrace
 	msg   string
 	klass string
+	// expected errors are recorded but do not affect error metrics or
+	// apdex.
+	expected bool
+	// userAttrs are the attributes provided by the error.
+	userAttrs map[string]userAttribute
 }
 
 type txnErrors []*txnError
@@ -64,7 +180,14 @@ func (errors *txnErrors) Add(e *txnError) {
 	}
 }
 
+// errorIntrinsics are the intrinsics of a traced error.
+type errorIntrinsics struct {
+	Expected bool `json:"error.expected,omitempty"`
+}
+
 func (h *harvestError) MarshalJSON() ([]byte, error) {
+	user := &bytes.Buffer{}
+	errorUserAttributesJSON(h.attrs, h.userAttrs, user)
 	return json.Marshal(
 		[]interface{}{
 			timeToFloatMilliseconds(h.when),
@@ -72,15 +195,16 @@ func (h *harvestError) MarshalJSON() ([]byte, error) {
 			h.msg,
 			h.klass,
 			struct {
-				Stack      *stackTrace `json:"stack_trace"`
-				Agent      JSONString  `json:"agentAttributes"`
-				User       JSONString  `json:"userAttributes"`
-				Intrinsics struct{}    `json:"intrinsics"`
-				RequestURI string      `json:"request_uri,omitempty"`
+				Stack      *stackTrace     `json:"stack_trace"`
+				Agent      JSONString      `json:"agentAttributes"`
+				User       JSONString      `json:"userAttributes"`
+				Intrinsics errorIntrinsics `json:"intrinsics"`
+				RequestURI string          `json:"request_uri,omitempty"`
 			}{
 				Stack:      h.stack,
-				User:       userAttributesStringJSON(h.attrs, destError),
+				User:       JSONString(user.Bytes()),
 				Agent:      agentAttributesStringJSON(h.attrs, destError),
+				Intrinsics: errorIntrinsics{Expected: h.expected},
 				RequestURI: h.requestURI,
 			},
 		})
@@ -134,3 +258,32 @@ func (errors *harvestErrors) Data(agentRunID string, harvestStart time.Time) ([]
 }
 
 func (errors *harvestErrors) mergeIntoHarvest(h *harvest) {}
+
+// appError is an error noticed by the application outside of a transaction.
+type appError struct {
+	txnError
+	attrs       *attributes
+	errorEvents bool
+}
+
+func (e *appError) mergeIntoHarvest(h *harvest) {
+	if !e.expected {
+		h.metrics.addSingleCount(errorsAll, forced)
+		h.metrics.addSingleCount(errorsBackground, forced)
+		h.metrics.addSingleCount(errorsPrefix+unknownTxnName, forced)
+	}
+
+	mergeTxnErrors(h.errorTraces, txnErrors{&e.txnError}, unknownTxnName, "", e.attrs)
+
+	if e.errorEvents {
+		h.errorEvents.Add(&errorEvent{
+			klass:     e.klass,
+			msg:       e.msg,
+			when:      e.when,
+			txnName:   unknownTxnName,
+			attrs:     e.attrs,
+			expected:  e.expected,
+			userAttrs: e.userAttrs,
+		})
+	}
+}
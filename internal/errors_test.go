@@ -1,8 +1,11 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
@@ -172,3 +175,172 @@ func TestErrorsLifecycle(t *testing.T) {
 		t.Error(string(js))
 	}
 }
+
+func TestErrorTraceExpected(t *testing.T) {
+	e := &txnError{
+		when:     time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC),
+		msg:      "my_msg",
+		klass:    "my_class",
+		expected: true,
+	}
+	he := harvestErrorFromTxnError(e, "my_txn_name", "my_request_uri", nil)
+	js, err := json.Marshal(he)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var fields []interface{}
+	if err := json.Unmarshal(js, &fields); nil != err {
+		t.Fatal(string(js), err)
+	}
+	intrinsics := fields[4].(map[string]interface{})["intrinsics"].(map[string]interface{})
+	if v := intrinsics["error.expected"]; true != v {
+		t.Error(string(js))
+	}
+}
+
+type classError struct {
+	msg   string
+	klass string
+	cause error
+	attrs map[string]interface{}
+	stack []uintptr
+}
+
+func (e classError) Error() string                           { return e.msg }
+func (e classError) ErrorClass() string                      { return e.klass }
+func (e classError) ErrorAttributes() map[string]interface{} { return e.attrs }
+func (e classError) StackTrace() []uintptr                   { return e.stack }
+func (e classError) Unwrap() error                           { return e.cause }
+
+type wrappedError struct{ cause error }
+
+func (e wrappedError) Error() string { return "wrapped: " + e.cause.Error() }
+func (e wrappedError) Unwrap() error { return e.cause }
+
+func TestTxnErrorFromErrorRootCause(t *testing.T) {
+	e := txnErrorFromError(wrappedError{cause: wrappedError{cause: errors.New("hello")}})
+	if e.msg != "wrapped: wrapped: hello" || e.klass != "*errors.errorString" || nil != e.stack {
+		t.Error(e.msg, e.klass, e.stack)
+	}
+}
+
+func TestTxnErrorFromErrorClass(t *testing.T) {
+	e := txnErrorFromError(classError{msg: "hello", klass: "my_class"})
+	if e.klass != "my_class" {
+		t.Error(e.klass)
+	}
+	// An empty class is ignored.
+	e = txnErrorFromError(classError{msg: "hello"})
+	if e.klass != "internal.classError" {
+		t.Error(e.klass)
+	}
+	e = txnErrorFromError(wrappedError{cause: classError{msg: "hello", klass: "my_class"}})
+	if e.klass != "my_class" {
+		t.Error(e.klass)
+	}
+}
+
+func TestTxnErrorFromErrorStackTrace(t *testing.T) {
+	inner := getStackTrace(0).callers
+	outer := []uintptr{1, 2, 3}
+	e := txnErrorFromError(classError{
+		stack: outer,
+		cause: classError{stack: inner},
+	})
+	if nil == e.stack || len(e.stack.callers) != len(inner) || e.stack.callers[0] != inner[0] {
+		t.Error(e.stack)
+	}
+}
+
+func TestErrorUserAttributes(t *testing.T) {
+	input := sampleAttributeConfigInput
+	input.errorCollector.Exclude = []string{"excluded"}
+	cfg := createAttributeConfig(input)
+	err := classError{
+		attrs: map[string]interface{}{
+			"zip":      "outer",
+			"excluded": 1,
+			"invalid":  struct{}{},
+		},
+		cause: classError{attrs: map[string]interface{}{
+			"zip":   "inner",
+			"inner": true,
+		}},
+	}
+	attrs := newAttributes(cfg)
+	addUserAttribute(attrs, "zip", "txn", destAll)
+	addUserAttribute(attrs, "txn", 1, destAll)
+
+	buf := &bytes.Buffer{}
+	errorUserAttributesJSON(attrs, errorUserAttributes(cfg, err), buf)
+	var actual map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &actual); nil != err {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"zip":   "outer",
+		"inner": true,
+		"txn":   1.0,
+	}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Error(actual)
+	}
+}
+
+func TestErrorUserAttributesLimit(t *testing.T) {
+	cfg := createAttributeConfig(sampleAttributeConfigInput)
+	errAttrs := make(map[string]interface{})
+	attrs := newAttributes(cfg)
+	for i := 0; i < attributeUserLimit; i++ {
+		errAttrs["err"+strconv.Itoa(i)] = i
+	}
+	addUserAttribute(attrs, "err0", "txn", destAll)
+	for i := 1; i < attributeUserLimit; i++ {
+		addUserAttribute(attrs, "txn"+strconv.Itoa(i), i, destAll)
+	}
+	err := classError{attrs: errAttrs}
+
+	buf := &bytes.Buffer{}
+	errorUserAttributesJSON(attrs, errorUserAttributes(cfg, err), buf)
+	var actual map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &actual); nil != err {
+		t.Fatal(err)
+	}
+	if len(actual) != attributeUserLimit {
+		t.Error(len(actual))
+	}
+	// The error's attributes take precedence over the transaction's.
+	for key := range errAttrs {
+		if _, ok := actual[key]; !ok {
+			t.Error(key)
+		}
+	}
+	if actual["err0"] != 0.0 {
+		t.Error(actual["err0"])
+	}
+}
+
+func TestErrorUserAttributesLimitOrder(t *testing.T) {
+	cfg := createAttributeConfig(sampleAttributeConfigInput)
+	errAttrs := make(map[string]interface{})
+	for i := 0; i < attributeUserLimit-2; i++ {
+		errAttrs["err"+strconv.Itoa(i)] = i
+	}
+	attrs := newAttributes(cfg)
+	for _, key := range []string{"d", "b", "c", "a"} {
+		addUserAttribute(attrs, key, key, destAll)
+	}
+	userAttrs := errorUserAttributes(cfg, classError{attrs: errAttrs})
+
+	// The transaction attributes kept are those with the lowest keys.
+	for i := 0; i < 10; i++ {
+		actual, err := getErrorUserAttributes(attrs, userAttrs)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if len(actual) != attributeUserLimit ||
+			actual["a"] != "a" || actual["b"] != "b" {
+			t.Fatal(actual)
+		}
+	}
+}
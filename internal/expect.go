@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -61,6 +63,8 @@ type WantErrorEvent struct {
 	DatastoreCallCount uint64
 	UserAttributes     map[string]interface{}
 	AgentAttributes    map[string]interface{}
+	// Intrinsics are compared using expectIntrinsics.
+	Intrinsics map[string]interface{}
 }
 
 // WantTxnEvent is a transaction event expectation.
@@ -72,6 +76,42 @@ type WantTxnEvent struct {
 	DatastoreCallCount uint64
 	UserAttributes     map[string]interface{}
 	AgentAttributes    map[string]interface{}
+	// CrossProcess indicates whether the transaction was involved in
+	// cross application tracing.  If it was and ReferringTxnGUID is
+	// non-empty, the referring transaction guid must match.
+	CrossProcess     bool
+	ReferringTxnGUID string
+	// Intrinsics are compared using expectIntrinsics.
+	Intrinsics map[string]interface{}
+}
+
+// WantTxnTrace is a transaction trace expectation.
+type WantTxnTrace struct {
+	MetricName      string
+	CleanURL        string
+	NumSegments     int
+	UserAttributes  map[string]interface{}
+	AgentAttributes map[string]interface{}
+}
+
+// WantSlowQuery is a slow query expectation.
+type WantSlowQuery struct {
+	Count      int64
+	MetricName string
+	Query      string
+	TxnName    string
+	TxnURL     string
+}
+
+// WantSpanEvent is a span event expectation.  Span events are expected in
+// the order they were added to the harvest: The transaction's span event is
+// followed by its segments' span events.
+type WantSpanEvent struct {
+	Name         string
+	Category     string
+	IsEntrypoint bool
+	// Intrinsics are compared using expectIntrinsics.
+	Intrinsics map[string]interface{}
 }
 
 // Expect exposes methods that allow for testing whether the correct data was
@@ -82,6 +122,9 @@ type Expect interface {
 	ExpectErrorEvents(t validator, want []WantErrorEvent)
 	ExpectTxnEvents(t validator, want []WantTxnEvent)
 	ExpectMetrics(t validator, want []WantMetric)
+	ExpectTxnTraces(t validator, want []WantTxnTrace)
+	ExpectSlowQueries(t validator, want []WantSlowQuery)
+	ExpectSpanEvents(t validator, want []WantSpanEvent)
 }
 
 // ExpectCustomEvents implement Expect's ExpectCustomEvents.
@@ -109,6 +152,21 @@ func (app *App) ExpectMetrics(t validator, want []WantMetric) {
 	expectMetrics(addValidatorField{`metrics:`, t}, app.testHarvest.metrics, want)
 }
 
+// ExpectTxnTraces implement Expect's ExpectTxnTraces.
+func (app *App) ExpectTxnTraces(t validator, want []WantTxnTrace) {
+	expectTxnTraces(addValidatorField{`txn traces:`, t}, app.testHarvest.txnTraces, want)
+}
+
+// ExpectSlowQueries implement Expect's ExpectSlowQueries.
+func (app *App) ExpectSlowQueries(t validator, want []WantSlowQuery) {
+	expectSlowQueries(addValidatorField{`slow queries:`, t}, app.testHarvest.slowSQLs, want)
+}
+
+// ExpectSpanEvents implement Expect's ExpectSpanEvents.
+func (app *App) ExpectSpanEvents(t validator, want []WantSpanEvent) {
+	expectSpanEvents(addValidatorField{`span events:`, t}, app.testHarvest.spanEvents, want)
+}
+
 func expectMetricField(t validator, id metricID, v1, v2 float64, fieldName string) {
 	if v1 != v2 {
 		t.Error("metric fields do not match", id, v1, v2, fieldName)
@@ -199,6 +257,35 @@ func expectCustomEvents(v validator, cs *customEvents, expect []WantCustomEvent)
 	}
 }
 
+// expectIntrinsics checks the intrinsics object of an event's JSON.  Each
+// expected key must be present, and must have the value given unless the
+// expected value is nil.
+func expectIntrinsics(v validator, w jsonWriter, expect map[string]interface{}) {
+	if nil == expect {
+		return
+	}
+	buf := &bytes.Buffer{}
+	w.WriteJSON(buf)
+	var fields []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); nil != err || 0 == len(fields) {
+		v.Error("unable to parse event", buf.String(), err)
+		return
+	}
+	for key, val := range expect {
+		found, ok := fields[0][key]
+		if !ok {
+			v.Error("missing intrinsic", key)
+			continue
+		}
+		if nil == val {
+			continue
+		}
+		if v1, v2 := fmt.Sprint(found), fmt.Sprint(val); v1 != v2 {
+			v.Error("intrinsic difference", fmt.Sprintf("key=%s", key), v1, v2)
+		}
+	}
+}
+
 func expectErrorEvent(v validator, err *errorEvent, expect WantErrorEvent) {
 	validateStringField(v, "txnName", expect.TxnName, err.txnName)
 	validateStringField(v, "klass", expect.Klass, err.klass)
@@ -207,7 +294,11 @@ func expectErrorEvent(v validator, err *errorEvent, expect WantErrorEvent) {
 		v.Error("queuing", err.queuing)
 	}
 	if nil != expect.UserAttributes {
-		expectAttributes(v, getUserAttributes(err.attrs, destError), expect.UserAttributes)
+		attrs, e := getErrorUserAttributes(err.attrs, err.userAttrs)
+		if nil != e {
+			v.Error("unable to unmarshal error user attributes", e)
+		}
+		expectAttributes(v, attrs, expect.UserAttributes)
 	}
 	if nil != expect.AgentAttributes {
 		expectAttributes(v, getAgentAttributes(err.attrs, destError), expect.AgentAttributes)
@@ -224,6 +315,7 @@ func expectErrorEvent(v validator, err *errorEvent, expect WantErrorEvent) {
 	if (0 == expect.DatastoreCallCount) != (err.datastoreDuration == 0) {
 		v.Error("datastore duration", err.datastoreDuration)
 	}
+	expectIntrinsics(v, err, expect.Intrinsics)
 }
 
 func expectErrorEvents(v validator, events *errorEvents, expect []WantErrorEvent) {
@@ -269,6 +361,12 @@ func expectTxnEvent(v validator, e *txnEvent, expect WantTxnEvent) {
 	if (0 == expect.DatastoreCallCount) != (e.datastoreDuration == 0) {
 		v.Error("datastore duration", e.datastoreDuration)
 	}
+	if expect.CrossProcess != (nil != e.cat) {
+		v.Error("cross process", expect.CrossProcess, e.cat)
+	} else if nil != e.cat && "" != expect.ReferringTxnGUID {
+		validateStringField(v, "referring txn guid", expect.ReferringTxnGUID, e.cat.referringGUID)
+	}
+	expectIntrinsics(v, e, expect.Intrinsics)
 }
 
 func expectTxnEvents(v validator, events *txnEvents, expect []WantTxnEvent) {
@@ -295,7 +393,11 @@ func expectError(v validator, err *harvestError, expect WantError) {
 	validateStringField(v, "msg", expect.Msg, err.txnError.msg)
 	validateStringField(v, "URL", expect.URL, err.requestURI)
 	if nil != expect.UserAttributes {
-		expectAttributes(v, getUserAttributes(err.attrs, destError), expect.UserAttributes)
+		attrs, e := getErrorUserAttributes(err.attrs, err.userAttrs)
+		if nil != e {
+			v.Error("unable to unmarshal error user attributes", e)
+		}
+		expectAttributes(v, attrs, expect.UserAttributes)
 	}
 	if nil != expect.AgentAttributes {
 		expectAttributes(v, getAgentAttributes(err.attrs, destError), expect.AgentAttributes)
@@ -311,3 +413,89 @@ func expectErrors(v validator, errors *harvestErrors, expect []WantError) {
 		expectError(v, errors.errors[i], e)
 	}
 }
+
+func expectTxnTrace(v validator, trace *harvestTrace, expect WantTxnTrace) {
+	if 0 == trace.duration {
+		v.Error("zero trace duration")
+	}
+	validateStringField(v, "metric name", expect.MetricName, trace.finalName)
+	validateStringField(v, "request url", expect.CleanURL, trace.cleanURL)
+	if expect.NumSegments != len(trace.trace.nodes) {
+		v.Error("number of segments", expect.NumSegments, len(trace.trace.nodes))
+	}
+	if nil != expect.UserAttributes {
+		expectAttributes(v, getUserAttributes(trace.attrs, destTxnTrace), expect.UserAttributes)
+	}
+	if nil != expect.AgentAttributes {
+		expectAttributes(v, getAgentAttributes(trace.attrs, destTxnTrace), expect.AgentAttributes)
+	}
+}
+
+func expectTxnTraces(v validator, traces *harvestTraces, want []WantTxnTrace) {
+	saved := traces.saved()
+	if len(saved) != len(want) {
+		v.Error("number of traces do not match", len(saved), len(want))
+		return
+	}
+	for i, e := range want {
+		expectTxnTrace(v, saved[i], e)
+	}
+}
+
+func expectSlowQuery(v validator, slowQuery *slowQuery, want WantSlowQuery) {
+	if slowQuery.count != want.Count {
+		v.Error("wrong Count field", slowQuery.count, want.Count)
+	}
+	validateStringField(v, "MetricName", want.MetricName, slowQuery.metricName)
+	validateStringField(v, "Query", want.Query, slowQuery.query)
+	validateStringField(v, "TxnName", want.TxnName, slowQuery.txnName)
+	validateStringField(v, "TxnURL", want.TxnURL, slowQuery.txnURL)
+	if nil == slowQuery.stack || 0 == len(slowQuery.stack.callers) {
+		v.Error("missing stack trace")
+	}
+}
+
+func expectSlowQueries(v validator, slowQueries *slowQueries, want []WantSlowQuery) {
+	if len(want) != len(slowQueries.lookup) {
+		v.Error("number of slow queries mismatch", len(slowQueries.lookup), len(want))
+		return
+	}
+	for _, s := range want {
+		idx, ok := slowQueries.lookup[makeSlowQueryID(s.Query)]
+		if !ok {
+			// The query may have been recorded raw: find it by
+			// value.
+			for _, q := range slowQueries.lookup {
+				if q.query == s.Query {
+					idx, ok = q, true
+				}
+			}
+		}
+		if !ok {
+			v.Error("unable to find slow query", s.Query)
+			continue
+		}
+		expectSlowQuery(v, idx, s)
+	}
+}
+
+func expectSpanEvents(v validator, events *spanEvents, want []WantSpanEvent) {
+	if len(*events.events.events) != len(want) {
+		v.Error("number of span events does not match",
+			len(*events.events.events), len(want))
+		return
+	}
+	for i, w := range want {
+		event, ok := (*events.events.events)[i].jsonWriter.(*spanEvent)
+		if !ok {
+			v.Error("wrong span event")
+			continue
+		}
+		validateStringField(v, "name", w.Name, event.name)
+		validateStringField(v, "category", w.Category, string(event.category))
+		if w.IsEntrypoint != event.isEntrypoint {
+			v.Error("entrypoint", w.IsEntrypoint, event.isEntrypoint)
+		}
+		expectIntrinsics(v, event, w.Intrinsics)
+	}
+}
@@ -1,6 +1,10 @@
 package internal
 
-import "time"
+import (
+	"time"
+
+	"github.com/newrelic/go-agent/api"
+)
 
 type harvestable interface {
 	mergeIntoHarvest(h *harvest)
@@ -10,37 +14,158 @@ type dataConsumer interface {
 	consume(AgentRunID, harvestable)
 }
 
+// harvestLimits are the capacities of a harvest's reservoirs.
+type harvestLimits struct {
+	txnEvents    int
+	customEvents int
+	errorEvents  int
+	spanEvents   int
+	errorTraces  int
+	metrics      int
+	// failedEventAttempts limits the attempts to send the transaction,
+	// custom, and error events of a harvest.
+	failedEventAttempts int
+}
+
+var defaultHarvestLimits = harvestLimits{
+	txnEvents:           maxTxnEvents,
+	customEvents:        maxCustomEvents,
+	errorEvents:         maxErrorEvents,
+	spanEvents:          maxSpanEvents,
+	errorTraces:         maxHarvestErrors,
+	metrics:             maxMetrics,
+	failedEventAttempts: failedEventsAttemptsLimit,
+}
+
+// configLimit returns a limit of the config's HarvestLimits, or the default
+// given if the limit is zero.
+func configLimit(limit, defaultLimit int) int {
+	if 0 == limit {
+		return defaultLimit
+	}
+	return limit
+}
+
+// harvestLimitsFromConfig returns the limits of each harvest.  The event
+// limits of the config apply to each harvestPeriod, and are split between the
+// event harvests which occur within it.
+func harvestLimitsFromConfig(c *api.Config, eventPeriod time.Duration) harvestLimits {
+	l := c.HarvestLimits
+	return harvestLimits{
+		txnEvents:    splitEventLimit(configLimit(l.MaxTxnEvents, maxTxnEvents), eventPeriod),
+		customEvents: splitEventLimit(configLimit(l.MaxCustomEvents, maxCustomEvents), eventPeriod),
+		errorEvents:  splitEventLimit(configLimit(l.MaxErrorEvents, maxErrorEvents), eventPeriod),
+		spanEvents:   configLimit(l.MaxSpanEvents, maxSpanEvents),
+		errorTraces:  configLimit(l.MaxErrors, maxHarvestErrors),
+		metrics:      configLimit(l.MaxMetrics, maxMetrics),
+
+		failedEventAttempts: failedEventAttemptsLimit(eventPeriod),
+	}
+}
+
+// failedEventAttemptsLimit returns the number of attempts made to send events
+// harvested with the period given.  Failed events are kept for the same time
+// regardless of the period:  failedEventsAttemptsLimit harvestPeriods.
+func failedEventAttemptsLimit(period time.Duration) int {
+	if period <= 0 || period >= harvestPeriod {
+		return failedEventsAttemptsLimit
+	}
+	return int(int64(failedEventsAttemptsLimit) * int64(harvestPeriod) / int64(period))
+}
+
+// configEventHarvestPeriod returns the event harvest period of the config.
+func configEventHarvestPeriod(c *api.Config) time.Duration {
+	if 0 == c.EventHarvestPeriod {
+		return harvestPeriod
+	}
+	return c.EventHarvestPeriod
+}
+
+// clampEventLimit returns the limit requested by the collector if it is
+// present and does not exceed the local limit.
+func clampEventLimit(local int, server *int) int {
+	if nil != server && *server >= 0 && *server < local {
+		return *server
+	}
+	return local
+}
+
+// splitEventLimit returns the share of a limit belonging to an event harvest
+// period.  Each harvest may record at least one event unless the limit is
+// zero.
+func splitEventLimit(limit int, period time.Duration) int {
+	if limit <= 0 || period <= 0 || period >= harvestPeriod {
+		return limit
+	}
+	split := int(int64(limit) * int64(period) / int64(harvestPeriod))
+	if split < 1 {
+		return 1
+	}
+	return split
+}
+
 type harvest struct {
+	limits       harvestLimits
 	metrics      *metricTable
 	customEvents *customEvents
 	txnEvents    *txnEvents
 	errorEvents  *errorEvents
 	errorTraces  *harvestErrors
+	txnTraces    *harvestTraces
+	slowSQLs     *slowQueries
+	spanEvents   *spanEvents
 }
 
+// harvestTypes are groups of payloads which are harvested on the same cycle.
+type harvestTypes uint
+
+const (
+	// harvestMetricsTraces are the metrics, traces, and span events, which
+	// are harvested every harvestPeriod.
+	harvestMetricsTraces harvestTypes = 1 << iota
+	// harvestEvents are the transaction, custom, and error events, which
+	// are harvested on the faster event harvest cycle.
+	harvestEvents
+
+	harvestAll = harvestMetricsTraces | harvestEvents
+)
+
+// payloads returns the payloads of the harvest.  The payloads of a harvest
+// returned by ready are limited to the types harvested.
 func (h *harvest) payloads() map[string]payloadCreator {
-	return map[string]payloadCreator{
-		cmdMetrics:      h.metrics,
-		cmdCustomEvents: h.customEvents,
-		cmdTxnEvents:    h.txnEvents,
-		cmdErrorEvents:  h.errorEvents,
-		cmdErrorData:    h.errorTraces,
+	ps := make(map[string]payloadCreator)
+	if nil != h.metrics {
+		ps[cmdMetrics] = h.metrics
+		ps[cmdErrorData] = h.errorTraces
+		ps[cmdTxnTraces] = h.txnTraces
+		ps[cmdSlowSQLs] = h.slowSQLs
+		ps[cmdSpanEvents] = h.spanEvents
+	}
+	if nil != h.txnEvents {
+		ps[cmdCustomEvents] = h.customEvents
+		ps[cmdTxnEvents] = h.txnEvents
+		ps[cmdErrorEvents] = h.errorEvents
 	}
+	return ps
 }
 
-func newHarvest(now time.Time) *harvest {
+func newHarvest(now time.Time, limits harvestLimits) *harvest {
 	return &harvest{
-		metrics:      newMetricTable(maxMetrics, now),
-		customEvents: newCustomEvents(maxCustomEvents),
-		txnEvents:    newTxnEvents(maxTxnEvents),
-		errorEvents:  newErrorEvents(maxErrorEvents),
-		errorTraces:  newHarvestErrors(maxHarvestErrors),
+		limits:       limits,
+		metrics:      newMetricTable(limits.metrics, now),
+		customEvents: newCustomEvents(limits.customEvents),
+		txnEvents:    newTxnEvents(limits.txnEvents),
+		errorEvents:  newErrorEvents(limits.errorEvents),
+		errorTraces:  newHarvestErrors(limits.errorTraces),
+		txnTraces:    newHarvestTraces(),
+		slowSQLs:     newSlowQueries(maxHarvestSlowSQLs),
+		spanEvents:   newSpanEvents(limits.spanEvents),
 	}
 }
 
-func (h *harvest) createFinalMetrics() {
-	h.metrics.addSingleCount(instanceReporting, forced)
-
+// createEventMetrics records the supportability metrics of the events
+// harvested on the event harvest cycle.
+func (h *harvest) createEventMetrics() {
 	h.metrics.addCount(customEventsSeen, h.customEvents.numSeen(), forced)
 	h.metrics.addCount(customEventsSent, h.customEvents.numSaved(), forced)
 
@@ -50,13 +175,53 @@ func (h *harvest) createFinalMetrics() {
 	h.metrics.addCount(errorEventsSeen, h.errorEvents.numSeen(), forced)
 	h.metrics.addCount(errorEventsSent, h.errorEvents.numSaved(), forced)
 
+	h.metrics.addValue(txnEventsLimit, "", float64(h.limits.txnEvents), forced)
+	h.metrics.addValue(customEventsLimit, "", float64(h.limits.customEvents), forced)
+	h.metrics.addValue(errorEventsLimit, "", float64(h.limits.errorEvents), forced)
+}
+
+// createFinalMetrics records the supportability metrics of the payloads
+// harvested with the metrics.
+func (h *harvest) createFinalMetrics() {
+	h.metrics.addSingleCount(instanceReporting, forced)
+
+	h.metrics.addCount(spanEventsSeen, h.spanEvents.numSeen(), forced)
+	h.metrics.addCount(spanEventsSent, h.spanEvents.numSaved(), forced)
+
+	h.metrics.addValue(spanEventsLimit, "", float64(h.limits.spanEvents), forced)
+
 	if h.metrics.numDropped > 0 {
 		h.metrics.addCount(supportabilityDropped, float64(h.metrics.numDropped), forced)
 	}
 }
 
+// ready removes the payloads of the types given from the harvest, replacing
+// them with empty payloads, and returns them in a new harvest to be sent.
+// The supportability metrics of harvested events are recorded in the
+// metrics, which are sent on the next metric harvest.
+func (h *harvest) ready(types harvestTypes, now time.Time) *harvest {
+	ready := &harvest{limits: h.limits}
+	if 0 != types&harvestEvents {
+		h.createEventMetrics()
+		ready.customEvents, h.customEvents = h.customEvents, newCustomEvents(h.limits.customEvents)
+		ready.txnEvents, h.txnEvents = h.txnEvents, newTxnEvents(h.limits.txnEvents)
+		ready.errorEvents, h.errorEvents = h.errorEvents, newErrorEvents(h.limits.errorEvents)
+	}
+	if 0 != types&harvestMetricsTraces {
+		h.createFinalMetrics()
+		ready.metrics, h.metrics = h.metrics, newMetricTable(h.limits.metrics, now)
+		ready.errorTraces, h.errorTraces = h.errorTraces, newHarvestErrors(h.limits.errorTraces)
+		ready.txnTraces, h.txnTraces = h.txnTraces, newHarvestTraces()
+		ready.slowSQLs, h.slowSQLs = h.slowSQLs, newSlowQueries(maxHarvestSlowSQLs)
+		ready.spanEvents, h.spanEvents = h.spanEvents, newSpanEvents(h.limits.spanEvents)
+	}
+	return ready
+}
+
 func (h *harvest) applyMetricRules(rules metricRules) {
-	h.metrics = h.metrics.applyRules(rules)
+	if nil != h.metrics {
+		h.metrics = h.metrics.applyRules(rules)
+	}
 }
 
 type payloadCreator interface {
@@ -69,3 +234,21 @@ type payloadCreator interface {
 	// rpm request is necessary.
 	Data(agentRunID string, harvestStart time.Time) ([]byte, error)
 }
+
+// splittableCmds are the commands whose payloads implement splittablePayload.
+var splittableCmds = map[string]bool{
+	cmdMetrics:      true,
+	cmdCustomEvents: true,
+	cmdTxnEvents:    true,
+	cmdErrorEvents:  true,
+	cmdSpanEvents:   true,
+}
+
+// splittablePayload is implemented by payloads which may be divided when they
+// are too large to be sent in a single request.
+type splittablePayload interface {
+	payloadCreator
+	// split divides the payload in half.  It returns nils if the payload
+	// cannot be divided.
+	split() (payloadCreator, payloadCreator)
+}
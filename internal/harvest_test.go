@@ -3,12 +3,15 @@ package internal
 import (
 	"testing"
 	"time"
+
+	"github.com/newrelic/go-agent/api"
 )
 
 func TestCreateFinalMetrics(t *testing.T) {
 	now := time.Now()
 
-	h := newHarvest(now)
+	h := newHarvest(now, defaultHarvestLimits)
+	h.createEventMetrics()
 	h.createFinalMetrics()
 	expectMetrics(t, h.metrics, []WantMetric{
 		{instanceReporting, "", true, []float64{1, 0, 0, 0, 0, 0}},
@@ -18,13 +21,20 @@ func TestCreateFinalMetrics(t *testing.T) {
 		{txnEventsSent, "", true, []float64{0, 0, 0, 0, 0, 0}},
 		{errorEventsSeen, "", true, []float64{0, 0, 0, 0, 0, 0}},
 		{errorEventsSent, "", true, []float64{0, 0, 0, 0, 0, 0}},
+		{spanEventsSeen, "", true, []float64{0, 0, 0, 0, 0, 0}},
+		{spanEventsSent, "", true, []float64{0, 0, 0, 0, 0, 0}},
+		{txnEventsLimit, "", true, []float64{1, 10000, 10000, 10000, 10000, 10000 * 10000}},
+		{customEventsLimit, "", true, []float64{1, 10000, 10000, 10000, 10000, 10000 * 10000}},
+		{errorEventsLimit, "", true, []float64{1, 100, 100, 100, 100, 100 * 100}},
+		{spanEventsLimit, "", true, []float64{1, 1000, 1000, 1000, 1000, 1000 * 1000}},
 	})
 
-	h = newHarvest(now)
+	h = newHarvest(now, defaultHarvestLimits)
 	h.metrics = newMetricTable(0, now)
 	h.customEvents = newCustomEvents(1)
 	h.txnEvents = newTxnEvents(1)
 	h.errorEvents = newErrorEvents(1)
+	h.spanEvents = newSpanEvents(1)
 
 	h.metrics.addSingleCount("drop me!", unforced)
 
@@ -42,6 +52,10 @@ func TestCreateFinalMetrics(t *testing.T) {
 	h.errorEvents.Add(&errorEvent{})
 	h.errorEvents.Add(&errorEvent{})
 
+	h.spanEvents.AddSpanEvent(&spanEvent{})
+	h.spanEvents.AddSpanEvent(&spanEvent{})
+
+	h.createEventMetrics()
 	h.createFinalMetrics()
 	expectMetrics(t, h.metrics, []WantMetric{
 		{instanceReporting, "", true, []float64{1, 0, 0, 0, 0, 0}},
@@ -51,12 +65,80 @@ func TestCreateFinalMetrics(t *testing.T) {
 		{txnEventsSent, "", true, []float64{1, 0, 0, 0, 0, 0}},
 		{errorEventsSeen, "", true, []float64{2, 0, 0, 0, 0, 0}},
 		{errorEventsSent, "", true, []float64{1, 0, 0, 0, 0, 0}},
+		{spanEventsSeen, "", true, []float64{2, 0, 0, 0, 0, 0}},
+		{spanEventsSent, "", true, []float64{1, 0, 0, 0, 0, 0}},
+		{txnEventsLimit, "", true, nil},
+		{customEventsLimit, "", true, nil},
+		{errorEventsLimit, "", true, nil},
+		{spanEventsLimit, "", true, nil},
 		{supportabilityDropped, "", true, []float64{1, 0, 0, 0, 0, 0}},
 	})
 }
 
+func TestHarvestReadyEvents(t *testing.T) {
+	now := time.Now()
+	h := newHarvest(now, defaultHarvestLimits)
+	h.txnEvents.AddTxnEvent(&txnEvent{})
+	h.metrics.addSingleCount("zip", forced)
+
+	ready := h.ready(harvestEvents, now)
+	if 1 != ready.txnEvents.numSaved() || 0 != h.txnEvents.numSaved() {
+		t.Error(ready.txnEvents.numSaved(), h.txnEvents.numSaved())
+	}
+	if nil != ready.metrics {
+		t.Error("metrics are not harvested with events")
+	}
+	payloads := ready.payloads()
+	if len(payloads) != 3 || nil == payloads[cmdTxnEvents] || nil == payloads[cmdErrorEvents] ||
+		nil == payloads[cmdCustomEvents] {
+		t.Error(payloads)
+	}
+	// The event metrics are sent with the next metric harvest.
+	if m := h.metrics.metrics[metricID{Name: txnEventsSent}]; nil == m || 1 != m.data.countSatisfied {
+		t.Error(m)
+	}
+
+	ready = h.ready(harvestMetricsTraces, now)
+	if nil != ready.txnEvents {
+		t.Error("events are not harvested with metrics")
+	}
+	if len(ready.payloads()) != 5 {
+		t.Error(ready.payloads())
+	}
+	if nil == ready.metrics.metrics[metricID{Name: "zip"}] || nil == ready.metrics.metrics[metricID{Name: txnEventsSent}] {
+		t.Error(ready.metrics.metrics)
+	}
+	if 0 != len(h.metrics.metrics) {
+		t.Error(h.metrics.metrics)
+	}
+
+	ready = h.ready(harvestAll, now)
+	if len(ready.payloads()) != 8 {
+		t.Error(ready.payloads())
+	}
+}
+
+func TestSplitEventLimit(t *testing.T) {
+	for _, tc := range []struct {
+		limit  int
+		period time.Duration
+		expect int
+	}{
+		{10000, 5 * time.Second, 833},
+		{100, 5 * time.Second, 8},
+		{1, 5 * time.Second, 1},
+		{0, 5 * time.Second, 0},
+		{10000, 0, 10000},
+		{10000, harvestPeriod, 10000},
+	} {
+		if split := splitEventLimit(tc.limit, tc.period); split != tc.expect {
+			t.Error(tc.limit, tc.period, split, tc.expect)
+		}
+	}
+}
+
 func TestEmptyPayloads(t *testing.T) {
-	h := newHarvest(time.Now())
+	h := newHarvest(time.Now(), defaultHarvestLimits)
 	payloads := h.payloads()
 	for _, p := range payloads {
 		d, err := p.Data("agentRunID", time.Now())
@@ -66,10 +148,19 @@ func TestEmptyPayloads(t *testing.T) {
 	}
 }
 
+func TestSplittableCmds(t *testing.T) {
+	h := newHarvest(time.Now(), defaultHarvestLimits)
+	for cmd, p := range h.payloads() {
+		if _, ok := p.(splittablePayload); ok != splittableCmds[cmd] {
+			t.Error(cmd, ok)
+		}
+	}
+}
+
 func TestMergeFailedHarvest(t *testing.T) {
 	start1 := time.Now()
 	start2 := start1.Add(1 * time.Minute)
-	h := newHarvest(start1)
+	h := newHarvest(start1, defaultHarvestLimits)
 	h.metrics.addCount("zip", 1, forced)
 	h.txnEvents.AddTxnEvent(&txnEvent{
 		Name:      "finalName",
@@ -132,7 +223,7 @@ func TestMergeFailedHarvest(t *testing.T) {
 		URL:     "requestURI",
 	}})
 
-	nextHarvest := newHarvest(start2)
+	nextHarvest := newHarvest(start2, defaultHarvestLimits)
 	if start2 != nextHarvest.metrics.metricPeriodStart {
 		t.Error(nextHarvest.metrics.metricPeriodStart)
 	}
@@ -170,3 +261,29 @@ func TestMergeFailedHarvest(t *testing.T) {
 	})
 	expectErrors(t, nextHarvest.errorTraces, []WantError{})
 }
+
+func TestMergeFailedEventsPeriod(t *testing.T) {
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	limits := harvestLimitsFromConfig(&cfg, 5*time.Second)
+	// Events sent every five seconds are retried for as long as those
+	// sent every minute.
+	if limits.failedEventAttempts != 12*failedEventsAttemptsLimit {
+		t.Error(limits.failedEventAttempts)
+	}
+
+	failed := newHarvest(time.Now(), limits)
+	failed.txnEvents.AddTxnEvent(&txnEvent{
+		Name:      "finalName",
+		Timestamp: time.Now(),
+		Duration:  time.Second,
+	})
+	failed.txnEvents.events.failedHarvests = failedEventsAttemptsLimit
+	h := newHarvest(time.Now(), limits)
+	failed.txnEvents.mergeIntoHarvest(h)
+	expectTxnEvents(t, h.txnEvents, []WantTxnEvent{{Name: "finalName"}})
+
+	failed.txnEvents.events.failedHarvests = limits.failedEventAttempts
+	h = newHarvest(time.Now(), limits)
+	failed.txnEvents.mergeIntoHarvest(h)
+	expectTxnEvents(t, h.txnEvents, []WantTxnEvent{})
+}
@@ -4,23 +4,41 @@ import "time"
 
 const (
 	// app behavior
-	connectBackoff            = 20 * time.Second
+	// maxRetryAfter limits the delay requested by the Retry-After header
+	// of collector responses.
+	maxRetryAfter             = time.Hour
 	harvestPeriod             = 60 * time.Second
 	collectorTimeout          = 20 * time.Second
 	appDataChanSize           = 200
 	failedMetricAttemptsLimit = 5
 	failedEventsAttemptsLimit = 10
+	// samplerTarget is the number of transactions starting a distributed
+	// trace which are sampled each harvest period.
+	samplerTarget = 10
 
 	// transaction behavior
-	maxStackTraceFrames = 100
-	maxTxnErrors        = 5
+	maxStackTraceFrames   = 100
+	maxTxnErrors          = 5
+	maxTxnSlowQueries     = 50
+	startingTxnTraceNodes = 16
+	// maxAlternatePathHashes limits the distinct path hashes of a
+	// transaction's outbound cross application calls.
+	maxAlternatePathHashes = 10
+	// maxTxnSpanEvents limits the span events recorded by each of a
+	// transaction's tracers.
+	maxTxnSpanEvents = 1000
+	// maxErrorCauses limits the depth to which the causes of a noticed
+	// error are unwrapped.
+	maxErrorCauses = 100
 
-	// harvest data
-	maxMetrics       = 2 * 1000
-	maxCustomEvents  = 10 * 1000
-	maxTxnEvents     = 10 * 1000
-	maxErrorEvents   = 100
-	maxHarvestErrors = 20
+	// harvest data defaults, see api.Config.HarvestLimits
+	maxMetrics         = 2 * 1000
+	maxCustomEvents    = 10 * 1000
+	maxTxnEvents       = 10 * 1000
+	maxErrorEvents     = 100
+	maxHarvestErrors   = 20
+	maxHarvestSlowSQLs = 10
+	maxSpanEvents      = 1000
 
 	// attributes
 	attributeKeyLengthLimit   = 255
@@ -29,6 +47,9 @@ const (
 	attributeAgentLimit       = 255 - attributeUserLimit
 	customEventAttributeLimit = 64
 
+	// custom metrics
+	customMetricNameLengthLimit = 255
+
 	// Limits affecting Config validation are found in the config package.
 
 	// Runtime metrics should not depend on the sampler period, but the
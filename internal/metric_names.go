@@ -35,8 +35,23 @@ const (
 	errorEventsSeen = "Supportability/Events/TransactionError/Seen"
 	errorEventsSent = "Supportability/Events/TransactionError/Sent"
 
+	spanEventsSeen = "Supportability/SpanEvent/TotalEventsSeen"
+	spanEventsSent = "Supportability/SpanEvent/TotalEventsSent"
+
+	// The configured reservoir sizes are reported each harvest.
+	txnEventsLimit    = "Supportability/EventHarvest/AnalyticEventData/HarvestLimit"
+	customEventsLimit = "Supportability/EventHarvest/CustomEventData/HarvestLimit"
+	errorEventsLimit  = "Supportability/EventHarvest/ErrorEventData/HarvestLimit"
+	spanEventsLimit   = "Supportability/EventHarvest/SpanEventData/HarvestLimit"
+
 	supportabilityDropped = "Supportability/MetricsDropped"
 
+	// The bytes written to, sent from, and discarded from the spool since
+	// the previous harvest.
+	spoolSpooledBytes  = "Supportability/Spool/Spooled/Bytes"
+	spoolReplayedBytes = "Supportability/Spool/Replayed/Bytes"
+	spoolDroppedBytes  = "Supportability/Spool/Dropped/Bytes"
+
 	customSegmentPrefix = "Custom/"
 
 	// source.datanerd.us/agents/agent-specs/blob/master/Datastore-Metrics-PORTED.md
@@ -98,6 +113,11 @@ func externalHostMetric(key externalMetricKey) string {
 	return "External/" + key.Host + "/all"
 }
 
+// ClientApplication/{client_id}/all
+func clientApplicationMetric(crossProcessID string) string {
+	return "ClientApplication/" + crossProcessID + "/all"
+}
+
 // ExternalApp/{host}/{external_id}/all
 func externalAppMetric(key externalMetricKey) string {
 	return "ExternalApp/" + key.Host +
@@ -110,3 +130,23 @@ func externalTransactionMetric(key externalMetricKey) string {
 		"/" + key.ExternalCrossProcessID +
 		"/" + key.ExternalTransactionName
 }
+
+// Supportability/Collector/{method}/PayloadSplit
+func payloadSplitMetric(cmd string) string {
+	return "Supportability/Collector/" + cmd + "/PayloadSplit"
+}
+
+// Supportability/Collector/{method}/PayloadDropped
+func payloadDroppedMetric(cmd string) string {
+	return "Supportability/Collector/" + cmd + "/PayloadDropped"
+}
+
+// Supportability/Collector/{method}/Output/Bytes
+func payloadUncompressedMetric(cmd string) string {
+	return "Supportability/Collector/" + cmd + "/Output/Bytes"
+}
+
+// Supportability/Collector/{method}/Output/CompressedBytes
+func payloadCompressedMetric(cmd string) string {
+	return "Supportability/Collector/" + cmd + "/Output/CompressedBytes"
+}
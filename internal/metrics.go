@@ -229,6 +229,28 @@ func (mt *metricTable) CollectorJSON(agentRunID string, now time.Time) ([]byte,
 func (mt *metricTable) Data(agentRunID string, harvestStart time.Time) ([]byte, error) {
 	return mt.CollectorJSON(agentRunID, harvestStart)
 }
+
+// split divides the metrics between two tables with the same period.
+func (mt *metricTable) split() (payloadCreator, payloadCreator) {
+	if len(mt.metrics) < 2 {
+		return nil, nil
+	}
+	first := newMetricTable(mt.maxTableSize, mt.metricPeriodStart)
+	second := newMetricTable(mt.maxTableSize, mt.metricPeriodStart)
+	first.failedHarvests = mt.failedHarvests
+	second.failedHarvests = mt.failedHarvests
+	i := 0
+	for id, m := range mt.metrics {
+		if i < len(mt.metrics)/2 {
+			first.metrics[id] = m
+		} else {
+			second.metrics[id] = m
+		}
+		i++
+	}
+	return first, second
+}
+
 func (mt *metricTable) mergeIntoHarvest(h *harvest) {
 	h.metrics.mergeFailed(mt)
 }
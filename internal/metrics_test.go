@@ -336,3 +336,35 @@ func TestMergedWithScope(t *testing.T) {
 		{"two", "my_scope", false, []float64{2, 4, 2, 2, 2, 8}},
 	})
 }
+
+func TestMetricsSplit(t *testing.T) {
+	mt := newMetricTable(20, start)
+	mt.failedHarvests = 2
+	mt.addSingleCount("one", unforced)
+	mt.addSingleCount("two", forced)
+	mt.addSingleCount("three", unforced)
+
+	first, second := mt.split()
+	a, b := first.(*metricTable), second.(*metricTable)
+	if len(a.metrics) != 1 || len(b.metrics) != 2 {
+		t.Fatal(len(a.metrics), len(b.metrics))
+	}
+	if a.metricPeriodStart != start || b.metricPeriodStart != start ||
+		a.failedHarvests != 2 || b.failedHarvests != 2 {
+		t.Error(a, b)
+	}
+	joined := newMetricTable(20, start)
+	joined.merge(a, "")
+	joined.merge(b, "")
+	expectMetrics(t, joined, []WantMetric{
+		{"one", "", false, []float64{1, 0, 0, 0, 0, 0}},
+		{"two", "", true, []float64{1, 0, 0, 0, 0, 0}},
+		{"three", "", false, []float64{1, 0, 0, 0, 0, 0}},
+	})
+
+	single := newMetricTable(20, start)
+	single.addSingleCount("one", unforced)
+	if first, second := single.split(); nil != first || nil != second {
+		t.Error(first, second)
+	}
+}
@@ -145,14 +145,22 @@ func (s stats) mergeIntoHarvest(h *harvest) {
 func runSampler(app *App, period time.Duration) {
 	previous := getSample(time.Now())
 
-	for now := range time.Tick(period) {
-		current := getSample(now)
-
-		run := app.getRun()
-		app.consume(run.RunID, getStats(samples{
-			previous: previous,
-			current:  current,
-		}))
-		previous = current
+	t := time.NewTicker(period)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			current := getSample(now)
+
+			run := app.getRun()
+			app.consume(run.RunID, getStats(samples{
+				previous: previous,
+				current:  current,
+			}))
+			previous = current
+		case <-app.shutdownStarted:
+			return
+		}
 	}
 }
@@ -7,7 +7,7 @@ import (
 
 func TestMetricsCreated(t *testing.T) {
 	now := time.Now()
-	h := newHarvest(now)
+	h := newHarvest(now, defaultHarvestLimits)
 
 	stats := stats{
 		numGoroutine: 23,
@@ -43,7 +43,7 @@ func TestMetricsCreated(t *testing.T) {
 
 func TestMetricsCreatedEmpty(t *testing.T) {
 	now := time.Now()
-	h := newHarvest(now)
+	h := newHarvest(now, defaultHarvestLimits)
 	stats := stats{}
 
 	stats.mergeIntoHarvest(h)
@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"time"
+
+	"github.com/newrelic/go-agent/internal/jsonx"
+)
+
+// https://source.datanerd.us/agents/agent-specs/blob/master/Slow-SQLs-LEGACY.md
+
+type slowQueryID uint32
+
+// makeSlowQueryID creates the id used to aggregate slow queries.  It must be
+// given the obfuscated query so that queries differing only in their literal
+// values are aggregated together.
+func makeSlowQueryID(obfuscated string) slowQueryID {
+	h := fnv.New32a()
+	h.Write([]byte(obfuscated))
+	return slowQueryID(h.Sum32())
+}
+
+type slowQueryInstance struct {
+	duration   time.Duration
+	metricName string
+	// query is obfuscated unless obfuscation has been disabled.
+	query   string
+	id      slowQueryID
+	stack   *stackTrace
+	txnName string
+	txnURL  string
+}
+
+// slowQuery aggregates the instances of a query.  The slowest instance is
+// retained.
+type slowQuery struct {
+	slowQueryInstance
+	count int64
+	total time.Duration
+	min   time.Duration
+}
+
+func (s *slowQuery) max() time.Duration { return s.duration }
+
+func (s *slowQuery) aggregate(other *slowQuery) {
+	s.count += other.count
+	s.total += other.total
+	if other.min < s.min {
+		s.min = other.min
+	}
+	if other.max() > s.max() {
+		s.slowQueryInstance = other.slowQueryInstance
+	}
+}
+
+// slowQueries is used to collect the slow queries of both a transaction and a
+// harvest.  When it is full, the query with the smallest maximum duration is
+// replaced.
+type slowQueries struct {
+	lookup map[slowQueryID]*slowQuery
+	max    int
+}
+
+func newSlowQueries(max int) *slowQueries {
+	return &slowQueries{
+		lookup: make(map[slowQueryID]*slowQuery),
+		max:    max,
+	}
+}
+
+func (slows *slowQueries) observeInstance(instance slowQueryInstance) {
+	slows.observe(&slowQuery{
+		slowQueryInstance: instance,
+		count:             1,
+		total:             instance.duration,
+		min:               instance.duration,
+	})
+}
+
+func (slows *slowQueries) observe(s *slowQuery) {
+	if existing, ok := slows.lookup[s.id]; ok {
+		existing.aggregate(s)
+		return
+	}
+	if len(slows.lookup) >= slows.max {
+		var fastest *slowQuery
+		for _, q := range slows.lookup {
+			if nil == fastest || q.max() < fastest.max() {
+				fastest = q
+			}
+		}
+		if nil == fastest || fastest.max() >= s.max() {
+			return
+		}
+		delete(slows.lookup, fastest.id)
+	}
+	cpy := new(slowQuery)
+	*cpy = *s
+	slows.lookup[s.id] = cpy
+}
+
+// merge adds the queries of a transaction or of a failed harvest.  If
+// non-empty, the transaction name and url are assigned to the merged queries.
+func (slows *slowQueries) merge(other *slowQueries, txnName, txnURL string) {
+	for _, s := range other.lookup {
+		cpy := *s
+		if "" != txnName {
+			cpy.txnName = txnName
+			cpy.txnURL = txnURL
+		}
+		slows.observe(&cpy)
+	}
+}
+
+func (s *slowQuery) writeJSON(buf *bytes.Buffer) error {
+	params := struct {
+		Backtrace *stackTrace `json:"backtrace,omitempty"`
+	}{
+		Backtrace: s.stack,
+	}
+	js, err := json.Marshal(params)
+	if nil != err {
+		return err
+	}
+	encoded, err := compressEncode(js)
+	if nil != err {
+		return err
+	}
+
+	buf.WriteByte('[')
+	jsonx.AppendString(buf, s.txnName)
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, s.txnURL)
+	buf.WriteByte(',')
+	jsonx.AppendInt(buf, int64(s.id))
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, s.query)
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, s.metricName)
+	buf.WriteByte(',')
+	jsonx.AppendInt(buf, s.count)
+	buf.WriteByte(',')
+	jsonx.AppendFloat(buf, s.total.Seconds()*1000.0)
+	buf.WriteByte(',')
+	jsonx.AppendFloat(buf, s.min.Seconds()*1000.0)
+	buf.WriteByte(',')
+	jsonx.AppendFloat(buf, s.max().Seconds()*1000.0)
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, encoded)
+	buf.WriteByte(']')
+	return nil
+}
+
+// Data prepares the slow queries for the collector.  Note that the
+// sql_trace_data command does not include the agent run id in the payload.
+func (slows *slowQueries) Data(agentRunID string, harvestStart time.Time) ([]byte, error) {
+	if 0 == len(slows.lookup) {
+		return nil, nil
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`[[`)
+	first := true
+	for _, s := range slows.lookup {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		if err := s.writeJSON(buf); nil != err {
+			return nil, err
+		}
+	}
+	buf.WriteString(`]]`)
+
+	return buf.Bytes(), nil
+}
+
+func (slows *slowQueries) mergeIntoHarvest(h *harvest) {
+	h.slowSQLs.merge(slows, "", "")
+}
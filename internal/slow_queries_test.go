@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/api/datastore"
+)
+
+func TestSlowQueriesAggregation(t *testing.T) {
+	slows := newSlowQueries(10)
+	id := makeSlowQueryID("SELECT * FROM users WHERE id = ?")
+	slows.observeInstance(slowQueryInstance{
+		duration: 2 * time.Second,
+		query:    "SELECT * FROM users WHERE id = 1",
+		id:       id,
+	})
+	slows.observeInstance(slowQueryInstance{
+		duration: 3 * time.Second,
+		query:    "SELECT * FROM users WHERE id = 2",
+		id:       id,
+	})
+	slows.observeInstance(slowQueryInstance{
+		duration: 1 * time.Second,
+		query:    "SELECT * FROM users WHERE id = 3",
+		id:       id,
+	})
+	if len(slows.lookup) != 1 {
+		t.Fatal(len(slows.lookup))
+	}
+	s := slows.lookup[id]
+	if s.count != 3 || s.total != 6*time.Second || s.min != 1*time.Second ||
+		s.max() != 3*time.Second || s.query != "SELECT * FROM users WHERE id = 2" {
+		t.Error(s.count, s.total, s.min, s.max(), s.query)
+	}
+}
+
+func TestSlowQueriesReplaceFastest(t *testing.T) {
+	slows := newSlowQueries(2)
+	slows.observeInstance(slowQueryInstance{duration: 2 * time.Second, query: "two", id: 2})
+	slows.observeInstance(slowQueryInstance{duration: 1 * time.Second, query: "one", id: 1})
+	slows.observeInstance(slowQueryInstance{duration: 3 * time.Second, query: "three", id: 3})
+	slows.observeInstance(slowQueryInstance{duration: 1 * time.Second, query: "other", id: 4})
+	if len(slows.lookup) != 2 {
+		t.Fatal(len(slows.lookup))
+	}
+	if nil == slows.lookup[2] || nil == slows.lookup[3] {
+		t.Error(slows.lookup)
+	}
+}
+
+func TestSlowQueriesMerge(t *testing.T) {
+	txnSlows := newSlowQueries(10)
+	txnSlows.observeInstance(slowQueryInstance{duration: 2 * time.Second, query: "query", id: 1})
+
+	h := newHarvest(time.Now(), defaultHarvestLimits)
+	h.slowSQLs.merge(txnSlows, "WebTransaction/Go/hello", "/hello")
+	h.slowSQLs.merge(txnSlows, "WebTransaction/Go/other", "/other")
+
+	s := h.slowSQLs.lookup[1]
+	if s.count != 2 || s.txnName != "WebTransaction/Go/hello" || s.txnURL != "/hello" {
+		t.Error(s.count, s.txnName, s.txnURL)
+	}
+
+	next := newHarvest(time.Now(), defaultHarvestLimits)
+	h.slowSQLs.mergeIntoHarvest(next)
+	s = next.slowSQLs.lookup[1]
+	if s.count != 2 || s.txnName != "WebTransaction/Go/hello" {
+		t.Error(s.count, s.txnName)
+	}
+}
+
+func TestSlowQueriesData(t *testing.T) {
+	slows := newSlowQueries(10)
+	if js, err := slows.Data("run", time.Now()); nil != js || nil != err {
+		t.Error(string(js), err)
+	}
+	slows.observeInstance(slowQueryInstance{
+		duration:   2 * time.Second,
+		metricName: "Datastore/statement/MySQL/users/select",
+		query:      "SELECT * FROM users WHERE id = ?",
+		id:         123,
+		stack:      getStackTrace(0),
+		txnName:    "WebTransaction/Go/hello",
+		txnURL:     "/hello",
+	})
+	js, err := slows.Data("run", time.Now())
+	if nil != err {
+		t.Fatal(err)
+	}
+	var data [][]interface{}
+	if err := json.Unmarshal(js, &data); nil != err {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || len(data[0]) != 1 {
+		t.Fatal(string(js))
+	}
+	fields := data[0][0].([]interface{})
+	expect := compactJSONString(`["WebTransaction/Go/hello","/hello",123,
+		"SELECT * FROM users WHERE id = ?","Datastore/statement/MySQL/users/select",
+		1,2000,2000,2000]`)
+	if js, _ := json.Marshal(fields[0:9]); string(js) != expect {
+		t.Error(string(js))
+	}
+	params, err := uncompressDecode(fields[9].(string))
+	if nil != err {
+		t.Fatal(err)
+	}
+	var p struct {
+		Backtrace []string `json:"backtrace"`
+	}
+	if err := json.Unmarshal(params, &p); nil != err {
+		t.Fatal(err)
+	}
+	if 0 == len(p.Backtrace) || !strings.Contains(p.Backtrace[0], "TestSlowQueriesData") {
+		t.Error(p.Backtrace)
+	}
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	tr := &tracer{
+		slowQueriesEnabled: true,
+		slowQueryThreshold: 2 * time.Second,
+	}
+	query := "SELECT * FROM users WHERE name = 'zap'"
+
+	t1 := startSegment(tr, start)
+	endDatastoreSegment(tr, t1, start.Add(1*time.Second), datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   query,
+	})
+	if nil != tr.slowQueries {
+		t.Fatal(tr.slowQueries)
+	}
+	t2 := startSegment(tr, start)
+	endDatastoreSegment(tr, t2, start.Add(3*time.Second), datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   query,
+	})
+	t3 := startSegment(tr, start)
+	endDatastoreSegment(tr, t3, start.Add(3*time.Second), datastore.Segment{
+		Product: datastore.MySQL,
+	})
+	if nil == tr.slowQueries || 1 != len(tr.slowQueries.lookup) {
+		t.Fatal(tr.slowQueries)
+	}
+	for _, s := range tr.slowQueries.lookup {
+		if s.query != "SELECT * FROM users WHERE name = ?" {
+			t.Error(s.query)
+		}
+		if s.metricName != "Datastore/statement/MySQL/users/select" {
+			t.Error(s.metricName)
+		}
+		if s.id != makeSlowQueryID("SELECT * FROM users WHERE name = ?") {
+			t.Error(s.id)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"time"
+)
+
+// spanCategory identifies the kind of segment a span event represents.
+type spanCategory string
+
+const (
+	spanCategoryGeneric   spanCategory = "generic"
+	spanCategoryHTTP      spanCategory = "http"
+	spanCategoryDatastore spanCategory = "datastore"
+)
+
+// spanEvent represents a segment or the transaction itself within a
+// distributed trace.  The trace and transaction fields are assigned when the
+// transaction ends.
+type spanEvent struct {
+	traceID      string
+	txnID        string
+	sampled      bool
+	priority     float32
+	guid         string
+	parentID     string
+	timestamp    time.Time
+	duration     time.Duration
+	name         string
+	category     spanCategory
+	isEntrypoint bool
+
+	// component and kind are set for external and datastore spans.
+	component string
+	kind      string
+	// httpURL is set for external spans.  It does not include the query
+	// string.
+	httpURL string
+	// dbStatement must be obfuscated unless obfuscation has been
+	// disabled.
+	dbStatement  string
+	dbCollection string
+}
+
+// WriteJSON prepares JSON in the format expected by the collector.
+func (e *spanEvent) WriteJSON(buf *bytes.Buffer) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteString(`[{`)
+	w.stringField("type", "Span")
+	w.stringField("traceId", e.traceID)
+	w.stringField("transactionId", e.txnID)
+	w.boolField("sampled", e.sampled)
+	w.floatField("priority", float64(e.priority))
+	w.stringField("guid", e.guid)
+	if "" != e.parentID {
+		w.stringField("parentId", e.parentID)
+	}
+	w.intField("timestamp", timeToUnixMilliseconds(e.timestamp))
+	w.floatField("duration", e.duration.Seconds())
+	w.stringField("name", e.name)
+	w.stringField("category", string(e.category))
+	if e.isEntrypoint {
+		w.boolField("nr.entryPoint", true)
+	}
+	if "" != e.component {
+		w.stringField("component", e.component)
+	}
+	if "" != e.kind {
+		w.stringField("span.kind", e.kind)
+	}
+	if "" != e.httpURL {
+		w.stringField("http.url", e.httpURL)
+	}
+	if "" != e.dbStatement {
+		w.stringField("db.statement", e.dbStatement)
+	}
+	if "" != e.dbCollection {
+		w.stringField("db.collection", e.dbCollection)
+	}
+	buf.WriteString(`},{},{}]`)
+}
+
+func (e *spanEvent) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 256))
+
+	e.WriteJSON(buf)
+
+	return buf.Bytes(), nil
+}
+
+type spanEvents struct {
+	events *analyticsEvents
+}
+
+func newSpanEvents(max int) *spanEvents {
+	return &spanEvents{
+		events: newAnalyticsEvents(max),
+	}
+}
+
+// AddSpanEvent adds a span event to the reservoir.  The priority is used in
+// place of a random stamp so that the spans of a trace are kept or dropped
+// together.
+func (events *spanEvents) AddSpanEvent(e *spanEvent) {
+	events.events.AddEvent(analyticsEvent{eventStamp(e.priority), e})
+}
+
+func (events *spanEvents) mergeIntoHarvest(h *harvest) {
+	// Span events are harvested with the metrics.
+	h.spanEvents.events.MergeFailed(events.events, failedEventsAttemptsLimit)
+}
+
+func (events *spanEvents) Data(agentRunID string, harvestStart time.Time) ([]byte, error) {
+	return events.events.CollectorJSON(agentRunID)
+}
+
+func (events *spanEvents) split() (payloadCreator, payloadCreator) {
+	first, second := events.events.split()
+	if nil == first {
+		return nil, nil
+	}
+	return &spanEvents{events: first}, &spanEvents{events: second}
+}
+
+func (events *spanEvents) numSeen() float64  { return events.events.NumSeen() }
+func (events *spanEvents) numSaved() float64 { return events.events.NumSaved() }
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/api/datastore"
+)
+
+func TestSpanEventJSON(t *testing.T) {
+	e := &spanEvent{
+		traceID:   "0af7651916cd43dd8448eb211c80319c",
+		txnID:     "5569065a5b1313bd",
+		sampled:   true,
+		priority:  1.5,
+		guid:      "27ddd2d8890283b4",
+		parentID:  "b7ad6b7169203331",
+		timestamp: timeFromUnixMilliseconds(1518469636025),
+		duration:  2 * time.Second,
+		name:      "External/example.com/all",
+		category:  spanCategoryHTTP,
+		component: "http",
+		kind:      "client",
+		httpURL:   "http://example.com/zip",
+	}
+	js, err := e.MarshalJSON()
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect := compactJSONString(`[{
+		"type":"Span",
+		"traceId":"0af7651916cd43dd8448eb211c80319c",
+		"transactionId":"5569065a5b1313bd",
+		"sampled":true,
+		"priority":1.5,
+		"guid":"27ddd2d8890283b4",
+		"parentId":"b7ad6b7169203331",
+		"timestamp":1518469636025,
+		"duration":2,
+		"name":"External/example.com/all",
+		"category":"http",
+		"component":"http",
+		"span.kind":"client",
+		"http.url":"http://example.com/zip"
+	},{},{}]`)
+	if string(js) != expect {
+		t.Error(string(js))
+	}
+}
+
+func TestTracerSpanEvents(t *testing.T) {
+	start := time.Now()
+	tr := &tracer{
+		distributedTracing: true,
+		rootSpanID:         "root",
+		spanEventsEnabled:  true,
+	}
+	t1 := startSegment(tr, start.Add(1*time.Second))
+	t2 := startSegment(tr, start.Add(2*time.Second))
+	endDatastoreSegment(tr, t2, start.Add(3*time.Second), datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   "SELECT * FROM users WHERE id = 1",
+	})
+	t3 := startSegment(tr, start.Add(4*time.Second))
+	endExternalSegment(tr, t3, start.Add(5*time.Second), externalMetricKey{Host: "example.com"}, "http://example.com")
+	endBasicSegment(tr, t1, start.Add(6*time.Second), "f1")
+
+	if 3 != len(tr.spanEvents) {
+		t.Fatal(len(tr.spanEvents))
+	}
+	ds, ext, basic := tr.spanEvents[0], tr.spanEvents[1], tr.spanEvents[2]
+	if basic.parentID != "root" || basic.name != "Custom/f1" || basic.category != spanCategoryGeneric ||
+		basic.duration != 5*time.Second {
+		t.Errorf("%+v", basic)
+	}
+	if ds.parentID != basic.guid || ds.name != "Datastore/statement/MySQL/users/select" ||
+		ds.category != spanCategoryDatastore || ds.dbStatement != "SELECT * FROM users WHERE id = ?" ||
+		ds.dbCollection != "users" || ds.component != "MySQL" {
+		t.Errorf("%+v", ds)
+	}
+	if ext.parentID != basic.guid || ext.name != "External/example.com/all" ||
+		ext.category != spanCategoryHTTP || ext.httpURL != "http://example.com" {
+		t.Errorf("%+v", ext)
+	}
+	if ds.guid == ext.guid || "" == ds.guid || "" == ext.guid {
+		t.Error(ds.guid, ext.guid)
+	}
+}
+
+func TestTracerSpanEventsDisabled(t *testing.T) {
+	tr := &tracer{distributedTracing: true, rootSpanID: "root"}
+	token := startSegment(tr, time.Now())
+	if id := spanIDForToken(tr, token); 16 != len(id) {
+		t.Error(id)
+	}
+	endBasicSegment(tr, token, time.Now(), "f1")
+	if 0 != len(tr.spanEvents) {
+		t.Error(len(tr.spanEvents))
+	}
+	if id := spanIDForToken(tr, token); id != "root" {
+		t.Error(id)
+	}
+}
+
+func TestTracerSpanEventsLimit(t *testing.T) {
+	tr := &tracer{distributedTracing: true, spanEventsEnabled: true}
+	for i := 0; i < maxTxnSpanEvents+5; i++ {
+		endBasicSegment(tr, startSegment(tr, time.Now()), time.Now(), "f1")
+	}
+	async := &tracer{distributedTracing: true, spanEventsEnabled: true}
+	endBasicSegment(async, startSegment(async, time.Now()), time.Now(), "f1")
+	mergeTracer(tr, async)
+	if len(tr.spanEvents) != maxTxnSpanEvents {
+		t.Error(len(tr.spanEvents))
+	}
+}
@@ -0,0 +1,340 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/internal/jsonx"
+	"github.com/newrelic/go-agent/log"
+)
+
+// spool stores payloads on disk which could not be sent because the collector
+// was unreachable.  Each payload is written to its own file, named by the time
+// it was spooled, so that payloads are replayed in order and the oldest
+// payloads are discarded first.  Files are written to a temporary name and
+// renamed once complete, and their contents are framed and checksummed so that
+// partially written or otherwise corrupt files are discarded rather than sent.
+type spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	sync.Mutex
+	seq       uint64
+	replaying bool
+	// The bytes spooled, replayed, and dropped since the last metric
+	// harvest.
+	spooledBytes  int64
+	replayedBytes int64
+	droppedBytes  int64
+}
+
+const (
+	spoolFileSuffix = ".spool"
+	spoolTempSuffix = ".tmp"
+	spoolVersion    = 1
+	// spoolHeaderLength is the length of the frame before the command:
+	// magic, version, command length, and payload length.
+	spoolHeaderLength = 4 + 1 + 2 + 4
+	spoolCRCLength    = 4
+)
+
+var (
+	spoolMagic      = []byte("NRSP")
+	errSpoolCorrupt = errors.New("spool file corrupt")
+)
+
+// spoolDir returns the directory of the application's spool within the
+// configured directory.  Each application name and license has its own spool,
+// since spooled payloads carry neither and are sent using the connection of the
+// application which replays them.
+func spoolDir(c *api.Config) string {
+	sum := sha256.Sum256([]byte(c.AppName + "\x00" + c.License))
+	return filepath.Join(c.Spool.Dir, hex.EncodeToString(sum[0:8]))
+}
+
+func newSpool(dir string, maxBytes int64, maxAge time.Duration) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); nil != err {
+		return nil, err
+	}
+	return &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}, nil
+}
+
+// encodeSpoolFrame creates the contents of a spool file:  The magic bytes,
+// version, command length, payload length, command, payload, and a CRC32
+// checksum of everything preceding it.
+func encodeSpoolFrame(cmd string, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.Grow(spoolHeaderLength + len(cmd) + len(data) + spoolCRCLength)
+	buf.Write(spoolMagic)
+	buf.WriteByte(spoolVersion)
+	binary.Write(buf, binary.BigEndian, uint16(len(cmd)))
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(cmd)
+	buf.Write(data)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+func decodeSpoolFrame(frame []byte) (string, []byte, error) {
+	if len(frame) < spoolHeaderLength+spoolCRCLength ||
+		!bytes.Equal(frame[0:4], spoolMagic) ||
+		spoolVersion != frame[4] {
+		return "", nil, errSpoolCorrupt
+	}
+	cmdLen := int(binary.BigEndian.Uint16(frame[5:7]))
+	dataLen := int64(binary.BigEndian.Uint32(frame[7:11]))
+	if int64(len(frame)) != int64(spoolHeaderLength+cmdLen+spoolCRCLength)+dataLen {
+		return "", nil, errSpoolCorrupt
+	}
+	body := frame[0 : len(frame)-spoolCRCLength]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(frame[len(body):]) {
+		return "", nil, errSpoolCorrupt
+	}
+	cmd := string(body[spoolHeaderLength : spoolHeaderLength+cmdLen])
+	return cmd, body[spoolHeaderLength+cmdLen:], nil
+}
+
+type spoolEntry struct {
+	path    string
+	created time.Time
+	size    int64
+}
+
+// entries returns the spool files in the order they were written.  Temporary
+// files are not included.
+func (s *spool) entries() ([]spoolEntry, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if nil != err {
+		return nil, err
+	}
+	entries := make([]spoolEntry, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, spoolFileSuffix) {
+			continue
+		}
+		// A file with an unparsable name has a zero creation time,
+		// and is therefore discarded as too old.
+		var created time.Time
+		if idx := strings.IndexByte(name, '-'); idx > 0 {
+			if nanos, err := strconv.ParseInt(name[0:idx], 10, 64); nil == err {
+				created = time.Unix(0, nanos)
+			}
+		}
+		entries = append(entries, spoolEntry{
+			path:    filepath.Join(s.dir, name),
+			created: created,
+			size:    info.Size(),
+		})
+	}
+	return entries, nil
+}
+
+func (s *spool) expired(e spoolEntry, now time.Time) bool {
+	return now.Sub(e.created) > s.maxAge
+}
+
+// drop removes a spool file which will not be sent.  The spool must be
+// locked.
+func (s *spool) drop(e spoolEntry) {
+	if err := os.Remove(e.path); nil == err {
+		s.droppedBytes += e.size
+	}
+}
+
+// write stores a payload.  Expired files are discarded, followed by the
+// oldest files until the payload fits within the size limit.
+func (s *spool) write(cmd string, data []byte, now time.Time) {
+	frame := encodeSpoolFrame(cmd, data)
+	size := int64(len(frame))
+
+	s.Lock()
+	defer s.Unlock()
+
+	if size > s.maxBytes {
+		s.droppedBytes += size
+		return
+	}
+
+	entries, err := s.entries()
+	if nil != err {
+		log.Warn("unable to read spool", log.Context{
+			"dir":   s.dir,
+			"error": err.Error(),
+		})
+		s.droppedBytes += size
+		return
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	for _, e := range entries {
+		if !s.expired(e, now) && total+size <= s.maxBytes {
+			break
+		}
+		s.drop(e)
+		total -= e.size
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%d-%d%s", now.UnixNano(), os.Getpid(), s.seq, spoolFileSuffix)
+	path := filepath.Join(s.dir, name)
+	tmp := path + spoolTempSuffix
+	err = ioutil.WriteFile(tmp, frame, 0600)
+	if nil == err {
+		err = os.Rename(tmp, path)
+	}
+	if nil != err {
+		os.Remove(tmp)
+		log.Warn("unable to write spool", log.Context{
+			"dir":   s.dir,
+			"error": err.Error(),
+		})
+		s.droppedBytes += size
+		return
+	}
+	s.spooledBytes += size
+}
+
+// spoolRetryable returns true if a replayed payload should be kept for a
+// later attempt.  Replay stops when this is the case.
+func spoolRetryable(e error) bool {
+	return isCollectorUnreachable(e) || isFatalHarvestError(e) ||
+		hasAction(e, collectorRetry)
+}
+
+// replay sends the spooled payloads in order using the function given.
+// Payloads are removed once sent, or if they are expired, corrupt, or rejected
+// by the collector.  Only one replay runs at a time:  replay returns
+// immediately if another is in progress.
+func (s *spool) replay(now time.Time, send func(cmd string, data []byte) error) {
+	s.Lock()
+	if s.replaying {
+		s.Unlock()
+		return
+	}
+	s.replaying = true
+	entries, err := s.entries()
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		s.replaying = false
+		s.Unlock()
+	}()
+
+	if nil != err {
+		log.Warn("unable to read spool", log.Context{
+			"dir":   s.dir,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	for _, e := range entries {
+		if s.expired(e, now) {
+			s.Lock()
+			s.drop(e)
+			s.Unlock()
+			continue
+		}
+		frame, err := ioutil.ReadFile(e.path)
+		if os.IsNotExist(err) {
+			// The file was discarded by a concurrent write.
+			continue
+		}
+		if nil != err {
+			log.Warn("unable to read spool", log.Context{
+				"dir":   s.dir,
+				"error": err.Error(),
+			})
+			return
+		}
+		cmd, data, err := decodeSpoolFrame(frame)
+		if nil == err {
+			err = send(cmd, data)
+			if nil != err && spoolRetryable(err) {
+				return
+			}
+		}
+		if nil != err {
+			log.Warn("spooled payload discarded", log.Context{
+				"file":  e.path,
+				"cmd":   cmd,
+				"error": err.Error(),
+			})
+		}
+
+		s.Lock()
+		if nil == err {
+			if rmErr := os.Remove(e.path); nil == rmErr {
+				s.replayedBytes += e.size
+			}
+		} else {
+			s.drop(e)
+		}
+		s.Unlock()
+	}
+}
+
+func (s *spool) mergeIntoHarvest(h *harvest) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.spooledBytes > 0 {
+		h.metrics.addCount(spoolSpooledBytes, float64(s.spooledBytes), forced)
+	}
+	if s.replayedBytes > 0 {
+		h.metrics.addCount(spoolReplayedBytes, float64(s.replayedBytes), forced)
+	}
+	if s.droppedBytes > 0 {
+		h.metrics.addCount(spoolDroppedBytes, float64(s.droppedBytes), forced)
+	}
+	s.spooledBytes = 0
+	s.replayedBytes = 0
+	s.droppedBytes = 0
+}
+
+// replaceRunID replaces the agent run id which begins most payloads with the
+// run id given, since spooled payloads are sent using a later connection.
+// Payloads which do not begin with a run id are returned unchanged.
+func replaceRunID(data []byte, runID string) ([]byte, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); nil != err {
+		return nil, err
+	}
+	var old string
+	if 0 == len(fields) || nil != json.Unmarshal(fields[0], &old) {
+		return data, nil
+	}
+	// The payload is spliced rather than marshalled again so that the
+	// remaining fields are sent unchanged.
+	start := bytes.IndexByte(data, '"')
+	end := start + len(fields[0])
+	buf := &bytes.Buffer{}
+	buf.Grow(len(data) - len(fields[0]) + len(runID) + 2)
+	buf.Write(data[0:start])
+	jsonx.AppendString(buf, runID)
+	buf.Write(data[end:])
+	return buf.Bytes(), nil
+}
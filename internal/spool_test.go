@@ -0,0 +1,521 @@
+package internal
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/api"
+)
+
+func testSpool(t *testing.T, maxBytes int64) *spool {
+	dir, err := ioutil.TempDir("", "spool")
+	if nil != err {
+		t.Fatal(err)
+	}
+	s, err := newSpool(filepath.Join(dir, "nested"), maxBytes, time.Hour)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func spoolFiles(t *testing.T, s *spool) []spoolEntry {
+	entries, err := s.entries()
+	if nil != err {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+type spooledPayload struct {
+	cmd  string
+	data string
+}
+
+func replayAll(s *spool, now time.Time, result error) []spooledPayload {
+	var sent []spooledPayload
+	s.replay(now, func(cmd string, data []byte) error {
+		sent = append(sent, spooledPayload{cmd: cmd, data: string(data)})
+		return result
+	})
+	return sent
+}
+
+func TestSpoolDir(t *testing.T) {
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	cfg.Spool.Dir = "spool"
+	dir := spoolDir(&cfg)
+	if filepath.Dir(dir) != "spool" {
+		t.Error(dir)
+	}
+	if same := cfg; spoolDir(&same) != dir {
+		t.Error(spoolDir(&same))
+	}
+	renamed := cfg
+	renamed.AppName = "other app"
+	if spoolDir(&renamed) == dir {
+		t.Error("applications with different names share a spool")
+	}
+	relicensed := cfg
+	relicensed.License = "9876543210987654321098765432109876543210"
+	if spoolDir(&relicensed) == dir {
+		t.Error("applications with different licenses share a spool")
+	}
+}
+
+func TestSpoolFrame(t *testing.T) {
+	frame := encodeSpoolFrame(cmdMetrics, []byte(`["run",1,2,[]]`))
+	cmd, data, err := decodeSpoolFrame(frame)
+	if nil != err || cmd != cmdMetrics || string(data) != `["run",1,2,[]]` {
+		t.Error(cmd, string(data), err)
+	}
+
+	flipped := append([]byte(nil), frame...)
+	flipped[len(flipped)-6] ^= 0xff
+	for _, corrupt := range [][]byte{
+		nil,
+		frame[0 : len(frame)-1],
+		append(append([]byte(nil), frame...), '!'),
+		append([]byte("XXXX"), frame[4:]...),
+		flipped,
+	} {
+		if _, _, err := decodeSpoolFrame(corrupt); err != errSpoolCorrupt {
+			t.Error(string(corrupt), err)
+		}
+	}
+}
+
+func TestSpoolReplayInOrder(t *testing.T) {
+	s := testSpool(t, 1024*1024)
+	now := time.Now()
+	s.write(cmdMetrics, []byte(`1`), now)
+	s.write(cmdTxnEvents, []byte(`2`), now.Add(time.Second))
+	s.write(cmdErrorData, []byte(`3`), now.Add(2*time.Second))
+
+	sent := replayAll(s, now.Add(3*time.Second), nil)
+	if len(sent) != 3 ||
+		sent[0] != (spooledPayload{cmdMetrics, "1"}) ||
+		sent[1] != (spooledPayload{cmdTxnEvents, "2"}) ||
+		sent[2] != (spooledPayload{cmdErrorData, "3"}) {
+		t.Error(sent)
+	}
+	if files := spoolFiles(t, s); 0 != len(files) {
+		t.Error(files)
+	}
+
+	h := newHarvest(now, defaultHarvestLimits)
+	s.mergeIntoHarvest(h)
+	expectMetrics(t, h.metrics, []WantMetric{
+		{spoolSpooledBytes, "", true, nil},
+		{spoolReplayedBytes, "", true, nil},
+	})
+	spooled := h.metrics.metrics[metricID{Name: spoolSpooledBytes}].data.countSatisfied
+	replayed := h.metrics.metrics[metricID{Name: spoolReplayedBytes}].data.countSatisfied
+	if spooled != replayed || spooled <= 3 {
+		t.Error(spooled, replayed)
+	}
+
+	// The metrics are reset once harvested.
+	h = newHarvest(now, defaultHarvestLimits)
+	s.mergeIntoHarvest(h)
+	expectMetrics(t, h.metrics, []WantMetric{})
+}
+
+func TestSpoolReplayStopsWhenUnreachable(t *testing.T) {
+	s := testSpool(t, 1024*1024)
+	now := time.Now()
+	s.write(cmdMetrics, []byte(`1`), now)
+	s.write(cmdMetrics, []byte(`2`), now.Add(time.Second))
+
+	unreachable := &url.Error{Op: "Post", URL: "collector", Err: errors.New("offline")}
+	if sent := replayAll(s, now, unreachable); len(sent) != 1 {
+		t.Error(sent)
+	}
+	if files := spoolFiles(t, s); 2 != len(files) {
+		t.Error(files)
+	}
+	if sent := replayAll(s, now, nil); len(sent) != 2 || sent[0].data != "1" {
+		t.Error(sent)
+	}
+}
+
+func TestSpoolReplayDiscardsRejected(t *testing.T) {
+	s := testSpool(t, 1024*1024)
+	now := time.Now()
+	s.write(cmdMetrics, []byte(`1`), now)
+	s.write(cmdMetrics, []byte(`2`), now.Add(time.Second))
+
+	if sent := replayAll(s, now, unexpectedStatusCodeErr{code: 400}); len(sent) != 2 {
+		t.Error(sent)
+	}
+	if files := spoolFiles(t, s); 0 != len(files) {
+		t.Error(files)
+	}
+	h := newHarvest(now, defaultHarvestLimits)
+	s.mergeIntoHarvest(h)
+	expectMetrics(t, h.metrics, []WantMetric{
+		{spoolSpooledBytes, "", true, nil},
+		{spoolDroppedBytes, "", true, nil},
+	})
+}
+
+func TestSpoolReplayDiscardsCorrupt(t *testing.T) {
+	s := testSpool(t, 1024*1024)
+	now := time.Now()
+	s.write(cmdMetrics, []byte(`1`), now)
+	s.write(cmdMetrics, []byte(`2`), now.Add(time.Second))
+
+	files := spoolFiles(t, s)
+	if err := ioutil.WriteFile(files[0].path, []byte("garbage"), 0600); nil != err {
+		t.Fatal(err)
+	}
+	// Temporary files are ignored.
+	if err := ioutil.WriteFile(files[1].path+spoolTempSuffix, []byte("partial"), 0600); nil != err {
+		t.Fatal(err)
+	}
+	if sent := replayAll(s, now, nil); len(sent) != 1 || sent[0].data != "2" {
+		t.Error(sent)
+	}
+	if files := spoolFiles(t, s); 0 != len(files) {
+		t.Error(files)
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	size := int64(len(encodeSpoolFrame(cmdMetrics, []byte(`1`))))
+	s := testSpool(t, 2*size)
+	now := time.Now()
+	s.write(cmdMetrics, []byte(`1`), now)
+	s.write(cmdMetrics, []byte(`2`), now.Add(time.Second))
+	s.write(cmdMetrics, []byte(`3`), now.Add(2*time.Second))
+	// This payload is larger than the spool.
+	s.write(cmdMetrics, []byte(strings.Repeat("x", int(2*size))), now.Add(3*time.Second))
+
+	if sent := replayAll(s, now, nil); len(sent) != 2 || sent[0].data != "2" || sent[1].data != "3" {
+		t.Error(sent)
+	}
+	h := newHarvest(now, defaultHarvestLimits)
+	s.mergeIntoHarvest(h)
+	if m := h.metrics.metrics[metricID{Name: spoolDroppedBytes}]; nil == m ||
+		m.data.countSatisfied != float64(4*size-1) {
+		t.Error(m)
+	}
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	s := testSpool(t, 1024*1024)
+	now := time.Now()
+	s.write(cmdMetrics, []byte(`1`), now)
+	s.write(cmdMetrics, []byte(`2`), now.Add(time.Minute))
+
+	if sent := replayAll(s, now.Add(time.Hour+time.Second), nil); len(sent) != 1 || sent[0].data != "2" {
+		t.Error(sent)
+	}
+
+	// Expired payloads are also discarded when writing.
+	s.write(cmdMetrics, []byte(`3`), now)
+	s.write(cmdMetrics, []byte(`4`), now.Add(2*time.Hour))
+	if files := spoolFiles(t, s); 1 != len(files) {
+		t.Error(files)
+	}
+}
+
+func TestReplaceRunID(t *testing.T) {
+	testcases := []struct {
+		input  string
+		expect string
+	}{
+		{`["old",1,2,[]]`, `["new",1,2,[]]`},
+		{`["old",{"reservoir_size":1},[{"a":"<b>"}]]`, `["new",{"reservoir_size":1},[{"a":"<b>"}]]`},
+		// Slow query payloads do not contain the run id.
+		{`[[["name"]]]`, `[[["name"]]]`},
+		{`[]`, `[]`},
+	}
+	for _, tc := range testcases {
+		out, err := replaceRunID([]byte(tc.input), "new")
+		if nil != err || string(out) != tc.expect {
+			t.Error(tc.input, string(out), err)
+		}
+	}
+	if _, err := replaceRunID([]byte(`{}`), "new"); nil == err {
+		t.Error("expected error for invalid payload")
+	}
+}
+
+// offlineRoundTripper fails data calls while offline and otherwise records
+// their uncompressed payloads.  Payloads larger than maxPayload, if non-zero,
+// are rejected with a 413 response.  If reachable is non-nil, connecting
+// blocks until it is closed.
+type offlineRoundTripper struct {
+	reachable chan struct{}
+
+	sync.Mutex
+	offline    bool
+	maxPayload int
+	payloads   map[string][]string
+}
+
+func (m *offlineRoundTripper) setOffline(offline bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.offline = offline
+}
+
+func (m *offlineRoundTripper) sent(cmd string) []string {
+	m.Lock()
+	defer m.Unlock()
+	return m.payloads[cmd]
+}
+
+func (m *offlineRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	cmd := r.URL.Query().Get("method")
+	switch cmd {
+	case cmdRedirect:
+		if nil != m.reachable {
+			<-m.reachable
+		}
+		return makeResponse(200, redirectBody), nil
+	case cmdConnect:
+		return makeResponse(200, connectBody), nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.offline {
+		return nil, errors.New("offline")
+	}
+	compressed, _ := ioutil.ReadAll(r.Body)
+	data, _ := uncompress(compressed)
+	if m.maxPayload > 0 && len(data) > m.maxPayload {
+		return makeResponse(413, ""), nil
+	}
+	m.payloads[cmd] = append(m.payloads[cmd], string(data))
+	return makeResponse(200, `{"return_value":null}`), nil
+}
+
+func (m *offlineRoundTripper) CancelRequest(req *http.Request) {}
+
+func TestSpoolUnreachableCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	transport := &offlineRoundTripper{offline: true, payloads: make(map[string][]string)}
+	app := testEnabledAppConfig(t, transport, func(cfg *api.Config) {
+		cfg.EventHarvestPeriod = time.Second
+		cfg.Spool.Dir = dir
+	})
+	defer app.Shutdown(time.Second)
+	waitForRun(t, app)
+
+	if err := app.RecordCustomEvent("myType", map[string]interface{}{"zip": 1}); nil != err {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for 0 == len(spoolFiles(t, app.spool)) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if 0 == len(spoolFiles(t, app.spool)) {
+		t.Fatal("payload not spooled")
+	}
+
+	transport.setOffline(false)
+	run := app.getRun()
+	now := time.Now()
+	// The spool is not replayed after event harvests.
+	app.harvestWait.Add(1)
+	app.harvestRoutine(newHarvest(now, run.limits).ready(harvestEvents, now), harvestEvents, now, run)
+	if 0 == len(spoolFiles(t, app.spool)) {
+		t.Fatal("spool replayed after event harvest")
+	}
+	// The spool is replayed after the next successful metric harvest.
+	app.harvestWait.Add(1)
+	app.harvestRoutine(newHarvest(now, run.limits).ready(harvestMetricsTraces, now), harvestMetricsTraces, now, run)
+	if files := spoolFiles(t, app.spool); 0 != len(files) {
+		t.Fatal(files)
+	}
+	found := false
+	for _, p := range transport.sent(cmdCustomEvents) {
+		if strings.Contains(p, `"myType"`) {
+			found = true
+			if !strings.HasPrefix(p, `["my_agent_run_id",`) {
+				t.Error(p)
+			}
+		}
+	}
+	if !found {
+		t.Error(transport.sent(cmdCustomEvents))
+	}
+}
+
+func TestOfflineHarvest(t *testing.T) {
+	app := &App{spool: testSpool(t, 1024*1024)}
+	now := time.Now()
+	offline := app.newOfflineHarvest(now)
+	if ready := offline.take(now); nil != ready {
+		t.Error("empty offline harvest taken")
+	}
+	app.mergeData(appData{data: payloadMetric("zip")}, nil, offline)
+	ready := offline.take(now)
+	if nil == ready {
+		t.Fatal("offline harvest not taken")
+	}
+	if nil == ready.metrics.metrics[metricID{Name: "zip"}] {
+		t.Error("offline data not merged")
+	}
+	if ready := offline.take(now); nil != ready {
+		t.Error("offline harvest taken twice")
+	}
+
+	// Data is discarded while not connected without a spool.
+	if nil != (&App{}).newOfflineHarvest(now) {
+		t.Error("offline harvest created without a spool")
+	}
+}
+
+func TestSpoolDataRecordedBeforeConnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	transport := &offlineRoundTripper{
+		reachable: make(chan struct{}),
+		payloads:  make(map[string][]string),
+	}
+	app := testEnabledAppConfig(t, transport, func(cfg *api.Config) {
+		cfg.Spool.Dir = dir
+	})
+	if err := app.RecordCustomEvent("myType", map[string]interface{}{"zip": 1}); nil != err {
+		t.Fatal(err)
+	}
+	if err := app.RecordCustomMetric("myMetric", 1); nil != err {
+		t.Fatal(err)
+	}
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.End()
+	// Give the processor goroutine time to merge the data into the
+	// offline harvest.
+	time.Sleep(50 * time.Millisecond)
+
+	close(transport.reachable)
+	waitForRun(t, app)
+	app.Shutdown(5 * time.Second)
+
+	for cmd, want := range map[string]string{
+		cmdCustomEvents: `"myType"`,
+		cmdTxnEvents:    `"OtherTransaction/Go/hello"`,
+		cmdMetrics:      `"Custom/myMetric"`,
+	} {
+		found := false
+		for _, p := range transport.sent(cmd) {
+			if strings.Contains(p, want) {
+				found = true
+				if !strings.HasPrefix(p, `["my_agent_run_id",`) {
+					t.Error(p)
+				}
+			}
+		}
+		if !found {
+			t.Error(cmd, transport.sent(cmd))
+		}
+	}
+	if files := spoolFiles(t, app.spool); 0 != len(files) {
+		t.Error(files)
+	}
+}
+
+func TestSpoolHarvestMaxPayloadBytes(t *testing.T) {
+	transport := &offlineRoundTripper{payloads: make(map[string][]string)}
+	app := testPayloadApp(t, transport, 400)
+	app.spool = testSpool(t, 1024*1024)
+	now := time.Now()
+	h := newHarvest(now, defaultHarvestLimits)
+	h.customEvents = testCustomEvents(t, 10, 30)
+	app.spoolHarvest(h.ready(harvestEvents, now), now, app.getRun())
+
+	// Payloads are split before they are spooled so that they may be
+	// sent.
+	var payloads []string
+	for _, p := range replayAll(app.spool, now, nil) {
+		if p.cmd != cmdCustomEvents || len(p.data) > 400 {
+			t.Error(p.cmd, len(p.data))
+		}
+		payloads = append(payloads, p.data)
+	}
+	if len(payloads) < 2 || 10 != sentEvents(t, payloads) {
+		t.Error(payloads)
+	}
+	if n := payloadMetricCount(app, payloadSplitMetric(cmdCustomEvents)); n < 1 {
+		t.Error(n)
+	}
+}
+
+func TestReplaySpoolPayloadSizeMetrics(t *testing.T) {
+	transport := &offlineRoundTripper{payloads: make(map[string][]string)}
+	app := testPayloadApp(t, transport, 0)
+	app.spool = testSpool(t, 1024*1024)
+	now := time.Now()
+	data, err := testCustomEvents(t, 10, 30).Data("old run", now)
+	if nil != err {
+		t.Fatal(err)
+	}
+	app.spool.write(cmdCustomEvents, data, now)
+	app.replaySpool(app.getRun())
+
+	if sent := transport.sent(cmdCustomEvents); 1 != len(sent) {
+		t.Fatal(sent)
+	}
+	if n := payloadMetricCount(app, payloadUncompressedMetric(cmdCustomEvents)); 1 != n {
+		t.Error(n)
+	}
+	if n := payloadMetricCount(app, payloadCompressedMetric(cmdCustomEvents)); 1 != n {
+		t.Error(n)
+	}
+}
+
+func TestReplaySpoolPayloadTooLarge(t *testing.T) {
+	for _, tc := range []struct {
+		maxPayloadBytes int
+		collectorMax    int
+	}{
+		// The payload exceeds Config.MaxPayloadBytes.
+		{maxPayloadBytes: 400},
+		// The payload is rejected by the collector.
+		{collectorMax: 400},
+	} {
+		transport := &offlineRoundTripper{
+			maxPayload: tc.collectorMax,
+			payloads:   make(map[string][]string),
+		}
+		app := testPayloadApp(t, transport, tc.maxPayloadBytes)
+		app.spool = testSpool(t, 1024*1024)
+		now := time.Now()
+		data, err := testCustomEvents(t, 10, 30).Data("old run", now)
+		if nil != err {
+			t.Fatal(err)
+		}
+		app.spool.write(cmdCustomEvents, data, now)
+		app.replaySpool(app.getRun())
+
+		if sent := transport.sent(cmdCustomEvents); 0 != len(sent) {
+			t.Error(tc, sent)
+		}
+		if files := spoolFiles(t, app.spool); 0 != len(files) {
+			t.Error(tc, files)
+		}
+		if n := payloadMetricCount(app, payloadDroppedMetric(cmdCustomEvents)); 1 != n {
+			t.Error(tc, n)
+		}
+	}
+}
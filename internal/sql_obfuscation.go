@@ -0,0 +1,349 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/newrelic/go-agent/api/datastore"
+)
+
+// https://source.datanerd.us/agents/agent-specs/blob/master/Slow-SQLs-LEGACY.md
+
+// sqlDialect describes which quoting rules and literals are recognized when
+// obfuscating a query.
+type sqlDialect struct {
+	// doubleQuotedStrings indicates that double quotes surround string
+	// literals rather than identifiers.
+	doubleQuotedStrings   bool
+	backQuotedIdentifiers bool
+	dollarQuotes          bool
+	oracleQuotes          bool
+	uuids                 bool
+	hexLiterals           bool
+	booleans              bool
+}
+
+var (
+	sqlDialectMySQL = &sqlDialect{
+		doubleQuotedStrings:   true,
+		backQuotedIdentifiers: true,
+		hexLiterals:           true,
+		booleans:              true,
+	}
+	sqlDialectPostgres = &sqlDialect{
+		dollarQuotes: true,
+		uuids:        true,
+		booleans:     true,
+	}
+	sqlDialectOracle = &sqlDialect{
+		oracleQuotes: true,
+	}
+	sqlDialectCassandra = &sqlDialect{
+		uuids:       true,
+		hexLiterals: true,
+		booleans:    true,
+	}
+	// SQLite treats a double quoted token which does not name a column
+	// as a string literal, so double quotes are obfuscated.
+	sqlDialectSQLite = &sqlDialect{
+		doubleQuotedStrings:   true,
+		backQuotedIdentifiers: true,
+		booleans:              true,
+	}
+)
+
+// sqlDialectForProduct returns the dialect used to obfuscate a datastore
+// product's queries.  The MySQL dialect is used for unknown products since it
+// treats both single and double quoted text as literals.
+func sqlDialectForProduct(product datastore.Product) *sqlDialect {
+	switch product {
+	case datastore.Postgres:
+		return sqlDialectPostgres
+	case datastore.Oracle:
+		return sqlDialectOracle
+	case datastore.Cassandra:
+		return sqlDialectCassandra
+	case datastore.SQLite:
+		return sqlDialectSQLite
+	default:
+		return sqlDialectMySQL
+	}
+}
+
+const (
+	// obfuscatedLiteral replaces each literal and comment.  If the query
+	// cannot be obfuscated safely, for example if it contains an
+	// unterminated string, the entire query is replaced.
+	obfuscatedLiteral = "?"
+)
+
+func isSQLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSQLHexDigit(c byte) bool {
+	return isSQLDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isSQLIdentifierStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || '_' == c || c >= 0x80
+}
+
+func isSQLIdentifierChar(c byte) bool {
+	return isSQLIdentifierStart(c) || isSQLDigit(c) || '$' == c
+}
+
+// scanIdentifierChars returns the index of the first non identifier character
+// at or after i.
+func scanIdentifierChars(query string, i int) int {
+	for i < len(query) && isSQLIdentifierChar(query[i]) {
+		i++
+	}
+	return i
+}
+
+// scanQuotedLiteral returns the index following the literal which starts with
+// the quote at index i.  A doubled quote is an escaped quote.  A backslash
+// escaped quote may indicate either an escaped quote or a string ending in a
+// backslash, so the remainder of the query is treated as part of the literal.
+func scanQuotedLiteral(query string, i int, quote byte) (int, bool) {
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			if j+1 < len(query) && quote == query[j+1] {
+				return len(query), true
+			}
+		case quote:
+			if j+1 < len(query) && quote == query[j+1] {
+				j++
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return 0, false
+}
+
+// scanQuotedIdentifier returns the index following the identifier which
+// starts with the quote at index i.
+func scanQuotedIdentifier(query string, i int, quote byte) (int, bool) {
+	for j := i + 1; j < len(query); j++ {
+		if quote == query[j] {
+			if j+1 < len(query) && quote == query[j+1] {
+				j++
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return 0, false
+}
+
+// scanUUID returns the index following the UUID starting at index i, or -1
+// if there is no UUID at i.  UUIDs are 32 hex digits which may be separated by
+// dashes and surrounded by braces.
+func scanUUID(query string, i int) int {
+	j := i
+	if j < len(query) && '{' == query[j] {
+		j++
+	}
+	for digits := 0; digits < 32; digits++ {
+		if j >= len(query) || !isSQLHexDigit(query[j]) {
+			return -1
+		}
+		j++
+		for j < len(query) && '-' == query[j] {
+			j++
+		}
+	}
+	if j < len(query) && '}' == query[j] {
+		j++
+	}
+	if j < len(query) && isSQLIdentifierChar(query[j]) {
+		return -1
+	}
+	return j
+}
+
+// scanNumber returns the index following the numeric literal starting with
+// the digit at index i, or -1 if the digits are part of an identifier.
+func scanNumber(query string, i int, d *sqlDialect) int {
+	j := i
+	if d.hexLiterals && '0' == query[j] && j+2 < len(query) &&
+		('x' == query[j+1] || 'X' == query[j+1]) && isSQLHexDigit(query[j+2]) {
+		j += 2
+		for j < len(query) && isSQLHexDigit(query[j]) {
+			j++
+		}
+	} else {
+		for j < len(query) && isSQLDigit(query[j]) {
+			j++
+		}
+		if j+1 < len(query) && '.' == query[j] && isSQLDigit(query[j+1]) {
+			j++
+			for j < len(query) && isSQLDigit(query[j]) {
+				j++
+			}
+		}
+		if j+1 < len(query) && ('e' == query[j] || 'E' == query[j]) {
+			k := j + 1
+			if '+' == query[k] || '-' == query[k] {
+				k++
+			}
+			if k < len(query) && isSQLDigit(query[k]) {
+				j = k
+				for j < len(query) && isSQLDigit(query[j]) {
+					j++
+				}
+			}
+		}
+	}
+	if j < len(query) && isSQLIdentifierChar(query[j]) {
+		return -1
+	}
+	return j
+}
+
+// scanDollarQuote returns the index following the Postgres dollar quoted
+// string starting at index i.  If there is no dollar quote at i, -1 is
+// returned.
+func scanDollarQuote(query string, i int) (int, bool) {
+	j := i + 1
+	if j < len(query) && isSQLIdentifierStart(query[j]) {
+		for j < len(query) && isSQLIdentifierChar(query[j]) && '$' != query[j] {
+			j++
+		}
+	}
+	if j >= len(query) || '$' != query[j] {
+		return -1, true
+	}
+	tag := query[i : j+1]
+	end := strings.Index(query[j+1:], tag)
+	if end < 0 {
+		return 0, false
+	}
+	return j + 1 + end + len(tag), true
+}
+
+var oracleQuoteClosers = map[byte]byte{
+	'[': ']',
+	'{': '}',
+	'<': '>',
+	'(': ')',
+}
+
+// scanOracleQuote returns the index following the Oracle alternative quoted
+// string q'[...]' whose opening quote is at index i.
+func scanOracleQuote(query string, i int) (int, bool) {
+	if i+1 >= len(query) {
+		return 0, false
+	}
+	closer, ok := oracleQuoteClosers[query[i+1]]
+	if !ok {
+		closer = query[i+1]
+	}
+	end := strings.Index(query[i+2:], string([]byte{closer, '\''}))
+	if end < 0 {
+		return 0, false
+	}
+	return i + 2 + end + 2, true
+}
+
+// obfuscateSQL replaces the literals and comments within the query with
+// obfuscatedLiteral.
+func obfuscateSQL(query string, d *sqlDialect) string {
+	buf := make([]byte, 0, len(query))
+	i := 0
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case '\'' == c || ('"' == c && d.doubleQuotedStrings):
+			end, ok := scanQuotedLiteral(query, i, c)
+			if !ok {
+				return obfuscatedLiteral
+			}
+			buf = append(buf, obfuscatedLiteral...)
+			i = end
+		case '"' == c || ('`' == c && d.backQuotedIdentifiers):
+			end, ok := scanQuotedIdentifier(query, i, c)
+			if !ok {
+				return obfuscatedLiteral
+			}
+			buf = append(buf, query[i:end]...)
+			i = end
+		case '#' == c || ('-' == c && strings.HasPrefix(query[i:], "--")):
+			end := strings.IndexAny(query[i:], "\r\n")
+			if end < 0 {
+				end = len(query) - i
+			}
+			buf = append(buf, obfuscatedLiteral...)
+			i += end
+		case '/' == c && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += 2 + end + 2
+			}
+			buf = append(buf, obfuscatedLiteral...)
+		case '$' == c && d.dollarQuotes:
+			end, ok := scanDollarQuote(query, i)
+			if !ok {
+				return obfuscatedLiteral
+			}
+			if end < 0 {
+				// Positional parameters such as $1 are
+				// retained.
+				end = i + 1
+				for end < len(query) && isSQLDigit(query[end]) {
+					end++
+				}
+				buf = append(buf, query[i:end]...)
+			} else {
+				buf = append(buf, obfuscatedLiteral...)
+			}
+			i = end
+		case isSQLDigit(c) || isSQLIdentifierStart(c) || '{' == c:
+			if d.uuids {
+				if end := scanUUID(query, i); end >= 0 {
+					buf = append(buf, obfuscatedLiteral...)
+					i = end
+					break
+				}
+			}
+			if isSQLDigit(c) {
+				if end := scanNumber(query, i, d); end >= 0 {
+					buf = append(buf, obfuscatedLiteral...)
+					i = end
+					break
+				}
+			}
+			if '{' == c {
+				buf = append(buf, c)
+				i++
+				break
+			}
+			end := scanIdentifierChars(query, i)
+			word := query[i:end]
+			if d.oracleQuotes && end < len(query) && '\'' == query[end] &&
+				("q" == word || "Q" == word) {
+				quoteEnd, ok := scanOracleQuote(query, end)
+				if !ok {
+					return obfuscatedLiteral
+				}
+				buf = append(buf, obfuscatedLiteral...)
+				i = quoteEnd
+				break
+			}
+			if d.booleans && (strings.EqualFold(word, "true") || strings.EqualFold(word, "false")) {
+				buf = append(buf, obfuscatedLiteral...)
+			} else {
+				buf = append(buf, word...)
+			}
+			i = end
+		default:
+			buf = append(buf, c)
+			i++
+		}
+	}
+	return string(buf)
+}
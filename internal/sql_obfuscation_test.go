@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/newrelic/go-agent/api/datastore"
+	"github.com/newrelic/go-agent/internal/crossagent"
+)
+
+func TestCrossAgentSQLObfuscation(t *testing.T) {
+	var tcs []struct {
+		Name       string   `json:"name"`
+		SQL        string   `json:"sql"`
+		Obfuscated []string `json:"obfuscated"`
+		Dialects   []string `json:"dialects"`
+	}
+
+	err := crossagent.ReadJSON("sql_obfuscation/sql_obfuscation.json", &tcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dialects := map[string]*sqlDialect{
+		"mysql":     sqlDialectMySQL,
+		"postgres":  sqlDialectPostgres,
+		"oracle":    sqlDialectOracle,
+		"cassandra": sqlDialectCassandra,
+	}
+
+	for _, tc := range tcs {
+		for _, name := range tc.Dialects {
+			d, ok := dialects[name]
+			if !ok {
+				t.Error(tc.Name, "unknown dialect", name)
+				continue
+			}
+			out := obfuscateSQL(tc.SQL, d)
+			found := false
+			for _, expect := range tc.Obfuscated {
+				if out == expect {
+					found = true
+				}
+			}
+			if !found {
+				t.Error(tc.Name, name, out, tc.Obfuscated)
+			}
+		}
+	}
+}
+
+func TestObfuscateSQL(t *testing.T) {
+	tcs := []struct {
+		input   string
+		product datastore.Product
+		expect  string
+	}{
+		{"", datastore.MySQL, ""},
+		{"SELECT * FROM users WHERE id = $1", datastore.Postgres, "SELECT * FROM users WHERE id = $1"},
+		{"SELECT * FROM t WHERE x = $tag$unterminated", datastore.Postgres, "?"},
+		{"SELECT * FROM t WHERE x = q'[unterminated", datastore.Oracle, "?"},
+		{"SELECT * FROM `unterminated", datastore.MySQL, "?"},
+		{"SELECT * FROM t WHERE x = 1 /* unterminated", datastore.MySQL, "SELECT * FROM t WHERE x = ? ?"},
+		{"SELECT * FROM t WHERE x = \"zap\"", datastore.SQLite, "SELECT * FROM t WHERE x = ?"},
+		{"SELECT * FROM t WHERE x = \"zap\"", datastore.Redis, "SELECT * FROM t WHERE x = ?"},
+		{"SELECT * FROM t WHERE x = 'é' AND y = 12", datastore.MySQL, "SELECT * FROM t WHERE x = ? AND y = ?"},
+	}
+	for _, tc := range tcs {
+		out := obfuscateSQL(tc.input, sqlDialectForProduct(tc.product))
+		if out != tc.expect {
+			t.Error(tc.input, tc.product, out, tc.expect)
+		}
+	}
+}
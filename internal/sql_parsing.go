@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// sqlSubqueryTable is used as the table name when a query selects
+	// from a subquery.
+	sqlSubqueryTable = "(subquery)"
+
+	sqlTableQuotes = "`\"'"
+	sqlTablePrefix = `[\s` + sqlTableQuotes + `(\[{]*`
+	sqlTableName   = `([^\s,;()\[\]{}]+)`
+)
+
+var (
+	sqlCommentRegex      = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	sqlLineCommentRegex  = regexp.MustCompile(`(?m)(?:--|#).*$`)
+	sqlFirstWordRegex    = regexp.MustCompile(`^\w+`)
+	sqlFromTableRegex    = regexp.MustCompile(`(?is)^.*?\sfrom\b` + sqlTablePrefix + sqlTableName)
+	sqlIntoTableRegex    = regexp.MustCompile(`(?is)^.*?\sinto\b` + sqlTablePrefix + sqlTableName)
+	sqlUpdateTableRegex  = regexp.MustCompile(`(?is)^update(?:\s+(?:low_priority|ignore|only))*\s+` + sqlTablePrefix + sqlTableName)
+	sqlOperationTableRes = map[string]*regexp.Regexp{
+		"select":   sqlFromTableRegex,
+		"delete":   sqlFromTableRegex,
+		"insert":   sqlIntoTableRegex,
+		"update":   sqlUpdateTableRegex,
+		"call":     nil,
+		"show":     nil,
+		"create":   nil,
+		"drop":     nil,
+		"alter":    nil,
+		"set":      nil,
+		"exec":     nil,
+		"execute":  nil,
+		"commit":   nil,
+		"rollback": nil,
+	}
+)
+
+// sqlTableFromMatch removes the quotes and database name from a table name
+// match.
+func sqlTableFromMatch(match string) string {
+	if idx := strings.LastIndex(match, "."); idx >= 0 {
+		match = match[idx+1:]
+	}
+	match = strings.Trim(match, sqlTableQuotes)
+	if strings.EqualFold(match, "select") {
+		return sqlSubqueryTable
+	}
+	return match
+}
+
+// parseSQL extracts the lowercase operation and the table name from a query.
+// Empty strings are returned for values which cannot be determined.  The
+// table name is only extracted for select, delete, insert, and update
+// queries.
+func parseSQL(query string) (operation, table string) {
+	query = sqlCommentRegex.ReplaceAllString(query, " ")
+	query = sqlLineCommentRegex.ReplaceAllString(query, "")
+	query = strings.TrimLeft(query, " \t\r\n;")
+
+	operation = strings.ToLower(sqlFirstWordRegex.FindString(query))
+	re, ok := sqlOperationTableRes[operation]
+	if !ok {
+		return "", ""
+	}
+	if nil == re {
+		return operation, ""
+	}
+	if m := re.FindStringSubmatch(query); nil != m {
+		table = sqlTableFromMatch(m[1])
+	}
+	return operation, table
+}
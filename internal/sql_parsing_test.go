@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/newrelic/go-agent/internal/crossagent"
+)
+
+func TestCrossAgentSQLParsing(t *testing.T) {
+	var tcs []struct {
+		Input     string `json:"input"`
+		Operation string `json:"operation"`
+		Table     string `json:"table"`
+	}
+
+	err := crossagent.ReadJSON("sql_parsing.json", &tcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tcs {
+		operation, table := parseSQL(tc.Input)
+		if operation != tc.Operation || table != tc.Table {
+			t.Error(tc.Input, operation, table, tc.Operation, tc.Table)
+		}
+	}
+}
+
+func TestParseSQL(t *testing.T) {
+	tcs := []struct {
+		input     string
+		operation string
+		table     string
+	}{
+		{"", "", ""},
+		{"GET mykey", "", ""},
+		{"DELETE FROM users WHERE id = 1", "delete", "users"},
+		{"-- comment\nCALL my_procedure()", "call", ""},
+		{"show tables", "show", ""},
+		{"UPDATE LOW_PRIORITY IGNORE accounts SET x = 1", "update", "accounts"},
+		{"SELECT * FROM", "select", ""},
+	}
+	for _, tc := range tcs {
+		operation, table := parseSQL(tc.input)
+		if operation != tc.operation || table != tc.table {
+			t.Error(tc.input, operation, table, tc.operation, tc.table)
+		}
+	}
+}
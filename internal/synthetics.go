@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// https://newrelic.atlassian.net/wiki/display/eng/Agent+Support+for+Synthetics%3A+Forced+Transaction+Traces+and+Analytic+Events
+
+const (
+	syntheticsHeader = "X-NewRelic-Synthetics"
+	// syntheticsVersion is the only supported version of the header
+	// payload.
+	syntheticsVersion = 1
+
+	// maxSyntheticsTraces is the number of synthetic transaction traces
+	// kept each harvest in addition to the slowest trace.
+	maxSyntheticsTraces = 20
+
+	// syntheticsEventStamp is higher than any random stamp so that the
+	// transaction events of synthetic requests are never dropped in favor
+	// of other events.
+	syntheticsEventStamp = eventStamp(math.MaxFloat32)
+)
+
+var (
+	errSyntheticsMalformed        = errors.New("malformed synthetics header")
+	errSyntheticsVersion          = errors.New("unsupported synthetics header version")
+	errSyntheticsUntrustedAccount = errors.New("synthetics header is not from a trusted account")
+)
+
+// syntheticsInfo is the content of the X-NewRelic-Synthetics header:
+// [version, account id, resource id, job id, monitor id].
+type syntheticsInfo struct {
+	resourceID string
+	jobID      string
+	monitorID  string
+	// header is propagated unchanged on outbound requests.
+	header string
+	// guid is assigned when the transaction ends.
+	guid string
+}
+
+func parseSyntheticsHeader(reply *ConnectReply, header string) (*syntheticsInfo, error) {
+	js, err := catDeobfuscate(header, reply.EncodingKey)
+	if nil != err {
+		return nil, err
+	}
+	var fields []interface{}
+	if err := json.Unmarshal(js, &fields); nil != err {
+		return nil, errSyntheticsMalformed
+	}
+	if 5 != len(fields) {
+		return nil, errSyntheticsMalformed
+	}
+	version, ok := fields[0].(float64)
+	if !ok {
+		return nil, errSyntheticsMalformed
+	}
+	if syntheticsVersion != version {
+		return nil, errSyntheticsVersion
+	}
+	account, ok := fields[1].(float64)
+	if !ok {
+		return nil, errSyntheticsMalformed
+	}
+	trusted := false
+	for _, id := range reply.TrustedAccounts {
+		if float64(id) == account {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return nil, errSyntheticsUntrustedAccount
+	}
+	info := &syntheticsInfo{header: header}
+	if info.resourceID, ok = fields[2].(string); !ok {
+		return nil, errSyntheticsMalformed
+	}
+	if info.jobID, ok = fields[3].(string); !ok {
+		return nil, errSyntheticsMalformed
+	}
+	if info.monitorID, ok = fields[4].(string); !ok {
+		return nil, errSyntheticsMalformed
+	}
+	return info, nil
+}
+
+// writeTxnEventFields adds the intrinsics to a transaction event.  The
+// buffer must be within an object which already has fields.  The guid is
+// omitted if it has already been written as a cross application tracing
+// intrinsic.
+func (s *syntheticsInfo) writeTxnEventFields(buf *bytes.Buffer, writeGUID bool) {
+	w := jsonFieldsWriter{buf: buf, needsComma: true}
+	if writeGUID {
+		w.stringField("nr.guid", s.guid)
+	}
+	w.stringField("nr.syntheticsResourceId", s.resourceID)
+	w.stringField("nr.syntheticsJobId", s.jobID)
+	w.stringField("nr.syntheticsMonitorId", s.monitorID)
+}
+
+// writeTraceIntrinsics adds the intrinsics to a transaction trace.
+func (s *syntheticsInfo) writeTraceIntrinsics(w *jsonFieldsWriter) {
+	if nil == s {
+		return
+	}
+	w.stringField("synthetics_resource_id", s.resourceID)
+	w.stringField("synthetics_job_id", s.jobID)
+	w.stringField("synthetics_monitor_id", s.monitorID)
+}
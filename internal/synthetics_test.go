@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/internal/crossagent"
+)
+
+func TestCrossAgentSynthetics(t *testing.T) {
+	var tcs []struct {
+		Name     string `json:"name"`
+		Settings struct {
+			AgentEncodingKey  string `json:"agentEncodingKey"`
+			TransactionGUID   string `json:"transactionGuid"`
+			TrustedAccountIDs []int  `json:"trustedAccountIds"`
+		} `json:"settings"`
+		InputObfuscatedHeader  map[string]string `json:"inputObfuscatedHeader"`
+		OutputTransactionTrace struct {
+			Header struct {
+				Field9 *string `json:"field_9"`
+			} `json:"header"`
+			ExpectedIntrinsics    map[string]string `json:"expectedIntrinsics"`
+			NonExpectedIntrinsics []string          `json:"nonExpectedIntrinsics"`
+		} `json:"outputTransactionTrace"`
+		OutputTransactionEvent struct {
+			ExpectedAttributes    map[string]string `json:"expectedAttributes"`
+			NonExpectedAttributes []string          `json:"nonExpectedAttributes"`
+		} `json:"outputTransactionEvent"`
+		OutputExternalRequestHeader struct {
+			ExpectedHeader    map[string]string `json:"expectedHeader"`
+			NonExpectedHeader []string          `json:"nonExpectedHeader"`
+		} `json:"outputExternalRequestHeader"`
+	}
+
+	err := crossagent.ReadJSON("synthetics/synthetics.json", &tcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tcs {
+		reply := connectReplyDefaults()
+		reply.EncodingKey = tc.Settings.AgentEncodingKey
+		reply.TrustedAccounts = tc.Settings.TrustedAccountIDs
+
+		var info *syntheticsInfo
+		if header, ok := tc.InputObfuscatedHeader[syntheticsHeader]; ok {
+			info, _ = parseSyntheticsHeader(reply, header)
+		}
+		if nil != info {
+			info.guid = tc.Settings.TransactionGUID
+		}
+
+		event := &txnEvent{
+			Name:       "WebTransaction/Go/hello",
+			zone:       apdexNone,
+			synthetics: info,
+		}
+		js, err := event.MarshalJSON()
+		if nil != err {
+			t.Fatal(tc.Name, err)
+		}
+		var eventFields []map[string]interface{}
+		if err := json.Unmarshal(js, &eventFields); nil != err {
+			t.Fatal(tc.Name, string(js), err)
+		}
+		for key, val := range tc.OutputTransactionEvent.ExpectedAttributes {
+			if actual, ok := eventFields[0][key]; !ok || actual != val {
+				t.Error(tc.Name, key, actual, val)
+			}
+		}
+		for _, key := range tc.OutputTransactionEvent.NonExpectedAttributes {
+			if actual, ok := eventFields[0][key]; ok {
+				t.Error(tc.Name, key, actual)
+			}
+		}
+
+		trace := &harvestTrace{
+			start:      time.Now(),
+			finalName:  "WebTransaction/Go/hello",
+			synthetics: info,
+		}
+		js, err = trace.MarshalJSON()
+		if nil != err {
+			t.Fatal(tc.Name, err)
+		}
+		var traceFields []interface{}
+		if err := json.Unmarshal(js, &traceFields); nil != err {
+			t.Fatal(tc.Name, string(js), err)
+		}
+		field9 := traceFields[9]
+		if expect := tc.OutputTransactionTrace.Header.Field9; nil != expect {
+			if field9 != *expect || true != traceFields[7] {
+				t.Error(tc.Name, field9, traceFields[7])
+			}
+		} else if "" != field9 || false != traceFields[7] {
+			t.Error(tc.Name, field9, traceFields[7])
+		}
+		intrinsics := traceFields[4].([]interface{})[4].(map[string]interface{})["intrinsics"].(map[string]interface{})
+		for key, val := range tc.OutputTransactionTrace.ExpectedIntrinsics {
+			if actual, ok := intrinsics[key]; !ok || actual != val {
+				t.Error(tc.Name, key, actual, val)
+			}
+		}
+		for _, key := range tc.OutputTransactionTrace.NonExpectedIntrinsics {
+			if actual, ok := intrinsics[key]; ok {
+				t.Error(tc.Name, key, actual)
+			}
+		}
+
+		for key, val := range tc.OutputExternalRequestHeader.ExpectedHeader {
+			if key != syntheticsHeader || nil == info || info.header != val {
+				t.Error(tc.Name, key, val)
+			}
+		}
+		if 0 != len(tc.OutputExternalRequestHeader.NonExpectedHeader) && nil != info {
+			t.Error(tc.Name, info.header)
+		}
+	}
+}
+
+func TestSyntheticsEventsKept(t *testing.T) {
+	events := newTxnEvents(2)
+	events.AddTxnEvent(&txnEvent{Name: "synthetic", synthetics: &syntheticsInfo{}})
+	for i := 0; i < 10; i++ {
+		events.AddTxnEvent(&txnEvent{Name: "regular"})
+	}
+	kept := 0
+	for _, e := range *events.events.events {
+		if "synthetic" == e.jsonWriter.(*txnEvent).Name {
+			kept++
+		}
+	}
+	if 1 != kept {
+		t.Error(kept)
+	}
+}
+
+func TestHarvestTracesKeepsSynthetics(t *testing.T) {
+	start := time.Now()
+	traces := newHarvestTraces()
+	for i := 0; i < maxSyntheticsTraces+1; i++ {
+		traces.Witness(harvestTrace{
+			start:      start,
+			duration:   time.Duration(i) * time.Millisecond,
+			finalName:  "WebTransaction/Go/synthetic",
+			synthetics: &syntheticsInfo{resourceID: "r"},
+		})
+	}
+	traces.Witness(harvestTrace{start: start, duration: time.Millisecond, finalName: "WebTransaction/Go/regular"})
+	if len(traces.synthetics) != maxSyntheticsTraces {
+		t.Error(len(traces.synthetics))
+	}
+	// The synthetics trace which exceeds the limit competes to be the
+	// slowest trace.
+	if traces.trace.finalName != "WebTransaction/Go/synthetic" {
+		t.Error(traces.trace.finalName)
+	}
+
+	h := newHarvest(start, defaultHarvestLimits)
+	traces.mergeIntoHarvest(h)
+	if n := len(h.txnTraces.saved()); n != maxSyntheticsTraces+1 {
+		t.Error(n)
+	}
+	js, err := traces.Data("run", start)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var data []interface{}
+	if err := json.Unmarshal(js, &data); nil != err {
+		t.Fatal(err)
+	}
+	if n := len(data[1].([]interface{})); n != maxSyntheticsTraces+1 {
+		t.Error(n)
+	}
+}
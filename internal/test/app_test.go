@@ -3,6 +3,7 @@ package test
 import (
 	"net/http"
 	"testing"
+	"time"
 
 	newrelic "github.com/newrelic/go-agent"
 	"github.com/newrelic/go-agent/api"
@@ -162,6 +163,21 @@ func TestRecordCustomEventRemoteDisable(t *testing.T) {
 	app.ExpectCustomEvents(t, []internal.WantCustomEvent{})
 }
 
+func TestShutdownIgnoresLaterData(t *testing.T) {
+	app := testApp(nil, nil, t)
+	app.Shutdown(time.Second)
+	if err := app.RecordCustomEvent("myType", validParams); nil != err {
+		t.Error(err)
+	}
+	txn := app.StartTransaction("myName", nil, nil)
+	if err := txn.End(); nil != err {
+		t.Error(err)
+	}
+	app.ExpectCustomEvents(t, []internal.WantCustomEvent{})
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{})
+	app.ExpectMetrics(t, []internal.WantMetric{})
+}
+
 type sampleResponseWriter struct {
 	code    int
 	written int
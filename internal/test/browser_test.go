@@ -0,0 +1,178 @@
+package test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/go-agent"
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/internal"
+)
+
+const browserLicense = "0123456789012345678901234567890123456789"
+
+func browserReply(reply *internal.ConnectReply) {
+	reply.AgentLoader = "loader();"
+	reply.Beacon = "my_beacon"
+	reply.BrowserKey = "my_browser_key"
+	reply.AppID = "my_application_id"
+	reply.ErrorBeacon = "my_error_beacon"
+	reply.JSAgentFile = "my_js_agent_file"
+}
+
+// browserFooterInfo parses the footer and deobfuscates its transaction name
+// and attributes.
+func browserFooterInfo(t *testing.T, footer string) (info map[string]interface{}, name string, atts string) {
+	const prefix = `<script type="text/javascript">window.NREUM||(NREUM={});NREUM.info=`
+	if !strings.HasPrefix(footer, prefix) || !strings.HasSuffix(footer, `</script>`) {
+		t.Fatal(footer)
+	}
+	js := strings.TrimSuffix(strings.TrimPrefix(footer, prefix), `</script>`)
+	if err := json.Unmarshal([]byte(js), &info); nil != err {
+		t.Fatal(js, err)
+	}
+	deobfuscate := func(s string) string {
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if nil != err {
+			t.Fatal(s, err)
+		}
+		for i := range decoded {
+			decoded[i] ^= browserLicense[i%13]
+		}
+		return string(decoded)
+	}
+	return info, deobfuscate(info["transactionName"].(string)), deobfuscate(info["atts"].(string))
+}
+
+func TestBrowserTiming(t *testing.T) {
+	cfgfn := func(cfg *api.Config) { cfg.BrowserMonitoring.Attributes.Enabled = true }
+	app := testApp(browserReply, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.AddAttribute("zip", "zap")
+
+	if h := txn.BrowserTimingHeader(); h != `<script type="text/javascript">loader();</script>` {
+		t.Error(h)
+	}
+	info, name, atts := browserFooterInfo(t, string(txn.BrowserTimingFooter()))
+	if name != "WebTransaction/Go/hello" {
+		t.Error(name)
+	}
+	if atts != `{"u":{"zip":"zap"}}` {
+		t.Error(atts)
+	}
+	if info["beacon"] != "my_beacon" || info["licenseKey"] != "my_browser_key" ||
+		info["applicationID"] != "my_application_id" || info["agent"] != "my_js_agent_file" {
+		t.Error(info)
+	}
+
+	// The footer freezes the name.
+	txn.SetName("other")
+	txn.End()
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name: "WebTransaction/Go/hello",
+		Zone: "S",
+	}})
+
+	if h := txn.BrowserTimingHeader(); "" != h {
+		t.Error(h)
+	}
+}
+
+func TestBrowserTimingAttributesDisabledByDefault(t *testing.T) {
+	app := testApp(browserReply, nil, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.AddAttribute("zip", "zap")
+	txn.BrowserTimingHeader()
+	info, _, _ := browserFooterInfo(t, string(txn.BrowserTimingFooter()))
+	if info["atts"] != "" {
+		t.Error(info["atts"])
+	}
+}
+
+func TestBrowserTimingFooterWithoutHeader(t *testing.T) {
+	app := testApp(browserReply, nil, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	if f := txn.BrowserTimingFooter(); "" != f {
+		t.Error(f)
+	}
+}
+
+func TestBrowserTimingDisabled(t *testing.T) {
+	cfgfn := func(cfg *api.Config) { cfg.BrowserMonitoring.Enabled = false }
+	app := testApp(browserReply, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	if h := txn.BrowserTimingHeader(); "" != h {
+		t.Error(h)
+	}
+	if f := txn.BrowserTimingFooter(); "" != f {
+		t.Error(f)
+	}
+}
+
+func TestBrowserTimingNoLoader(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	if h := txn.BrowserTimingHeader(); "" != h {
+		t.Error(h)
+	}
+}
+
+func TestBrowserResponseWriter(t *testing.T) {
+	app := testApp(browserReply, nil, t)
+	w := newCompatibleResponseRecorder()
+	txn := app.StartTransaction("hello", w, helloRequest)
+
+	bw := newrelic.NewBrowserResponseWriter(txn, txn)
+	bw.Header().Set("Content-Length", "57")
+	bw.WriteHeader(201)
+	io.WriteString(bw, `<html><head><title>hi</title></head>`)
+	io.WriteString(bw, `<body>hello</body></html>`)
+	if 0 != w.Body.Len() {
+		t.Error("response not buffered", w.Body.String())
+	}
+	if err := bw.Close(); nil != err {
+		t.Fatal(err)
+	}
+	txn.End()
+
+	body := w.Body.String()
+	if !strings.HasPrefix(body, `<html><head><script type="text/javascript">loader();</script><title>hi</title></head><body>hello<script type="text/javascript">window.NREUM`) ||
+		!strings.HasSuffix(body, `</script></body></html>`) {
+		t.Error(body)
+	}
+	if w.Code != 201 {
+		t.Error(w.Code)
+	}
+	if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(len(body)) {
+		t.Error(cl, len(body))
+	}
+}
+
+func TestBrowserResponseWriterNotHTML(t *testing.T) {
+	app := testApp(browserReply, nil, t)
+	w := newCompatibleResponseRecorder()
+	txn := app.StartTransaction("hello", w, helloRequest)
+
+	page := `<html><head></head><body></body></html>`
+	for _, h := range []http.Header{
+		{"Content-Type": []string{"application/json"}},
+		{"Content-Type": []string{"text/html"}, "Content-Encoding": []string{"gzip"}},
+	} {
+		w = newCompatibleResponseRecorder()
+		bw := newrelic.NewBrowserResponseWriter(txn, w)
+		for key, vals := range h {
+			bw.Header()[key] = vals
+		}
+		io.WriteString(bw, page)
+		bw.Close()
+		if w.Body.String() != page {
+			t.Error(h, w.Body.String())
+		}
+	}
+	txn.End()
+}
@@ -0,0 +1,207 @@
+package test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/internal"
+)
+
+const (
+	catEncodingKey    = "d67afc830dab717fd163bfcb0b8b88423e9a1a3b"
+	catCrossProcessID = "1#1"
+	catTrustedAccount = 1
+)
+
+func catReply(reply *internal.ConnectReply) {
+	reply.EncodingKey = catEncodingKey
+	reply.CrossProcessID = catCrossProcessID
+	reply.TrustedAccounts = []int{catTrustedAccount}
+}
+
+// The obfuscation algorithm is duplicated here to ensure that the headers
+// match the format expected by other agents.
+func catObfuscate(input string) string {
+	out := make([]byte, len(input))
+	for i := range input {
+		out[i] = input[i] ^ catEncodingKey[i%len(catEncodingKey)]
+	}
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+func catDeobfuscate(t *testing.T, input string) []interface{} {
+	decoded, err := base64.StdEncoding.DecodeString(input)
+	if nil != err {
+		t.Fatal(input, err)
+	}
+	for i := range decoded {
+		decoded[i] ^= catEncodingKey[i%len(catEncodingKey)]
+	}
+	var fields []interface{}
+	if err := json.Unmarshal(decoded, &fields); nil != err {
+		t.Fatal(string(decoded), err)
+	}
+	return fields
+}
+
+func catInboundRequest(account string) *http.Request {
+	req, _ := http.NewRequest("GET", "http://example.com/hello", nil)
+	req.Header.Set("X-NewRelic-ID", catObfuscate(account+"#456"))
+	req.Header.Set("X-NewRelic-Transaction", catObfuscate(`["b854df4feb2b1f06",false,"7e249074f277923d","5d2957be"]`))
+	return req
+}
+
+func TestCATInboundTrusted(t *testing.T) {
+	app := testApp(catReply, nil, t)
+	w := newCompatibleResponseRecorder()
+	txn := app.StartTransaction("hello", w, catInboundRequest("1"))
+	txn.WriteHeader(200)
+	txn.SetName("renamed")
+	txn.End()
+
+	appData := w.Header().Get("X-NewRelic-App-Data")
+	if "" == appData {
+		t.Fatal("missing app data header")
+	}
+	fields := catDeobfuscate(t, appData)
+	if len(fields) != 7 || fields[0] != catCrossProcessID ||
+		fields[1] != "WebTransaction/Go/hello" || fields[4] != -1.0 {
+		t.Error(fields)
+	}
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name:             "WebTransaction/Go/hello",
+		Zone:             "S",
+		CrossProcess:     true,
+		ReferringTxnGUID: "b854df4feb2b1f06",
+	}})
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"WebTransaction/Go/hello", "", true, nil},
+		{"WebTransaction", "", true, nil},
+		{"HttpDispatcher", "", true, nil},
+		{"Apdex", "", true, nil},
+		{"Apdex/Go/hello", "", false, nil},
+		{"ClientApplication/1#456/all", "", false, nil},
+	})
+}
+
+func TestCATInboundUntrusted(t *testing.T) {
+	app := testApp(catReply, nil, t)
+	w := newCompatibleResponseRecorder()
+	txn := app.StartTransaction("hello", w, catInboundRequest("2"))
+	txn.Write([]byte("response text"))
+	txn.End()
+
+	if h := w.Header().Get("X-NewRelic-App-Data"); "" != h {
+		t.Error(h)
+	}
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name: "WebTransaction/Go/hello",
+		Zone: "S",
+	}})
+}
+
+func TestCATInboundDisabled(t *testing.T) {
+	cfgfn := func(cfg *api.Config) { cfg.CrossApplicationTracer.Enabled = false }
+	app := testApp(catReply, cfgfn, t)
+	w := newCompatibleResponseRecorder()
+	txn := app.StartTransaction("hello", w, catInboundRequest("1"))
+	txn.WriteHeader(200)
+	txn.End()
+
+	if h := w.Header().Get("X-NewRelic-App-Data"); "" != h {
+		t.Error(h)
+	}
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name: "WebTransaction/Go/hello",
+		Zone: "S",
+	}})
+}
+
+func TestCATOutboundHeaders(t *testing.T) {
+	app := testApp(catReply, nil, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	token := txn.StartSegment()
+	txn.PrepareRequest(token, req)
+
+	id, err := base64.StdEncoding.DecodeString(req.Header.Get("X-NewRelic-ID"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	for i := range id {
+		id[i] ^= catEncodingKey[i%len(catEncodingKey)]
+	}
+	if string(id) != catCrossProcessID {
+		t.Error(string(id))
+	}
+	payload := catDeobfuscate(t, req.Header.Get("X-NewRelic-Transaction"))
+	if len(payload) != 4 || payload[0] != payload[2] || payload[1] != false {
+		t.Error(payload)
+	}
+
+	appData := catObfuscate(`["1#789","WebTransaction/Go/other",0,0.5,-1,"9323dc260548ed0e",false]`)
+	resp := &http.Response{
+		Request: req,
+		Header:  http.Header{"X-Newrelic-App-Data": []string{appData}},
+	}
+	txn.EndRequest(token, req, resp)
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name:              "OtherTransaction/Go/hello",
+		Zone:              "",
+		ExternalCallCount: 1,
+		CrossProcess:      true,
+	}})
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"OtherTransaction/Go/hello", "", true, nil},
+		{"OtherTransaction/all", "", true, nil},
+		{"External/all", "", true, nil},
+		{"External/allOther", "", true, nil},
+		{"External/example.com/all", "", false, nil},
+		{"ExternalApp/example.com/1#789/all", "", false, nil},
+		{"ExternalTransaction/example.com/1#789/WebTransaction/Go/other", "", false, nil},
+		{"ExternalTransaction/example.com/1#789/WebTransaction/Go/other", "OtherTransaction/Go/hello", false, nil},
+	})
+}
+
+func TestCATResponseUntrusted(t *testing.T) {
+	app := testApp(catReply, nil, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	resp := &http.Response{
+		Request: req,
+		Header: http.Header{"X-Newrelic-App-Data": []string{
+			catObfuscate(`["2#789","WebTransaction/Go/other",0,0.5,-1,"9323dc260548ed0e",false]`),
+		}},
+	}
+	txn.EndRequest(txn.StartSegment(), req, resp)
+	txn.End()
+
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"OtherTransaction/Go/hello", "", true, nil},
+		{"OtherTransaction/all", "", true, nil},
+		{"External/all", "", true, nil},
+		{"External/allOther", "", true, nil},
+		{"External/example.com/all", "", false, nil},
+		{"External/example.com/all", "OtherTransaction/Go/hello", false, nil},
+	})
+}
+
+func TestCATOutboundMissingEncodingKey(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	txn.PrepareRequest(txn.StartSegment(), req)
+	txn.End()
+
+	for key := range req.Header {
+		if strings.HasPrefix(strings.ToLower(key), "x-newrelic") {
+			t.Error(key)
+		}
+	}
+}
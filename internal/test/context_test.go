@@ -0,0 +1,99 @@
+// +build go1.7
+
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	newrelic "github.com/newrelic/go-agent"
+	"github.com/newrelic/go-agent/internal"
+)
+
+func TestContextRoundTrip(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("myName", nil, nil)
+	ctx := newrelic.NewContext(context.Background(), txn)
+	if out := newrelic.FromContext(ctx); out != txn {
+		t.Error(out)
+	}
+	if out := newrelic.FromContext(context.Background()); nil != out {
+		t.Error(out)
+	}
+	if out := newrelic.FromContext(nil); nil != out {
+		t.Error(out)
+	}
+}
+
+type wrappedResponseWriter struct{ http.ResponseWriter }
+
+func myContextErrorHandler(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("my response"))
+	if txn := newrelic.FromContext(req.Context()); nil != txn {
+		txn.NoticeError(myError{})
+	}
+}
+
+func TestWrapHandleContext(t *testing.T) {
+	app := testApp(nil, nil, t)
+	mux := http.NewServeMux()
+	mux.Handle(newrelic.WrapHandle(app, helloPath, http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			// Wrapping the response writer must not prevent
+			// access to the transaction.
+			myContextErrorHandler(wrappedResponseWriter{w}, req)
+		})))
+	w := newCompatibleResponseRecorder()
+	mux.ServeHTTP(w, helloRequest)
+
+	if out := w.Body.String(); "my response" != out {
+		t.Error(out)
+	}
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "WebTransaction/Go/hello",
+		Msg:     "my msg",
+		Klass:   "test.myError",
+	}})
+}
+
+func TestRoundTripperContext(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("myName", nil, nil)
+	url := "http://example.com/"
+	client := &http.Client{}
+	inner := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("hello")
+	})
+	client.Transport = newrelic.NewRoundTripper(nil, inner)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	req = req.WithContext(newrelic.NewContext(req.Context(), txn))
+	client.Do(req)
+
+	// A request without a transaction in its context is not instrumented.
+	req, err = http.NewRequest("GET", url, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	client.Do(req)
+
+	txn.End()
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"OtherTransaction/Go/myName", "", true, nil},
+		{"OtherTransaction/all", "", true, nil},
+		{"External/all", "", true, nil},
+		{"External/allOther", "", true, nil},
+		{"External/example.com/all", "", false, nil},
+		{"External/example.com/all", "OtherTransaction/Go/myName", false, nil},
+	})
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name:              "OtherTransaction/Go/myName",
+		Zone:              "",
+		ExternalCallCount: 1,
+	}})
+}
@@ -0,0 +1,34 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/internal"
+)
+
+func TestRecordCustomMetric(t *testing.T) {
+	app := testApp(nil, nil, t)
+	if err := app.RecordCustomMetric("queue/depth", 5); nil != err {
+		t.Error(err)
+	}
+	if err := app.IncrementCustomMetric("jobs", 2); nil != err {
+		t.Error(err)
+	}
+	if err := app.RecordCustomTiming("job/duration", 500*time.Millisecond); nil != err {
+		t.Error(err)
+	}
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"Custom/queue/depth", "", false, []float64{1, 5, 5, 5, 5, 25}},
+		{"Custom/jobs", "", false, []float64{2, 0, 0, 0, 0, 0}},
+		{"Custom/job/duration", "", false, []float64{1, 0.5, 0.5, 0.5, 0.5, 0.25}},
+	})
+}
+
+func TestRecordCustomMetricInvalid(t *testing.T) {
+	app := testApp(nil, nil, t)
+	if err := app.RecordCustomMetric("", 5); nil == err {
+		t.Error(err)
+	}
+	app.ExpectMetrics(t, []internal.WantMetric{})
+}
@@ -0,0 +1,221 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/go-agent"
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/api/datastore"
+	"github.com/newrelic/go-agent/internal"
+)
+
+func enableDistributedTracing(cfg *api.Config) {
+	cfg.DistributedTracer.Enabled = true
+}
+
+func distributedTracingReply(reply *internal.ConnectReply) {
+	reply.AccountID = "123"
+	reply.TrustedAccountKey = "123"
+	reply.PrimaryAppID = "456"
+}
+
+const (
+	inboundTraceID     = "0af7651916cd43dd8448eb211c80319c"
+	inboundTraceParent = "00-" + inboundTraceID + "-b7ad6b7169203331-01"
+	inboundTraceState  = "123@nr=0-0-123-789-27ddd2d8890283b4-5569065a5b1313bd-1-1.5-1518469636025,rojo=00f067aa0ba902b7"
+)
+
+func TestDistributedTracingInbound(t *testing.T) {
+	app := testApp(distributedTracingReply, enableDistributedTracing, t)
+	req, _ := http.NewRequest("GET", "http://example.com/hello", nil)
+	req.Header.Set("traceparent", inboundTraceParent)
+	req.Header.Set("tracestate", inboundTraceState)
+	txn := app.StartTransaction("hello", nil, req)
+	txn.NoticeError(errors.New("oops"))
+	txn.End()
+
+	intrinsics := map[string]interface{}{
+		"traceId":      inboundTraceID,
+		"guid":         nil,
+		"parentId":     "5569065a5b1313bd",
+		"parentSpanId": "b7ad6b7169203331",
+		"sampled":      true,
+		"priority":     1.5,
+	}
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name:       "WebTransaction/Go/hello",
+		Zone:       "F",
+		Intrinsics: intrinsics,
+	}})
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName:    "WebTransaction/Go/hello",
+		Msg:        "oops",
+		Klass:      "*errors.errorString",
+		Intrinsics: intrinsics,
+	}})
+	app.ExpectSpanEvents(t, []internal.WantSpanEvent{{
+		Name:         "WebTransaction/Go/hello",
+		Category:     "generic",
+		IsEntrypoint: true,
+		Intrinsics: map[string]interface{}{
+			"traceId":  inboundTraceID,
+			"parentId": "b7ad6b7169203331",
+		},
+	}})
+}
+
+func TestDistributedTracingInboundNotSampled(t *testing.T) {
+	app := testApp(distributedTracingReply, enableDistributedTracing, t)
+	req, _ := http.NewRequest("GET", "http://example.com/hello", nil)
+	req.Header.Set("traceparent", "00-"+inboundTraceID+"-b7ad6b7169203331-00")
+	txn := app.StartTransaction("hello", nil, req)
+	txn.EndSegment(txn.StartSegment(), "segment")
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name: "WebTransaction/Go/hello",
+		Zone: "S",
+		Intrinsics: map[string]interface{}{
+			"traceId": inboundTraceID,
+			"sampled": false,
+		},
+	}})
+	app.ExpectSpanEvents(t, []internal.WantSpanEvent{})
+}
+
+func TestDistributedTracingSegments(t *testing.T) {
+	app := testApp(distributedTracingReply, enableDistributedTracing, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.EndSegment(txn.StartSegment(), "segment")
+	txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+		Product:    datastore.MySQL,
+		Collection: "users",
+		Operation:  "SELECT",
+	})
+	txn.EndExternal(txn.StartSegment(), "http://example.com/zip?secret=shh")
+	async := txn.NewGoroutine()
+	async.EndSegment(async.StartSegment(), "async")
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name:               "OtherTransaction/Go/hello",
+		ExternalCallCount:  1,
+		DatastoreCallCount: 1,
+		Intrinsics: map[string]interface{}{
+			"traceId":  nil,
+			"guid":     nil,
+			"sampled":  true,
+			"priority": nil,
+		},
+	}})
+	app.ExpectSpanEvents(t, []internal.WantSpanEvent{
+		{Name: "OtherTransaction/Go/hello", Category: "generic", IsEntrypoint: true},
+		{Name: "Custom/segment", Category: "generic"},
+		{Name: "Datastore/statement/MySQL/users/SELECT", Category: "datastore"},
+		{
+			Name:       "External/example.com/all",
+			Category:   "http",
+			Intrinsics: map[string]interface{}{"http.url": "http://example.com/zip"},
+		},
+		{Name: "Custom/async", Category: "generic"},
+	})
+}
+
+func TestDistributedTracingSpanEventsDisabled(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		enableDistributedTracing(cfg)
+		cfg.SpanEvents.Enabled = false
+	}
+	app := testApp(distributedTracingReply, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.EndSegment(txn.StartSegment(), "segment")
+	txn.End()
+	app.ExpectSpanEvents(t, []internal.WantSpanEvent{})
+}
+
+func TestDistributedTracingSpanEventsRemotelyDisabled(t *testing.T) {
+	replyfn := func(reply *internal.ConnectReply) {
+		distributedTracingReply(reply)
+		reply.CollectSpanEvents = false
+	}
+	app := testApp(replyfn, enableDistributedTracing, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.EndSegment(txn.StartSegment(), "segment")
+	txn.End()
+	app.ExpectSpanEvents(t, []internal.WantSpanEvent{})
+}
+
+func TestDistributedTracingDisabled(t *testing.T) {
+	app := testApp(distributedTracingReply, nil, t)
+	req, _ := http.NewRequest("GET", "http://example.com/hello", nil)
+	req.Header.Set("traceparent", inboundTraceParent)
+	txn := app.StartTransaction("hello", nil, req)
+	out, _ := http.NewRequest("GET", "http://example.com", nil)
+	txn.PrepareRequest(txn.StartSegment(), out)
+	txn.End()
+
+	if h := out.Header.Get("traceparent"); "" != h {
+		t.Error(h)
+	}
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name: "WebTransaction/Go/hello",
+		Zone: "S",
+	}})
+	app.ExpectSpanEvents(t, []internal.WantSpanEvent{})
+}
+
+func TestDistributedTracingOutbound(t *testing.T) {
+	app := testApp(distributedTracingReply, enableDistributedTracing, t)
+	req, _ := http.NewRequest("GET", "http://example.com/hello", nil)
+	req.Header.Set("traceparent", inboundTraceParent)
+	req.Header.Set("tracestate", inboundTraceState)
+	txn := app.StartTransaction("hello", nil, req)
+
+	out, _ := http.NewRequest("GET", "http://example.com", nil)
+	token := txn.StartSegment()
+	txn.PrepareRequest(token, out)
+	txn.EndRequest(token, out, nil)
+	txn.End()
+
+	parent := strings.Split(out.Header.Get("traceparent"), "-")
+	if len(parent) != 4 || parent[0] != "00" || parent[1] != inboundTraceID || parent[3] != "01" {
+		t.Fatal(parent)
+	}
+	state := strings.Split(out.Header.Get("tracestate"), ",")
+	if len(state) != 2 || !strings.HasPrefix(state[0], "123@nr=0-0-123-456-"+parent[2]+"-") ||
+		state[1] != "rojo=00f067aa0ba902b7" {
+		t.Error(state)
+	}
+	app.ExpectSpanEvents(t, []internal.WantSpanEvent{
+		{Name: "WebTransaction/Go/hello", Category: "generic", IsEntrypoint: true},
+		{
+			Name:       "External/example.com/all",
+			Category:   "http",
+			Intrinsics: map[string]interface{}{"guid": parent[2]},
+		},
+	})
+}
+
+func TestDistributedTracingRoundTripper(t *testing.T) {
+	app := testApp(distributedTracingReply, enableDistributedTracing, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	var sent *http.Request
+	inner := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r
+		return &http.Response{StatusCode: 200, Request: r}, nil
+	})
+	client := &http.Client{Transport: newrelic.NewRoundTripper(txn, inner)}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	client.Do(req)
+	txn.End()
+
+	if nil == sent || "" == sent.Header.Get("traceparent") {
+		t.Fatal("traceparent not added")
+	}
+	if h := req.Header.Get("traceparent"); "" != h {
+		t.Error("original request modified", h)
+	}
+}
@@ -0,0 +1,238 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/internal"
+)
+
+func TestIgnoreErrorClasses(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		cfg.ErrorCollector.IgnoreClasses = []string{"test.myError"}
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	if err := txn.NoticeError(myError{}); nil != err {
+		t.Error(err)
+	}
+	txn.End()
+	app.ExpectErrors(t, []internal.WantError{})
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{})
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"WebTransaction/Go/hello", "", true, nil},
+		{"WebTransaction", "", true, nil},
+		{"HttpDispatcher", "", true, nil},
+		{"Apdex", "", true, nil},
+		{"Apdex/Go/hello", "", false, nil},
+	})
+}
+
+func TestIgnoreErrorMessages(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		cfg.ErrorCollector.IgnoreMessages = map[string][]string{
+			"*errors.errorString": {"ignore me"},
+		}
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.NoticeError(errors.New("ignore me"))
+	txn.NoticeError(errors.New("keep me"))
+	txn.End()
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "OtherTransaction/Go/hello",
+		Msg:     "keep me",
+		Klass:   "*errors.errorString",
+	}})
+}
+
+func TestExpectedErrorClasses(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		cfg.ErrorCollector.ExpectedClasses = []string{"test.myError"}
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.NoticeError(myError{})
+	txn.End()
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "WebTransaction/Go/hello",
+		Msg:     "my msg",
+		Klass:   "test.myError",
+		Intrinsics: map[string]interface{}{
+			"error.expected": true,
+		},
+	}})
+	// Expected errors do not affect the error rate or apdex.
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name: "WebTransaction/Go/hello",
+		Zone: "S",
+	}})
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"WebTransaction/Go/hello", "", true, nil},
+		{"WebTransaction", "", true, nil},
+		{"HttpDispatcher", "", true, nil},
+		{"Apdex", "", true, nil},
+		{"Apdex/Go/hello", "", false, nil},
+	})
+}
+
+func TestExpectedErrorStatusCodes(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		cfg.ErrorCollector.ExpectedStatusCodes = []int{http.StatusConflict}
+	}
+	app := testApp(nil, cfgfn, t)
+	w := newCompatibleResponseRecorder()
+	txn := app.StartTransaction("hello", w, helloRequest)
+	txn.WriteHeader(http.StatusConflict)
+	txn.End()
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "WebTransaction/Go/hello",
+		Msg:     "Conflict",
+		Klass:   "409",
+		Intrinsics: map[string]interface{}{
+			"error.expected": true,
+		},
+	}})
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"WebTransaction/Go/hello", "", true, nil},
+		{"WebTransaction", "", true, nil},
+		{"HttpDispatcher", "", true, nil},
+		{"Apdex", "", true, nil},
+		{"Apdex/Go/hello", "", false, nil},
+	})
+}
+
+type richError struct{ cause error }
+
+func (e richError) Error() string      { return "rich: " + e.cause.Error() }
+func (e richError) ErrorClass() string { return "RichError" }
+func (e richError) Unwrap() error      { return e.cause }
+func (e richError) ErrorAttributes() map[string]interface{} {
+	return map[string]interface{}{"zip": "zap"}
+}
+
+func TestNoticeErrorRich(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.AddAttribute("color", "red")
+	txn.NoticeError(richError{cause: myError{}})
+	txn.End()
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "OtherTransaction/Go/hello",
+		Msg:     "rich: my msg",
+		Klass:   "RichError",
+		UserAttributes: map[string]interface{}{
+			"color": "red",
+			"zip":   "zap",
+		},
+	}})
+}
+
+type wrapError struct{ cause error }
+
+func (e wrapError) Error() string { return "wrap: " + e.cause.Error() }
+func (e wrapError) Unwrap() error { return e.cause }
+
+func TestNoticeErrorRootCauseClass(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		cfg.ErrorCollector.ExpectedClasses = []string{"test.myError"}
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.NoticeError(wrapError{cause: myError{}})
+	txn.End()
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "OtherTransaction/Go/hello",
+		Msg:     "wrap: my msg",
+		Klass:   "test.myError",
+		Intrinsics: map[string]interface{}{
+			"error.expected": true,
+		},
+	}})
+}
+
+func TestAppNoticeError(t *testing.T) {
+	app := testApp(nil, nil, t)
+	err := app.NoticeError(richError{cause: myError{}}, map[string]interface{}{"color": "red"})
+	if nil != err {
+		t.Error(err)
+	}
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "OtherTransaction/Go/unknown",
+		Msg:     "rich: my msg",
+		Klass:   "RichError",
+		UserAttributes: map[string]interface{}{
+			"color": "red",
+			"zip":   "zap",
+		},
+	}})
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"Errors/all", "", true, []float64{1, 0, 0, 0, 0, 0, 0}},
+		{"Errors/allOther", "", true, []float64{1, 0, 0, 0, 0, 0, 0}},
+		{"Errors/OtherTransaction/Go/unknown", "", true, []float64{1, 0, 0, 0, 0, 0, 0}},
+	})
+}
+
+func TestAppNoticeErrorNil(t *testing.T) {
+	app := testApp(nil, nil, t)
+	if err := app.NoticeError(nil, nil); err != internal.ErrNilError {
+		t.Error(err)
+	}
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{})
+}
+
+func TestAppNoticeErrorInvalidAttribute(t *testing.T) {
+	app := testApp(nil, nil, t)
+	err := app.NoticeError(myError{}, map[string]interface{}{"invalid": struct{}{}})
+	if _, ok := err.(internal.ErrInvalidAttribute); !ok {
+		t.Error(err)
+	}
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{})
+}
+
+func TestAppNoticeErrorHighSecurity(t *testing.T) {
+	cfgfn := func(cfg *api.Config) { cfg.HighSecurity = true }
+	app := testApp(nil, cfgfn, t)
+	app.NoticeError(myError{}, nil)
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "OtherTransaction/Go/unknown",
+		Msg:     internal.HighSecurityErrorMsg,
+		Klass:   "test.myError",
+	}})
+}
+
+func TestAppNoticeErrorDisabled(t *testing.T) {
+	cfgfn := func(cfg *api.Config) { cfg.ErrorCollector.Enabled = false }
+	app := testApp(nil, cfgfn, t)
+	if err := app.NoticeError(myError{}, nil); err != internal.ErrorsLocallyDisabled {
+		t.Error(err)
+	}
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{})
+	app.ExpectMetrics(t, []internal.WantMetric{})
+
+	replyfn := func(reply *internal.ConnectReply) { reply.CollectErrors = false }
+	app = testApp(replyfn, nil, t)
+	if err := app.NoticeError(myError{}, nil); err != internal.ErrorsRemotelyDisabled {
+		t.Error(err)
+	}
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{})
+}
+
+func TestAppNoticeErrorExpected(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		cfg.ErrorCollector.ExpectedClasses = []string{"test.myError"}
+	}
+	app := testApp(nil, cfgfn, t)
+	app.NoticeError(myError{}, nil)
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "OtherTransaction/Go/unknown",
+		Msg:     "my msg",
+		Klass:   "test.myError",
+		Intrinsics: map[string]interface{}{
+			"error.expected": true,
+		},
+	}})
+	app.ExpectMetrics(t, []internal.WantMetric{})
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/internal"
+)
+
+func TestHarvestLimits(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		cfg.HarvestLimits.MaxTxnEvents = 1
+		cfg.HarvestLimits.MaxTxnErrors = 1
+	}
+	app := testApp(nil, cfgfn, t)
+	for i := 0; i < 2; i++ {
+		txn := app.StartTransaction("hello", nil, nil)
+		txn.NoticeError(myError{})
+		txn.NoticeError(myError{})
+		txn.End()
+	}
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name: "OtherTransaction/Go/hello",
+		Zone: "",
+	}})
+	// Only the first error of each transaction is recorded.
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "OtherTransaction/Go/hello",
+		Msg:     "my msg",
+		Klass:   "test.myError",
+	}, {
+		TxnName: "OtherTransaction/Go/hello",
+		Msg:     "my msg",
+		Klass:   "test.myError",
+	}})
+}
+
+func TestHarvestLimitsZero(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		cfg.HarvestLimits = api.Config{}.HarvestLimits
+	}
+	app := testApp(nil, cfgfn, t)
+	if err := app.RecordCustomEvent("myType", validParams); nil != err {
+		t.Error(err)
+	}
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.NoticeError(myError{})
+	txn.End()
+	// Zero limits use the defaults rather than discarding the data.
+	app.ExpectCustomEvents(t, []internal.WantCustomEvent{{
+		Type:   "myType",
+		Params: validParams,
+	}})
+	app.ExpectErrorEvents(t, []internal.WantErrorEvent{{
+		TxnName: "OtherTransaction/Go/hello",
+		Msg:     "my msg",
+		Klass:   "test.myError",
+	}})
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"errors"
 	"net/http"
+	"sync"
 	"testing"
 
 	"github.com/newrelic/go-agent"
@@ -525,3 +526,72 @@ func TestRoundTripper(t *testing.T) {
 		ExternalCallCount: 1,
 	}})
 }
+
+func TestNewGoroutineSegments(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("myName", nil, nil)
+	outer := txn.StartSegment()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(txn newrelic.Transaction) {
+			defer wg.Done()
+			token := txn.StartSegment()
+			txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+				Product:    datastore.MySQL,
+				Collection: "my_table",
+				Operation:  "SELECT",
+			})
+			txn.EndExternal(txn.StartSegment(), "http://example.com/")
+			txn.EndSegment(token, "async")
+		}(txn.NewGoroutine())
+	}
+	wg.Wait()
+
+	txn.EndSegment(outer, "outer")
+	txn.End()
+
+	scope := "OtherTransaction/Go/myName"
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"OtherTransaction/Go/myName", "", true, nil},
+		{"OtherTransaction/all", "", true, nil},
+		{"Custom/outer", "", false, nil},
+		{"Custom/outer", scope, false, nil},
+		{"Custom/async", "", false, nil},
+		{"Custom/async", scope, false, nil},
+		{"Datastore/all", "", true, nil},
+		{"Datastore/allOther", "", true, nil},
+		{"Datastore/MySQL/all", "", true, nil},
+		{"Datastore/MySQL/allOther", "", true, nil},
+		{"Datastore/operation/MySQL/SELECT", "", false, nil},
+		{"Datastore/statement/MySQL/my_table/SELECT", "", false, nil},
+		{"Datastore/statement/MySQL/my_table/SELECT", scope, false, nil},
+		{"External/all", "", true, nil},
+		{"External/allOther", "", true, nil},
+		{"External/example.com/all", "", false, nil},
+		{"External/example.com/all", scope, false, nil},
+	})
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name:               "OtherTransaction/Go/myName",
+		Zone:               "",
+		ExternalCallCount:  10,
+		DatastoreCallCount: 10,
+	}})
+}
+
+func TestNewGoroutineAfterEnd(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("myName", nil, nil)
+	async := txn.NewGoroutine()
+	token := async.StartSegment()
+	txn.End()
+	async.EndSegment(token, "async")
+	late := txn.NewGoroutine()
+	late.EndSegment(late.StartSegment(), "late")
+
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{"OtherTransaction/Go/myName", "", true, nil},
+		{"OtherTransaction/all", "", true, nil},
+	})
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/api/datastore"
+	"github.com/newrelic/go-agent/internal"
+)
+
+const (
+	slowQueryInput      = "SELECT * FROM users WHERE name = 'secret'"
+	slowQueryObfuscated = "SELECT * FROM users WHERE name = ?"
+)
+
+func enableAllSlowQueries(cfg *api.Config) {
+	cfg.DatastoreTracer.SlowQuery.Threshold = 0
+}
+
+func TestSlowQuery(t *testing.T) {
+	app := testApp(nil, enableAllSlowQueries, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	for i := 0; i < 2; i++ {
+		txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+			Product: datastore.MySQL,
+			Query:   slowQueryInput,
+		})
+	}
+	txn.End()
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:      2,
+		MetricName: "Datastore/statement/MySQL/users/select",
+		Query:      slowQueryObfuscated,
+		TxnName:    "WebTransaction/Go/hello",
+		TxnURL:     "/hello",
+	}})
+}
+
+func TestSlowQueryBelowThreshold(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   slowQueryInput,
+	})
+	txn.End()
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{})
+}
+
+func TestSlowQueryAboveThreshold(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		cfg.DatastoreTracer.SlowQuery.Threshold = 1 * time.Millisecond
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	token := txn.StartSegment()
+	time.Sleep(5 * time.Millisecond)
+	txn.EndDatastore(token, datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   slowQueryInput,
+	})
+	txn.End()
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:      1,
+		MetricName: "Datastore/statement/MySQL/users/select",
+		Query:      slowQueryObfuscated,
+		TxnName:    "OtherTransaction/Go/hello",
+		TxnURL:     "",
+	}})
+}
+
+func TestSlowQueryLocallyDisabled(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		enableAllSlowQueries(cfg)
+		cfg.DatastoreTracer.SlowQuery.Enabled = false
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   slowQueryInput,
+	})
+	txn.End()
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{})
+}
+
+func TestSlowQueryRemotelyDisabled(t *testing.T) {
+	replyfn := func(reply *internal.ConnectReply) {
+		reply.CollectTraces = false
+	}
+	app := testApp(replyfn, enableAllSlowQueries, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   slowQueryInput,
+	})
+	txn.End()
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{})
+}
+
+func TestSlowQueryHighSecurity(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		enableAllSlowQueries(cfg)
+		cfg.HighSecurity = true
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   slowQueryInput,
+	})
+	txn.End()
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:      1,
+		MetricName: "Datastore/statement/MySQL/users/select",
+		Query:      slowQueryObfuscated,
+		TxnName:    "WebTransaction/Go/hello",
+		TxnURL:     "/hello",
+	}})
+}
+
+func TestSlowQueryNewGoroutine(t *testing.T) {
+	app := testApp(nil, enableAllSlowQueries, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	async := txn.NewGoroutine()
+	async.EndDatastore(async.StartSegment(), datastore.Segment{
+		Product: datastore.Postgres,
+		Query:   "INSERT INTO users (name) VALUES ($1)",
+	})
+	txn.End()
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:      1,
+		MetricName: "Datastore/statement/Postgres/users/insert",
+		Query:      "INSERT INTO users (name) VALUES ($1)",
+		TxnName:    "OtherTransaction/Go/hello",
+		TxnURL:     "",
+	}})
+}
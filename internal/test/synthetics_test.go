@@ -0,0 +1,87 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/internal"
+)
+
+var syntheticsHeader = catObfuscate(`[1,1,"resource","job","monitor"]`)
+
+func syntheticsRequest(header string) *http.Request {
+	req, _ := http.NewRequest("GET", "http://example.com/hello", nil)
+	req.Header.Set("X-NewRelic-Synthetics", header)
+	return req
+}
+
+func TestSyntheticsRequest(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		// Synthetics does not depend on cross application tracing.
+		cfg.CrossApplicationTracer.Enabled = false
+	}
+	app := testApp(catReply, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, syntheticsRequest(syntheticsHeader))
+	out, _ := http.NewRequest("GET", "http://example.com/zip", nil)
+	txn.PrepareRequest(txn.StartSegment(), out)
+	txn.End()
+
+	if h := out.Header.Get("X-NewRelic-Synthetics"); h != syntheticsHeader {
+		t.Error(h)
+	}
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name: "WebTransaction/Go/hello",
+		Zone: "S",
+		Intrinsics: map[string]interface{}{
+			"nr.guid":                 nil,
+			"nr.syntheticsResourceId": "resource",
+			"nr.syntheticsJobId":      "job",
+			"nr.syntheticsMonitorId":  "monitor",
+		},
+	}})
+	// The trace is saved even though the transaction is faster than the
+	// threshold.
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{{
+		MetricName:  "WebTransaction/Go/hello",
+		CleanURL:    "http://example.com/hello",
+		NumSegments: 0,
+	}})
+}
+
+func TestSyntheticsRequestWithCAT(t *testing.T) {
+	app := testApp(catReply, nil, t)
+	req := catInboundRequest("1")
+	req.Header.Set("X-NewRelic-Synthetics", syntheticsHeader)
+	txn := app.StartTransaction("hello", nil, req)
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantTxnEvent{{
+		Name:         "WebTransaction/Go/hello",
+		Zone:         "S",
+		CrossProcess: true,
+		Intrinsics: map[string]interface{}{
+			"nr.syntheticsResourceId": "resource",
+		},
+	}})
+}
+
+func TestSyntheticsRequestInvalid(t *testing.T) {
+	for _, header := range []string{
+		catObfuscate(`[2,1,"resource","job","monitor"]`),
+		catObfuscate(`[1,2,"resource","job","monitor"]`),
+		catObfuscate(`[1,1,"resource","job"]`),
+		"garbage",
+	} {
+		app := testApp(catReply, nil, t)
+		txn := app.StartTransaction("hello", nil, syntheticsRequest(header))
+		out, _ := http.NewRequest("GET", "http://example.com/zip", nil)
+		txn.PrepareRequest(txn.StartSegment(), out)
+		txn.End()
+
+		if h := out.Header.Get("X-NewRelic-Synthetics"); "" != h {
+			t.Error(h)
+		}
+		app.ExpectTxnTraces(t, []internal.WantTxnTrace{})
+	}
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/api/datastore"
+	"github.com/newrelic/go-agent/internal"
+)
+
+// traceAllTransactions lowers the trace thresholds so that every transaction
+// and segment is traced.
+func traceAllTransactions(cfg *api.Config) {
+	cfg.TransactionTracer.Threshold.IsApdexFailing = false
+	cfg.TransactionTracer.Threshold.Duration = 0
+	cfg.TransactionTracer.SegmentThreshold = 0
+}
+
+func TestTxnTrace(t *testing.T) {
+	app := testApp(nil, traceAllTransactions, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.AddAttribute("zip", "zap")
+	txn.EndSegment(txn.StartSegment(), "segment")
+	txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+		Product:    datastore.MySQL,
+		Collection: "users",
+		Operation:  "SELECT",
+	})
+	txn.EndExternal(txn.StartSegment(), "http://example.com/zip/zap?secret=shh")
+	txn.End()
+
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{{
+		MetricName:      "WebTransaction/Go/hello",
+		CleanURL:        "/hello",
+		NumSegments:     3,
+		UserAttributes:  map[string]interface{}{"zip": "zap"},
+		AgentAttributes: map[string]interface{}{
+			"request.method":                "GET",
+			"request.headers.accept":        "text/plain",
+			"request.headers.contentType":   "text/html; charset=utf-8",
+			"request.headers.contentLength": 753,
+			"request.headers.host":          "my_domain.com",
+			"request.headers.User-Agent":    "Mozilla/5.0",
+			"request.headers.referer":       "http://en.wikipedia.org/zip",
+		},
+	}})
+}
+
+func TestTxnTraceBelowThreshold(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.End()
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{})
+}
+
+func TestTxnTraceLocallyDisabled(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		traceAllTransactions(cfg)
+		cfg.TransactionTracer.Enabled = false
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.End()
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{})
+}
+
+func TestTxnTraceRemotelyDisabled(t *testing.T) {
+	replyfn := func(reply *internal.ConnectReply) {
+		reply.CollectTraces = false
+	}
+	app := testApp(replyfn, traceAllTransactions, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	txn.End()
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{})
+}
+
+func TestTxnTraceApdexThreshold(t *testing.T) {
+	replyfn := func(reply *internal.ConnectReply) {
+		reply.ApdexThresholdSeconds = 0.001
+	}
+	app := testApp(replyfn, nil, t)
+	txn := app.StartTransaction("hello", nil, helloRequest)
+	time.Sleep(10 * time.Millisecond)
+	txn.End()
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{{
+		MetricName:  "WebTransaction/Go/hello",
+		CleanURL:    "/hello",
+		NumSegments: 0,
+	}})
+}
+
+func TestTxnTraceSegmentThreshold(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		traceAllTransactions(cfg)
+		cfg.TransactionTracer.SegmentThreshold = 1 * time.Hour
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.EndSegment(txn.StartSegment(), "segment")
+	txn.End()
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{{
+		MetricName:  "OtherTransaction/Go/hello",
+		NumSegments: 0,
+	}})
+}
+
+func TestTxnTraceMaxSegments(t *testing.T) {
+	cfgfn := func(cfg *api.Config) {
+		traceAllTransactions(cfg)
+		cfg.TransactionTracer.MaxSegments = 2
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	for i := 0; i < 5; i++ {
+		txn.EndSegment(txn.StartSegment(), "segment")
+	}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	async := txn.NewGoroutine()
+	async.EndRequest(async.StartSegment(), req, nil)
+	txn.End()
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{{
+		MetricName:  "OtherTransaction/Go/hello",
+		NumSegments: 2,
+	}})
+}
+
+func TestTxnTraceNewGoroutine(t *testing.T) {
+	app := testApp(nil, traceAllTransactions, t)
+	txn := app.StartTransaction("hello", nil, nil)
+	async := txn.NewGoroutine()
+	txn.EndSegment(txn.StartSegment(), "segment")
+	async.EndSegment(async.StartSegment(), "async")
+	txn.End()
+	app.ExpectTxnTraces(t, []internal.WantTxnTrace{{
+		MetricName:  "OtherTransaction/Go/hello",
+		NumSegments: 2,
+	}})
+}
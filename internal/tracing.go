@@ -32,6 +32,8 @@ type segmentFrame struct {
 	stamp    uint64
 	start    time.Time
 	children time.Duration
+	// spanID is only created when distributed tracing is enabled.
+	spanID string
 }
 
 type datastoreMetricKey struct {
@@ -59,6 +61,21 @@ type tracer struct {
 	currentDepth     int
 	stack            []segmentFrame
 
+	txnTrace
+
+	// Slow query settings and data.  slowQueries is lazily initialized.
+	slowQueriesEnabled bool
+	slowQueryThreshold time.Duration
+	slowQueries        *slowQueries
+
+	// Distributed tracing settings and data.  Segment span ids are
+	// created if distributedTracing is true, and the segments at the
+	// bottom of the stack are children of the root span.
+	distributedTracing bool
+	rootSpanID         string
+	spanEventsEnabled  bool
+	spanEvents         []*spanEvent
+
 	customSegments    map[string]*metricData
 	datastoreSegments map[datastoreMetricKey]*metricData
 	externalSegments  map[externalMetricKey]*metricData
@@ -95,16 +112,37 @@ func startSegment(t *tracer, now time.Time) api.Token {
 	t.stack[idx].start = now
 	t.stack[idx].children = 0
 	t.stack[idx].stamp = stamp
+	if t.distributedTracing {
+		t.stack[idx].spanID = newSpanID()
+	} else {
+		t.stack[idx].spanID = ""
+	}
 
 	return createToken(idx, stamp)
 }
 
+// spanIDForToken returns the span id of the segment started with the token.
+// If the token is not valid, the root span id is returned.
+func spanIDForToken(t *tracer, token api.Token) string {
+	depth, stamp := parseToken(token)
+	if 0 == stamp || depth < 0 || depth >= t.currentDepth || stamp != t.stack[depth].stamp {
+		return t.rootSpanID
+	}
+	return t.stack[depth].spanID
+}
+
 type segmentEnd struct {
-	valid     bool
-	start     time.Time
-	stop      time.Time
-	duration  time.Duration
-	exclusive time.Duration
+	valid      bool
+	start      time.Time
+	stop       time.Time
+	startStamp uint64
+	stopStamp  uint64
+	duration   time.Duration
+	exclusive  time.Duration
+	// spanID and parentSpanID are empty unless distributed tracing is
+	// enabled.
+	spanID       string
+	parentSpanID string
 }
 
 func endSegment(t *tracer, token api.Token, now time.Time) segmentEnd {
@@ -127,9 +165,22 @@ func endSegment(t *tracer, token api.Token, now time.Time) segmentEnd {
 	for i := depth; i < t.currentDepth; i++ {
 		children += t.stack[i].children
 	}
+	// Stamp the segment's stop so that transaction trace nodes can be
+	// nested: The children of a node start after its start stamp and
+	// before its stop stamp.
+	t.stamp++
+
 	s.valid = true
 	s.stop = now
 	s.start = t.stack[depth].start
+	s.startStamp = stamp
+	s.stopStamp = t.stamp
+	s.spanID = t.stack[depth].spanID
+	if depth > 0 {
+		s.parentSpanID = t.stack[depth-1].spanID
+	} else {
+		s.parentSpanID = t.rootSpanID
+	}
 	if s.stop.After(s.start) {
 		s.duration = s.stop.Sub(s.start)
 	}
@@ -150,11 +201,34 @@ func endSegment(t *tracer, token api.Token, now time.Time) segmentEnd {
 	return s
 }
 
+func (t *tracer) considerSpanEvent() bool {
+	return t.spanEventsEnabled && len(t.spanEvents) < maxTxnSpanEvents
+}
+
+// witnessSpanEvent completes the span event of a segment.  The trace and
+// transaction fields are assigned when the transaction ends.
+func (t *tracer) witnessSpanEvent(end segmentEnd, e *spanEvent) {
+	e.guid = end.spanID
+	e.parentID = end.parentSpanID
+	e.timestamp = end.start
+	e.duration = end.duration
+	t.spanEvents = append(t.spanEvents, e)
+}
+
 func endBasicSegment(t *tracer, token api.Token, now time.Time, name string) {
 	end := endSegment(t, token, now)
 	if !end.valid {
 		return
 	}
+	if t.considerNode(end) {
+		t.witnessNode(end, customSegmentPrefix+name, traceNodeParams{})
+	}
+	if t.considerSpanEvent() {
+		t.witnessSpanEvent(end, &spanEvent{
+			name:     customSegmentPrefix + name,
+			category: spanCategoryGeneric,
+		})
+	}
 	if nil == t.customSegments {
 		t.customSegments = make(map[string]*metricData)
 	}
@@ -170,18 +244,38 @@ func endBasicSegment(t *tracer, token api.Token, now time.Time, name string) {
 	}
 }
 
-func endExternalSegment(t *tracer, token api.Token, now time.Time, host string) {
+// endExternalSegment ends an external segment.  The key's cross process
+// fields are populated if the response contained valid cross application
+// tracing headers.
+func endExternalSegment(t *tracer, token api.Token, now time.Time, key externalMetricKey, cleanURL string) {
 	end := endSegment(t, token, now)
 	if !end.valid {
 		return
 	}
-	if "" == host {
-		host = "unknown"
+	if "" == key.Host {
+		key.Host = "unknown"
 	}
-	key := externalMetricKey{
-		Host: host,
-		ExternalCrossProcessID:  "",
-		ExternalTransactionName: "",
+	considerNode := t.considerNode(end)
+	considerSpanEvent := t.considerSpanEvent()
+	if considerNode || considerSpanEvent {
+		name := externalHostMetric(key)
+		if "" != key.ExternalCrossProcessID && "" != key.ExternalTransactionName {
+			name = externalTransactionMetric(key)
+		}
+		if considerNode {
+			t.witnessNode(end, name, traceNodeParams{
+				CleanURL: cleanURL,
+			})
+		}
+		if considerSpanEvent {
+			t.witnessSpanEvent(end, &spanEvent{
+				name:      name,
+				category:  spanCategoryHTTP,
+				component: "http",
+				kind:      "client",
+				httpURL:   cleanURL,
+			})
+		}
 	}
 	if nil == t.externalSegments {
 		t.externalSegments = make(map[externalMetricKey]*metricData)
@@ -210,12 +304,62 @@ func endDatastoreSegment(t *tracer, token api.Token, now time.Time, s datastore.
 		Collection: s.Collection,
 		Operation:  s.Operation,
 	}
+	if "" != s.Query && ("" == key.Operation || "" == key.Collection) {
+		operation, table := parseSQL(s.Query)
+		if "" == key.Operation {
+			key.Operation = operation
+		}
+		if "" == key.Collection {
+			key.Collection = table
+		}
+	}
 	if key.Operation == "" {
 		key.Operation = "other"
 	}
 	if key.Product == "" {
 		key.Product = datastoreProductUnknown
 	}
+	considerNode := t.considerNode(end)
+	considerSpanEvent := t.considerSpanEvent()
+	slowQuery := "" != s.Query && t.slowQueriesEnabled &&
+		end.duration >= t.slowQueryThreshold
+	if considerNode || considerSpanEvent || slowQuery {
+		name := datastoreOperationMetric(key)
+		if "" != key.Collection {
+			name = datastoreStatementMetric(key)
+		}
+		var query string
+		if "" != s.Query {
+			query = obfuscateSQL(s.Query, sqlDialectForProduct(key.Product))
+		}
+		if considerNode {
+			t.witnessNode(end, name, traceNodeParams{Query: query})
+		}
+		if considerSpanEvent {
+			t.witnessSpanEvent(end, &spanEvent{
+				name:         name,
+				category:     spanCategoryDatastore,
+				component:    string(key.Product),
+				kind:         "client",
+				dbStatement:  query,
+				dbCollection: key.Collection,
+			})
+		}
+		if slowQuery {
+			if nil == t.slowQueries {
+				t.slowQueries = newSlowQueries(maxTxnSlowQueries)
+			}
+			t.slowQueries.observeInstance(slowQueryInstance{
+				duration:   end.duration,
+				metricName: name,
+				query:      query,
+				id:         makeSlowQueryID(query),
+				// Skip this function and the two Transaction
+				// methods which call it.
+				stack: getStackTrace(3),
+			})
+		}
+	}
 	if nil == t.datastoreSegments {
 		t.datastoreSegments = make(map[datastoreMetricKey]*metricData)
 	}
@@ -233,6 +377,85 @@ func endDatastoreSegment(t *tracer, token api.Token, now time.Time, s datastore.
 	}
 }
 
+// mergeTracer adds the segment data of a tracer used in another goroutine to a
+// transaction's tracer.  Since segments in other goroutines run concurrently
+// with the transaction's goroutine, the src tracer's stack and finished
+// children are not merged:  They do not affect exclusive time.
+func mergeTracer(dst *tracer, src *tracer) {
+	// Trace node stamps are offset so that the src nodes do not nest
+	// within the dst nodes.
+	for _, n := range src.nodes {
+		if len(dst.nodes) >= dst.maxNodes {
+			break
+		}
+		n.start.stamp += dst.stamp
+		n.stop.stamp += dst.stamp
+		dst.nodes = append(dst.nodes, n)
+	}
+	dst.stamp += src.stamp
+
+	for _, e := range src.spanEvents {
+		if len(dst.spanEvents) >= maxTxnSpanEvents {
+			break
+		}
+		dst.spanEvents = append(dst.spanEvents, e)
+	}
+
+	if nil != src.slowQueries {
+		if nil == dst.slowQueries {
+			dst.slowQueries = newSlowQueries(maxTxnSlowQueries)
+		}
+		dst.slowQueries.merge(src.slowQueries, "", "")
+	}
+
+	if nil != src.customSegments {
+		if nil == dst.customSegments {
+			dst.customSegments = make(map[string]*metricData)
+		}
+		for key, data := range src.customSegments {
+			if d, ok := dst.customSegments[key]; ok {
+				d.aggregate(*data)
+			} else {
+				cpy := new(metricData)
+				*cpy = *data
+				dst.customSegments[key] = cpy
+			}
+		}
+	}
+	if nil != src.datastoreSegments {
+		if nil == dst.datastoreSegments {
+			dst.datastoreSegments = make(map[datastoreMetricKey]*metricData)
+		}
+		for key, data := range src.datastoreSegments {
+			if d, ok := dst.datastoreSegments[key]; ok {
+				d.aggregate(*data)
+			} else {
+				cpy := new(metricData)
+				*cpy = *data
+				dst.datastoreSegments[key] = cpy
+			}
+		}
+	}
+	if nil != src.externalSegments {
+		if nil == dst.externalSegments {
+			dst.externalSegments = make(map[externalMetricKey]*metricData)
+		}
+		for key, data := range src.externalSegments {
+			if d, ok := dst.externalSegments[key]; ok {
+				d.aggregate(*data)
+			} else {
+				cpy := new(metricData)
+				*cpy = *data
+				dst.externalSegments[key] = cpy
+			}
+		}
+	}
+	dst.externalCallCount += src.externalCallCount
+	dst.externalDuration += src.externalDuration
+	dst.datastoreCallCount += src.datastoreCallCount
+	dst.datastoreDuration += src.datastoreDuration
+}
+
 func mergeBreakdownMetrics(t *tracer, metrics *metricTable, scope string, isWeb bool) {
 	// Custom Segment Metrics
 	for key, data := range t.customSegments {
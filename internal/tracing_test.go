@@ -300,12 +300,12 @@ func TestSegmentExternal(t *testing.T) {
 
 	t1 := startSegment(tr, start.Add(1*time.Second))
 	t2 := startSegment(tr, start.Add(2*time.Second))
-	endExternalSegment(tr, t2, start.Add(3*time.Second), "")
-	endExternalSegment(tr, t1, start.Add(4*time.Second), "f1.com")
+	endExternalSegment(tr, t2, start.Add(3*time.Second), externalMetricKey{}, "")
+	endExternalSegment(tr, t1, start.Add(4*time.Second), externalMetricKey{Host: "f1.com"}, "")
 	t3 := startSegment(tr, start.Add(5*time.Second))
-	endExternalSegment(tr, t3, start.Add(6*time.Second), "f1.com")
+	endExternalSegment(tr, t3, start.Add(6*time.Second), externalMetricKey{Host: "f1.com"}, "")
 	t4 := startSegment(tr, start.Add(7*time.Second))
-	endExternalSegment(tr, t4+1, start.Add(8*time.Second), "invalid-token.com")
+	endExternalSegment(tr, t4+1, start.Add(8*time.Second), externalMetricKey{Host: "invalid-token.com"}, "")
 
 	if tr.externalCallCount != 3 {
 		t.Error(tr.externalCallCount)
@@ -410,3 +410,96 @@ func TestSegmentDatastore(t *testing.T) {
 		{"Datastore/statement/MySQL/my_table/SELECT", scope, false, []float64{1, 1, 1, 1, 1, 1}},
 	})
 }
+
+func TestSegmentDatastoreQueryParsing(t *testing.T) {
+	start = time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	tr := &tracer{}
+
+	t1 := startSegment(tr, start.Add(1*time.Second))
+	endDatastoreSegment(tr, t1, start.Add(2*time.Second), datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   "SELECT * FROM users WHERE id = 1",
+	})
+	t2 := startSegment(tr, start.Add(3*time.Second))
+	endDatastoreSegment(tr, t2, start.Add(4*time.Second), datastore.Segment{
+		Product:    datastore.MySQL,
+		Collection: "accounts",
+		Operation:  "INSERT",
+		Query:      "SELECT * FROM users WHERE id = 1",
+	})
+	t3 := startSegment(tr, start.Add(5*time.Second))
+	endDatastoreSegment(tr, t3, start.Add(6*time.Second), datastore.Segment{
+		Product: datastore.Redis,
+		Query:   "GET mykey",
+	})
+
+	metrics := newMetricTable(100, time.Now())
+	scope := "WebTransaction/Go/zip"
+	mergeBreakdownMetrics(tr, metrics, scope, true)
+	expectMetrics(t, metrics, []WantMetric{
+		{"Datastore/all", "", true, []float64{3, 3, 3, 1, 1, 3}},
+		{"Datastore/allWeb", "", true, []float64{3, 3, 3, 1, 1, 3}},
+		{"Datastore/MySQL/all", "", true, []float64{2, 2, 2, 1, 1, 2}},
+		{"Datastore/MySQL/allWeb", "", true, []float64{2, 2, 2, 1, 1, 2}},
+		{"Datastore/Redis/all", "", true, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/Redis/allWeb", "", true, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/operation/MySQL/select", "", false, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/operation/MySQL/INSERT", "", false, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/operation/Redis/other", "", false, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/operation/Redis/other", scope, false, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/statement/MySQL/users/select", "", false, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/statement/MySQL/users/select", scope, false, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/statement/MySQL/accounts/INSERT", "", false, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/statement/MySQL/accounts/INSERT", scope, false, []float64{1, 1, 1, 1, 1, 1}},
+	})
+}
+
+func TestMergeTracer(t *testing.T) {
+	start = time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	tr := &tracer{}
+	async := &tracer{}
+
+	t1 := startSegment(tr, start.Add(1*time.Second))
+	a1 := startSegment(async, start.Add(1*time.Second))
+	a2 := startSegment(async, start.Add(2*time.Second))
+	endDatastoreSegment(async, a2, start.Add(3*time.Second), datastore.Segment{
+		Product:   datastore.MySQL,
+		Operation: "SELECT",
+	})
+	endBasicSegment(async, a1, start.Add(4*time.Second), "t1")
+	a3 := startSegment(async, start.Add(5*time.Second))
+	endExternalSegment(async, a3, start.Add(7*time.Second), externalMetricKey{Host: "f1.com"}, "")
+	endBasicSegment(tr, t1, start.Add(4*time.Second), "t1")
+
+	mergeTracer(tr, async)
+
+	// The async segments run concurrently and must not affect the
+	// transaction's exclusive time.
+	if children := tracerRootChildren(tr); children != 3*time.Second {
+		t.Error(children)
+	}
+	if tr.datastoreCallCount != 1 || tr.datastoreDuration != 1*time.Second {
+		t.Error(tr.datastoreCallCount, tr.datastoreDuration)
+	}
+	if tr.externalCallCount != 1 || tr.externalDuration != 2*time.Second {
+		t.Error(tr.externalCallCount, tr.externalDuration)
+	}
+
+	metrics := newMetricTable(100, time.Now())
+	scope := "WebTransaction/Go/zip"
+	mergeBreakdownMetrics(tr, metrics, scope, true)
+	expectMetrics(t, metrics, []WantMetric{
+		{"Custom/t1", "", false, []float64{2, 6, 5, 3, 3, 18}},
+		{"Custom/t1", scope, false, []float64{2, 6, 5, 3, 3, 18}},
+		{"Datastore/all", "", true, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/allWeb", "", true, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/MySQL/all", "", true, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/MySQL/allWeb", "", true, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/operation/MySQL/SELECT", "", false, []float64{1, 1, 1, 1, 1, 1}},
+		{"Datastore/operation/MySQL/SELECT", scope, false, []float64{1, 1, 1, 1, 1, 1}},
+		{"External/all", "", true, []float64{1, 2, 2, 2, 2, 4}},
+		{"External/allWeb", "", true, []float64{1, 2, 2, 2, 2, 4}},
+		{"External/f1.com/all", "", false, []float64{1, 2, 2, 2, 2, 4}},
+		{"External/f1.com/all", scope, false, []float64{1, 2, 2, 2, 2, 4}},
+	})
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"errors"
+	"html/template"
 	"net/http"
 	"strconv"
 	"sync"
@@ -13,11 +14,14 @@ import (
 )
 
 type txnInput struct {
-	W          http.ResponseWriter
-	Request    *http.Request
-	Config     api.Config
-	Reply      *ConnectReply
-	Consumer   dataConsumer
+	W        http.ResponseWriter
+	Request  *http.Request
+	Config   api.Config
+	Reply    *ConnectReply
+	Consumer dataConsumer
+	// Sampler decides which transactions starting a distributed trace
+	// are sampled.
+	Sampler    *adaptiveSampler
 	attrConfig *attributeConfig
 }
 
@@ -37,9 +41,22 @@ type txn struct {
 	errors     txnErrors // Lazily initialized.
 	errorsSeen uint64
 	attrs      *attributes
+	cross      txnCrossProcess
+	// synthetics is nil unless the request contains a valid synthetics
+	// header.
+	synthetics *syntheticsInfo
+	// dt is only populated if distributed tracing is enabled.
+	dt txnDistributedTrace
 
 	// Fields relating to tracing and breakdown metrics/segments.
 	tracer tracer
+	// asyncTracers are used by the Transactions returned by NewGoroutine.
+	// They are merged into tracer when the transaction ends.
+	asyncTracers []*tracer
+
+	// browserHeaderCreated indicates whether BrowserTimingHeader has
+	// returned the header, which is required for the footer.
+	browserHeaderCreated bool
 
 	// wroteHeader prevents capturing multiple response code errors if the
 	// user erroneously calls WriteHeader multiple times.
@@ -51,6 +68,7 @@ type txn struct {
 	finalName      string // Full finalized metric name
 	zone           apdexZone
 	apdexThreshold time.Duration
+	catIntrinsics  *catIntrinsics
 }
 
 func newTxn(input txnInput, name string) *txn {
@@ -77,18 +95,119 @@ func newTxn(input txnInput, name string) *txn {
 		}
 
 		txn.queuing = queueDuration(h, txn.start)
+
+		if txn.catEnabled() {
+			txn.handleInboundCAT(h)
+		}
+		txn.handleInboundSynthetics(h)
+	}
+
+	if txn.distributedTracingEnabled() {
+		var h http.Header
+		if nil != txn.Request {
+			h = txn.Request.Header
+		}
+		txn.dt = newTxnDistributedTrace(txn.Reply, h, txn.Sampler)
 	}
 
 	txn.attrs.agent.HostDisplayName = txn.Config.HostDisplayName
+	txn.initTracer(&txn.tracer)
 
 	return txn
 }
 
+// initTracer assigns the settings used by each of the transaction's tracers.
+func (txn *txn) initTracer(t *tracer) {
+	t.txnTrace = txnTrace{
+		enabled:          txn.txnTracesEnabled(),
+		segmentThreshold: txn.Config.TransactionTracer.SegmentThreshold,
+		maxNodes:         txn.Config.TransactionTracer.MaxSegments,
+	}
+	t.slowQueriesEnabled = txn.slowQueriesEnabled()
+	t.slowQueryThreshold = txn.Config.DatastoreTracer.SlowQuery.Threshold
+	t.distributedTracing = txn.distributedTracingEnabled()
+	t.rootSpanID = txn.dt.rootSpanID
+	t.spanEventsEnabled = txn.spanEventsEnabled()
+}
+
+func (txn *txn) distributedTracingEnabled() bool {
+	return txn.Config.DistributedTracer.Enabled
+}
+
+// spanEventsEnabled indicates whether span events are recorded.  They are
+// only recorded for sampled traces.
+func (txn *txn) spanEventsEnabled() bool {
+	return txn.distributedTracingEnabled() &&
+		txn.Config.SpanEvents.Enabled &&
+		txn.Reply.CollectSpanEvents &&
+		txn.dt.sampled
+}
+
+// catEnabled indicates whether cross application tracing headers are read
+// and written.  Both the encoding key and cross process id are required.
+// Distributed tracing replaces cross application tracing.
+func (txn *txn) catEnabled() bool {
+	return txn.Config.CrossApplicationTracer.Enabled &&
+		!txn.distributedTracingEnabled() &&
+		"" != txn.Reply.EncodingKey &&
+		"" != txn.Reply.CrossProcessID
+}
+
+func (txn *txn) handleInboundCAT(h http.Header) {
+	id := h.Get(catIDHeader)
+	payload := h.Get(catTransactionHeader)
+	if "" == id || "" == payload {
+		return
+	}
+	if err := txn.cross.handleInbound(txn.Reply, id, payload); nil != err {
+		log.Debug("unable to process inbound cross application tracing headers", log.Context{
+			"err": err.Error(),
+		})
+	}
+}
+
+func (txn *txn) handleInboundSynthetics(h http.Header) {
+	header := h.Get(syntheticsHeader)
+	if "" == header {
+		return
+	}
+	info, err := parseSyntheticsHeader(txn.Reply, header)
+	if nil != err {
+		log.Debug("unable to process inbound synthetics header", log.Context{
+			"err": err.Error(),
+		})
+		return
+	}
+	txn.synthetics = info
+}
+
 func (txn *txn) txnEventsEnabled() bool {
 	return txn.Config.TransactionEvents.Enabled &&
 		txn.Reply.CollectAnalyticsEvents
 }
 
+func (txn *txn) txnTracesEnabled() bool {
+	return txn.Config.TransactionTracer.Enabled &&
+		txn.Reply.CollectTraces
+}
+
+func (txn *txn) slowQueriesEnabled() bool {
+	return txn.Config.DatastoreTracer.SlowQuery.Enabled &&
+		txn.Reply.CollectTraces
+}
+
+func (txn *txn) txnTraceThreshold() time.Duration {
+	if txn.Config.TransactionTracer.Threshold.IsApdexFailing {
+		return apdexFailingThreshold(calculateApdexThreshold(txn.Reply, txn.finalName))
+	}
+	return txn.Config.TransactionTracer.Threshold.Duration
+}
+
+func (txn *txn) shouldSaveTrace() bool {
+	return txn.txnTracesEnabled() &&
+		(nil != txn.synthetics || txn.duration >= txn.txnTraceThreshold())
+}
+
 func (txn *txn) errorEventsEnabled() bool {
 	return txn.Config.ErrorCollector.CaptureEvents &&
 		txn.Reply.CollectErrorEvents
@@ -117,6 +236,9 @@ type createTxnMetricsArgs struct {
 	zone           apdexZone
 	apdexThreshold time.Duration
 	errorsSeen     uint64
+	// clientCrossProcessID is the cross process id of the application
+	// which made a trusted cross application request.
+	clientCrossProcessID string
 }
 
 func createTxnMetrics(args createTxnMetricsArgs, metrics *metricTable) {
@@ -130,6 +252,10 @@ func createTxnMetrics(args createTxnMetricsArgs, metrics *metricTable) {
 	metrics.addDuration(args.name, "", args.duration, args.exclusive, forced)
 	metrics.addDuration(rollup, "", args.duration, args.exclusive, forced)
 
+	if "" != args.clientCrossProcessID {
+		metrics.addDuration(clientApplicationMetric(args.clientCrossProcessID), "", args.duration, args.duration, unforced)
+	}
+
 	// Apdex Metrics
 	if args.zone != apdexNone {
 		metrics.addApdex(apdexRollup, "", args.apdexThreshold, args.zone, forced)
@@ -158,13 +284,14 @@ func (txn *txn) mergeIntoHarvest(h *harvest) {
 	}
 
 	createTxnMetrics(createTxnMetricsArgs{
-		isWeb:          txn.isWeb,
-		duration:       txn.duration,
-		exclusive:      exclusive,
-		name:           txn.finalName,
-		zone:           txn.zone,
-		apdexThreshold: txn.apdexThreshold,
-		errorsSeen:     txn.errorsSeen,
+		isWeb:                txn.isWeb,
+		duration:             txn.duration,
+		exclusive:            exclusive,
+		name:                 txn.finalName,
+		zone:                 txn.zone,
+		apdexThreshold:       txn.apdexThreshold,
+		errorsSeen:           txn.errorsSeen,
+		clientCrossProcessID: txn.cross.clientCrossProcessID,
 	}, h.metrics)
 
 	if txn.queuing > 0 {
@@ -173,14 +300,26 @@ func (txn *txn) mergeIntoHarvest(h *harvest) {
 
 	mergeBreakdownMetrics(&txn.tracer, h.metrics, txn.finalName, txn.isWeb)
 
+	var dt *txnDistributedTrace
+	if txn.distributedTracingEnabled() {
+		dt = &txn.dt
+	}
+
+	if txn.spanEventsEnabled() {
+		txn.mergeSpanEvents(h.spanEvents)
+	}
+
 	if txn.txnEventsEnabled() {
 		h.txnEvents.AddTxnEvent(&txnEvent{
-			Name:      txn.finalName,
-			Timestamp: txn.start,
-			Duration:  txn.duration,
-			queuing:   txn.queuing,
-			zone:      txn.zone,
-			attrs:     txn.attrs,
+			Name:                    txn.finalName,
+			Timestamp:               txn.start,
+			Duration:                txn.duration,
+			queuing:                 txn.queuing,
+			zone:                    txn.zone,
+			attrs:                   txn.attrs,
+			cat:                     txn.catIntrinsics,
+			synthetics:              txn.synthetics,
+			dt:                      dt,
 			datastoreExternalTotals: txn.tracer.datastoreExternalTotals,
 		})
 	}
@@ -190,24 +329,66 @@ func (txn *txn) mergeIntoHarvest(h *harvest) {
 		requestURI = safeURL(txn.Request.URL)
 	}
 
+	if txn.shouldSaveTrace() {
+		h.txnTraces.Witness(harvestTrace{
+			start:      txn.start,
+			duration:   txn.duration,
+			finalName:  txn.finalName,
+			cleanURL:   requestURI,
+			trace:      txn.tracer.txnTrace,
+			attrs:      txn.attrs,
+			cat:        txn.catIntrinsics,
+			synthetics: txn.synthetics,
+		})
+	}
+
+	if nil != txn.tracer.slowQueries {
+		h.slowSQLs.merge(txn.tracer.slowQueries, txn.finalName, requestURI)
+	}
+
 	mergeTxnErrors(h.errorTraces, txn.errors, txn.finalName, requestURI, txn.attrs)
 
 	if txn.errorEventsEnabled() {
 		for _, e := range txn.errors {
 			h.errorEvents.Add(&errorEvent{
-				klass:    e.klass,
-				msg:      e.msg,
-				when:     e.when,
-				txnName:  txn.finalName,
-				duration: txn.duration,
-				queuing:  txn.queuing,
-				attrs:    txn.attrs,
+				klass:                   e.klass,
+				msg:                     e.msg,
+				when:                    e.when,
+				txnName:                 txn.finalName,
+				duration:                txn.duration,
+				queuing:                 txn.queuing,
+				attrs:                   txn.attrs,
+				expected:                e.expected,
+				userAttrs:               e.userAttrs,
+				cat:                     txn.catIntrinsics,
+				dt:                      dt,
 				datastoreExternalTotals: txn.tracer.datastoreExternalTotals,
 			})
 		}
 	}
 }
 
+// mergeSpanEvents adds the span event of the transaction itself and the span
+// events of its segments.
+func (txn *txn) mergeSpanEvents(events *spanEvents) {
+	root := &spanEvent{
+		guid:         txn.dt.rootSpanID,
+		parentID:     txn.dt.parentSpanID,
+		timestamp:    txn.start,
+		duration:     txn.duration,
+		name:         txn.finalName,
+		category:     spanCategoryGeneric,
+		isEntrypoint: true,
+	}
+	for _, e := range append([]*spanEvent{root}, txn.tracer.spanEvents...) {
+		e.traceID = txn.dt.traceID
+		e.txnID = txn.dt.txnID
+		e.sampled = txn.dt.sampled
+		e.priority = txn.dt.priority
+		events.AddSpanEvent(e)
+	}
+}
+
 func responseCodeIsError(cfg *api.Config, code int) bool {
 	if code < http.StatusBadRequest { // 400
 		return false
@@ -220,6 +401,37 @@ func responseCodeIsError(cfg *api.Config, code int) bool {
 	return true
 }
 
+func responseCodeIsExpected(cfg *api.Config, code int) bool {
+	for _, expectedCode := range cfg.ErrorCollector.ExpectedStatusCodes {
+		if code == expectedCode {
+			return true
+		}
+	}
+	return false
+}
+
+func stringInSlice(s string, slice []string) bool {
+	for _, x := range slice {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
+
+// errorIgnored indicates whether an error is dropped because of its class or
+// message.
+func errorIgnored(cfg *api.Config, e *txnError) bool {
+	if stringInSlice(e.klass, cfg.ErrorCollector.IgnoreClasses) {
+		return true
+	}
+	return stringInSlice(e.msg, cfg.ErrorCollector.IgnoreMessages[e.klass])
+}
+
+func errorExpected(cfg *api.Config, e *txnError) bool {
+	return e.expected || stringInSlice(e.klass, cfg.ErrorCollector.ExpectedClasses)
+}
+
 var (
 	// statusCodeLookup avoids a strconv.Itoa call.
 	statusCodeLookup = map[int]string{
@@ -260,14 +472,53 @@ func headersJustWritten(txn *txn, code int) {
 
 	if responseCodeIsError(&txn.Config, code) {
 		e := txnErrorFromResponseCode(code)
+		e.expected = responseCodeIsExpected(&txn.Config, code)
 		e.stack = getStackTrace(1)
 		txn.noticeErrorInternal(e)
 	}
 }
 
+// addAppDataHeader adds the X-NewRelic-App-Data header to the response of a
+// trusted cross application request.  It must be called before the response
+// headers are written.  Since the header contains the transaction name, the
+// name is frozen.
+func (txn *txn) addAppDataHeader() {
+	if txn.finished || txn.wroteHeader || !txn.cross.inbound || !txn.catEnabled() {
+		return
+	}
+	txn.freezeName()
+	if txn.ignore {
+		return
+	}
+	h := txn.W.Header()
+	contentLength := int64(-1)
+	if val := h.Get("Content-Length"); "" != val {
+		if x, err := strconv.ParseInt(val, 10, 64); nil == err {
+			contentLength = x
+		}
+	}
+	appData := &catAppData{
+		crossProcessID: txn.Reply.CrossProcessID,
+		txnName:        txn.finalName,
+		queuing:        txn.queuing,
+		response:       time.Since(txn.start),
+		contentLength:  contentLength,
+		guid:           txn.cross.getGUID(),
+	}
+	js, err := appData.MarshalJSON()
+	if nil != err {
+		return
+	}
+	h.Set(catAppDataHeader, catObfuscate(js, txn.Reply.EncodingKey))
+}
+
 func (txn *txn) Header() http.Header { return txn.W.Header() }
 
 func (txn *txn) Write(b []byte) (int, error) {
+	txn.Lock()
+	txn.addAppDataHeader()
+	txn.Unlock()
+
 	n, err := txn.W.Write(b)
 
 	txn.Lock()
@@ -279,6 +530,10 @@ func (txn *txn) Write(b []byte) (int, error) {
 }
 
 func (txn *txn) WriteHeader(code int) {
+	txn.Lock()
+	txn.addAppDataHeader()
+	txn.Unlock()
+
 	txn.W.WriteHeader(code)
 
 	txn.Lock()
@@ -313,7 +568,17 @@ func (txn *txn) End() error {
 	txn.stop = time.Now()
 	txn.duration = txn.stop.Sub(txn.start)
 
+	for _, t := range txn.asyncTracers {
+		mergeTracer(&txn.tracer, t)
+	}
+
 	txn.freezeName()
+	if !txn.ignore {
+		txn.catIntrinsics = txn.cross.intrinsics(txn.Config.AppName, txn.finalName)
+		if nil != txn.synthetics {
+			txn.synthetics.guid = txn.cross.getGUID()
+		}
+	}
 	if txn.getsApdex() {
 		txn.apdexThreshold = calculateApdexThreshold(txn.Reply, txn.finalName)
 		if txn.errorsSeen > 0 {
@@ -376,9 +641,17 @@ const (
 )
 
 func (txn *txn) noticeErrorInternal(err txnError) error {
+	if errorIgnored(&txn.Config, &err) {
+		return nil
+	}
+	err.expected = errorExpected(&txn.Config, &err)
+
 	// Increment errorsSeen even if errors are disabled:  Error metrics do
-	// not depend on whether or not errors are enabled.
-	txn.errorsSeen++
+	// not depend on whether or not errors are enabled.  Expected errors
+	// do not affect error metrics or apdex.
+	if !err.expected {
+		txn.errorsSeen++
+	}
 
 	if !txn.Config.ErrorCollector.Enabled {
 		return ErrorsLocallyDisabled
@@ -389,7 +662,7 @@ func (txn *txn) noticeErrorInternal(err txnError) error {
 	}
 
 	if nil == txn.errors {
-		txn.errors = newTxnErrors(maxTxnErrors)
+		txn.errors = newTxnErrors(configLimit(txn.Config.HarvestLimits.MaxTxnErrors, maxTxnErrors))
 	}
 
 	if txn.Config.HighSecurity {
@@ -416,7 +689,10 @@ func (txn *txn) NoticeError(err error) error {
 	}
 
 	e := txnErrorFromError(err)
-	e.stack = getStackTrace(2)
+	if nil == e.stack {
+		e.stack = getStackTrace(2)
+	}
+	e.userAttrs = errorUserAttributes(txn.attrs.config, err)
 	return txn.noticeErrorInternal(e)
 }
 
@@ -443,49 +719,170 @@ func (txn *txn) Ignore() error {
 	return nil
 }
 
+// browserMonitoringEnabled indicates whether the browser timing header and
+// footer are created.  The JavaScript loader is provided by the connect reply.
+func (txn *txn) browserMonitoringEnabled() bool {
+	return txn.Config.BrowserMonitoring.Enabled &&
+		"" != txn.Reply.AgentLoader
+}
+
+func (txn *txn) BrowserTimingHeader() template.HTML {
+	txn.Lock()
+	defer txn.Unlock()
+
+	if txn.finished || !txn.browserMonitoringEnabled() {
+		return ""
+	}
+	txn.browserHeaderCreated = true
+	return template.HTML(browserTimingHeader(txn.Reply))
+}
+
+func (txn *txn) BrowserTimingFooter() template.HTML {
+	txn.Lock()
+	defer txn.Unlock()
+
+	if txn.finished || !txn.browserMonitoringEnabled() || !txn.browserHeaderCreated {
+		return ""
+	}
+	// The footer contains the transaction name, so the name cannot be
+	// changed afterwards.
+	txn.freezeName()
+	if txn.ignore {
+		return ""
+	}
+	info := newBrowserInfo(txn.Reply, txn.Config.License, txn.finalName,
+		txn.queuing, time.Since(txn.start), txn.attrs)
+	return template.HTML(browserTimingFooter(info))
+}
+
 func (txn *txn) StartSegment() api.Token {
+	return txn.startSegment(&txn.tracer)
+}
+
+func (txn *txn) EndSegment(token api.Token, name string) {
+	txn.endSegment(&txn.tracer, token, name)
+}
+
+func (txn *txn) EndDatastore(token api.Token, s datastore.Segment) {
+	txn.endDatastore(&txn.tracer, token, s)
+}
+
+func (txn *txn) EndExternal(token api.Token, url string) {
+	txn.endExternal(&txn.tracer, token, url)
+}
+
+func (txn *txn) PrepareRequest(token api.Token, request *http.Request) {
+	txn.prepareRequest(&txn.tracer, token, request)
+}
+
+func (txn *txn) EndRequest(token api.Token, request *http.Request, response *http.Response) {
+	txn.endRequest(&txn.tracer, token, request, response)
+}
+
+// NewGoroutine returns a Transaction which has its own segment tracer.
+// Segments started using the returned Transaction are ended using the same
+// Transaction.
+func (txn *txn) NewGoroutine() api.Transaction {
+	t := &tracer{}
+
+	txn.Lock()
+	txn.initTracer(t)
+	if !txn.finished {
+		txn.asyncTracers = append(txn.asyncTracers, t)
+	}
+	txn.Unlock()
+
+	return asyncTxn{txn: txn, async: t}
+}
+
+func (txn *txn) startSegment(t *tracer) api.Token {
 	token := invalidToken
 	txn.Lock()
 	if !txn.finished {
-		token = startSegment(&txn.tracer, time.Now())
+		token = startSegment(t, time.Now())
 	}
 	txn.Unlock()
 	return token
 }
 
-func (txn *txn) EndSegment(token api.Token, name string) {
+func (txn *txn) endSegment(t *tracer, token api.Token, name string) {
 	txn.Lock()
 	if !txn.finished {
-		endBasicSegment(&txn.tracer, token, time.Now(), name)
+		endBasicSegment(t, token, time.Now(), name)
 	}
 	txn.Unlock()
 }
 
-func (txn *txn) EndDatastore(token api.Token, s datastore.Segment) {
+func (txn *txn) endDatastore(t *tracer, token api.Token, s datastore.Segment) {
 	txn.Lock()
 	defer txn.Unlock()
 
 	if txn.finished {
 		return
 	}
-	endDatastoreSegment(&txn.tracer, token, time.Now(), s)
+	endDatastoreSegment(t, token, time.Now(), s)
 }
 
-func (txn *txn) EndExternal(token api.Token, url string) {
+func (txn *txn) endExternal(t *tracer, token api.Token, url string) {
 	txn.Lock()
 	defer txn.Unlock()
 
 	if txn.finished {
 		return
 	}
-	endExternalSegment(&txn.tracer, token, time.Now(), hostFromExternalURL(url))
+	key := externalMetricKey{Host: hostFromExternalURL(url)}
+	endExternalSegment(t, token, time.Now(), key, safeURLFromString(url))
 }
 
-func (txn *txn) PrepareRequest(token api.Token, request *http.Request) {
+func (txn *txn) prepareRequest(t *tracer, token api.Token, request *http.Request) {
 	txn.Lock()
 	defer txn.Unlock()
 
-	// TODO: handle request CAT headers
+	if txn.finished || nil == request {
+		return
+	}
+
+	if nil != txn.synthetics {
+		if nil == request.Header {
+			request.Header = make(http.Header)
+		}
+		request.Header.Set(syntheticsHeader, txn.synthetics.header)
+	}
+
+	if txn.distributedTracingEnabled() {
+		parent, state := txn.dt.outboundHeaders(txn.Reply, spanIDForToken(t, token), time.Now())
+		if nil == request.Header {
+			request.Header = make(http.Header)
+		}
+		request.Header.Set(traceParentHeader, parent)
+		if "" != state {
+			request.Header.Set(traceStateHeader, state)
+		} else {
+			request.Header.Del(traceStateHeader)
+		}
+		return
+	}
+
+	if !txn.catEnabled() {
+		return
+	}
+
+	// The name is not frozen here since the transaction may be renamed
+	// after the external call.
+	name := txn.finalName
+	if "" == name {
+		name = CreateFullTxnName(txn.name, txn.Reply, txn.isWeb)
+	}
+	js, err := txn.cross.outboundPayload(txn.Config.AppName, name).MarshalJSON()
+	if nil != err {
+		return
+	}
+	if nil == request.Header {
+		request.Header = make(http.Header)
+	}
+	key := txn.Reply.EncodingKey
+	request.Header.Set(catIDHeader, catObfuscate([]byte(txn.Reply.CrossProcessID), key))
+	request.Header.Set(catTransactionHeader, catObfuscate(js, key))
 }
 
 func hostFromRequestResponse(request *http.Request, response *http.Response) string {
@@ -501,7 +898,17 @@ func hostFromRequestResponse(request *http.Request, response *http.Response) str
 	return request.URL.Host
 }
 
-func (txn *txn) EndRequest(token api.Token, request *http.Request, response *http.Response) {
+func cleanURLFromRequestResponse(request *http.Request, response *http.Response) string {
+	if nil != response && nil != response.Request {
+		request = response.Request
+	}
+	if nil == request || nil == request.URL {
+		return ""
+	}
+	return safeURL(request.URL)
+}
+
+func (txn *txn) endRequest(t *tracer, token api.Token, request *http.Request, response *http.Response) {
 	txn.Lock()
 	defer txn.Unlock()
 
@@ -509,8 +916,48 @@ func (txn *txn) EndRequest(token api.Token, request *http.Request, response *htt
 		return
 	}
 
-	// TODO: handle response CAT headers
+	key := externalMetricKey{Host: hostFromRequestResponse(request, response)}
+	if nil != response && txn.catEnabled() {
+		appData, err := catAppDataFromHeader(txn.Reply, response.Header.Get(catAppDataHeader))
+		if nil != err {
+			log.Debug("unable to process external response cross application tracing header", log.Context{
+				"err": err.Error(),
+			})
+		} else if nil != appData {
+			key.ExternalCrossProcessID = appData.crossProcessID
+			key.ExternalTransactionName = appData.txnName
+		}
+	}
+	endExternalSegment(t, token, time.Now(), key, cleanURLFromRequestResponse(request, response))
+}
+
+// asyncTxn is returned by NewGoroutine.  It shares all state with the original
+// transaction except for the segment tracer.
+type asyncTxn struct {
+	*txn
+	async *tracer
+}
+
+func (x asyncTxn) StartSegment() api.Token {
+	return x.txn.startSegment(x.async)
+}
+
+func (x asyncTxn) EndSegment(token api.Token, name string) {
+	x.txn.endSegment(x.async, token, name)
+}
+
+func (x asyncTxn) EndDatastore(token api.Token, s datastore.Segment) {
+	x.txn.endDatastore(x.async, token, s)
+}
+
+func (x asyncTxn) EndExternal(token api.Token, url string) {
+	x.txn.endExternal(x.async, token, url)
+}
+
+func (x asyncTxn) PrepareRequest(token api.Token, request *http.Request) {
+	x.txn.prepareRequest(x.async, token, request)
+}
 
-	host := hostFromRequestResponse(request, response)
-	endExternalSegment(&txn.tracer, token, time.Now(), host)
+func (x asyncTxn) EndRequest(token api.Token, request *http.Request, response *http.Response) {
+	x.txn.endRequest(x.async, token, request, response)
 }
@@ -17,6 +17,13 @@ type txnEvent struct {
 	queuing   time.Duration
 	zone      apdexZone
 	attrs     *attributes
+	// cat is nil unless the transaction was involved in cross
+	// application tracing.
+	cat *catIntrinsics
+	// synthetics is nil unless the transaction was a synthetics request.
+	synthetics *syntheticsInfo
+	// dt is nil unless distributed tracing is enabled.
+	dt *txnDistributedTrace
 	datastoreExternalTotals
 }
 
@@ -49,6 +56,15 @@ func (e *txnEvent) WriteJSON(buf *bytes.Buffer) {
 		buf.WriteString(`,"databaseDuration":`)
 		jsonx.AppendFloat(buf, e.datastoreDuration.Seconds())
 	}
+	if nil != e.cat {
+		e.cat.writeTxnEventFields(buf)
+	}
+	if nil != e.synthetics {
+		e.synthetics.writeTxnEventFields(buf, nil == e.cat)
+	}
+	if nil != e.dt {
+		e.dt.writeIntrinsics(buf)
+	}
 	buf.WriteByte('}')
 	buf.WriteByte(',')
 	userAttributesJSON(e.attrs, buf, destTxnEvent)
@@ -77,16 +93,27 @@ func newTxnEvents(max int) *txnEvents {
 
 func (events *txnEvents) AddTxnEvent(e *txnEvent) {
 	stamp := eventStamp(rand.Float32())
+	if nil != e.synthetics {
+		stamp = syntheticsEventStamp
+	}
 	events.events.AddEvent(analyticsEvent{stamp, e})
 }
 
 func (events *txnEvents) mergeIntoHarvest(h *harvest) {
-	h.txnEvents.events.MergeFailed(events.events)
+	h.txnEvents.events.MergeFailed(events.events, h.limits.failedEventAttempts)
 }
 
 func (events *txnEvents) Data(agentRunID string, harvestStart time.Time) ([]byte, error) {
 	return events.events.CollectorJSON(agentRunID)
 }
 
+func (events *txnEvents) split() (payloadCreator, payloadCreator) {
+	first, second := events.events.split()
+	if nil == first {
+		return nil, nil
+	}
+	return &txnEvents{events: first}, &txnEvents{events: second}
+}
+
 func (events *txnEvents) numSeen() float64  { return events.events.NumSeen() }
 func (events *txnEvents) numSaved() float64 { return events.events.NumSaved() }
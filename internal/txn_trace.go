@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/newrelic/go-agent/internal/jsonx"
+)
+
+// https://source.datanerd.us/agents/agent-specs/blob/master/Transaction-Trace-LEGACY.md
+
+type traceNodeParams struct {
+	CleanURL string
+	// Query must be obfuscated.
+	Query string
+}
+
+type segmentTime struct {
+	stamp uint64
+	when  time.Time
+}
+
+type traceNode struct {
+	start  segmentTime
+	stop   segmentTime
+	name   string
+	params traceNodeParams
+}
+
+// txnTrace records the segment nodes of a single tracer.  Since the
+// transaction's trace is not known to be the slowest until the end of the
+// harvest, nodes are recorded for every transaction when traces are enabled.
+type txnTrace struct {
+	enabled          bool
+	segmentThreshold time.Duration
+	maxNodes         int
+	nodes            []traceNode
+}
+
+// considerNode indicates whether or not a node will be recorded.  It exists so
+// that node names are only created when necessary.
+func (trace *txnTrace) considerNode(end segmentEnd) bool {
+	return trace.enabled &&
+		end.duration >= trace.segmentThreshold &&
+		len(trace.nodes) < trace.maxNodes
+}
+
+func (trace *txnTrace) witnessNode(end segmentEnd, name string, params traceNodeParams) {
+	if nil == trace.nodes {
+		trace.nodes = make([]traceNode, 0, startingTxnTraceNodes)
+	}
+	trace.nodes = append(trace.nodes, traceNode{
+		start:  segmentTime{stamp: end.startStamp, when: end.start},
+		stop:   segmentTime{stamp: end.stopStamp, when: end.stop},
+		name:   name,
+		params: params,
+	})
+}
+
+type byStartStamp []traceNode
+
+func (ns byStartStamp) Len() int           { return len(ns) }
+func (ns byStartStamp) Swap(i, j int)      { ns[i], ns[j] = ns[j], ns[i] }
+func (ns byStartStamp) Less(i, j int) bool { return ns[i].start.stamp < ns[j].start.stamp }
+
+type harvestTrace struct {
+	start     time.Time
+	duration  time.Duration
+	finalName string
+	cleanURL  string
+	trace     txnTrace
+	attrs     *attributes
+	cat       *catIntrinsics
+	// synthetics is nil unless the transaction was a synthetics request.
+	synthetics *syntheticsInfo
+}
+
+func durationToIntMilliseconds(d time.Duration) int64 {
+	// time.Seconds() is intentionally not used here: Millisecond precision
+	// is enough.
+	return d.Nanoseconds() / (1000 * 1000)
+}
+
+func writeNodeParams(buf *bytes.Buffer, params traceNodeParams) {
+	w := jsonFieldsWriter{buf: buf}
+	buf.WriteByte('{')
+	if "" != params.CleanURL {
+		w.stringField("uri", params.CleanURL)
+	}
+	if "" != params.Query {
+		w.stringField("query", params.Query)
+	}
+	buf.WriteByte('}')
+}
+
+// writeChildren writes the nodes beginning at index i which are children of a
+// segment that stopped at the stamp stop.  The nodes must be sorted by start
+// stamp.  The index of the first node which is not a child is returned.
+func writeChildren(buf *bytes.Buffer, nodes []traceNode, i int, stop uint64, start time.Time) int {
+	buf.WriteByte('[')
+	for first := true; i < len(nodes) && nodes[i].start.stamp < stop; first = false {
+		if !first {
+			buf.WriteByte(',')
+		}
+		n := nodes[i]
+		buf.WriteByte('[')
+		jsonx.AppendInt(buf, durationToIntMilliseconds(n.start.when.Sub(start)))
+		buf.WriteByte(',')
+		jsonx.AppendInt(buf, durationToIntMilliseconds(n.stop.when.Sub(start)))
+		buf.WriteByte(',')
+		jsonx.AppendString(buf, n.name)
+		buf.WriteByte(',')
+		writeNodeParams(buf, n.params)
+		buf.WriteByte(',')
+		i = writeChildren(buf, nodes, i+1, n.stop.stamp, start)
+		buf.WriteByte(']')
+	}
+	buf.WriteByte(']')
+	return i
+}
+
+func (trace *harvestTrace) writeJSON(buf *bytes.Buffer) {
+	nodes := make([]traceNode, len(trace.trace.nodes))
+	copy(nodes, trace.trace.nodes)
+	sort.Sort(byStartStamp(nodes))
+
+	durationMillis := durationToIntMilliseconds(trace.duration)
+
+	buf.WriteByte('[')
+	jsonx.AppendFloat(buf, timeToFloatMilliseconds(trace.start))
+	buf.WriteByte(',')
+	jsonx.AppendInt(buf, durationMillis)
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, trace.finalName)
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, trace.cleanURL)
+	buf.WriteByte(',')
+
+	buf.WriteByte('[')
+	buf.WriteString(`0,{},{},`)
+
+	// Root node.
+	buf.WriteString(`[0,`)
+	jsonx.AppendInt(buf, durationMillis)
+	buf.WriteString(`,"ROOT",{},[`)
+
+	// Transaction node.
+	buf.WriteString(`[0,`)
+	jsonx.AppendInt(buf, durationMillis)
+	buf.WriteByte(',')
+	jsonx.AppendString(buf, trace.finalName)
+	buf.WriteString(`,{},`)
+	writeChildren(buf, nodes, 0, math.MaxUint64, trace.start)
+	buf.WriteByte(']')
+
+	buf.WriteString(`]]`)
+	buf.WriteByte(',')
+
+	buf.WriteString(`{"agentAttributes":`)
+	agentAttributesJSON(trace.attrs, buf, destTxnTrace)
+	buf.WriteString(`,"userAttributes":`)
+	userAttributesJSON(trace.attrs, buf, destTxnTrace)
+	buf.WriteString(`,"intrinsics":{`)
+	w := jsonFieldsWriter{buf: buf}
+	trace.cat.writeTraceIntrinsics(&w)
+	trace.synthetics.writeTraceIntrinsics(&w)
+	buf.WriteByte('}')
+	buf.WriteByte('}')
+	buf.WriteByte(']')
+
+	buf.WriteByte(',')
+	// GUID is used for cross application tracing and synthetics.
+	if nil != trace.cat {
+		jsonx.AppendString(buf, trace.cat.guid)
+	} else if nil != trace.synthetics {
+		jsonx.AppendString(buf, trace.synthetics.guid)
+	} else {
+		jsonx.AppendString(buf, "")
+	}
+	// Reserved for future use, force persist, X-Ray session ID, and
+	// Synthetics resource ID.
+	if nil != trace.synthetics {
+		buf.WriteString(`,null,true,null,`)
+		jsonx.AppendString(buf, trace.synthetics.resourceID)
+	} else {
+		buf.WriteString(`,null,false,null,""`)
+	}
+	buf.WriteByte(']')
+}
+
+// MarshalJSON prepares the trace in the format expected by the collector.
+func (trace *harvestTrace) MarshalJSON() ([]byte, error) {
+	estimate := 100 * len(trace.trace.nodes)
+	buf := bytes.NewBuffer(make([]byte, 0, estimate))
+
+	trace.writeJSON(buf)
+
+	return buf.Bytes(), nil
+}
+
+// harvestTraces keeps the slowest transaction trace of a harvest.  The traces
+// of synthetics requests are also kept, up to maxSyntheticsTraces.
+type harvestTraces struct {
+	trace      *harvestTrace
+	synthetics []*harvestTrace
+}
+
+func newHarvestTraces() *harvestTraces {
+	return &harvestTraces{}
+}
+
+func (traces *harvestTraces) Witness(trace harvestTrace) {
+	if nil != trace.synthetics && len(traces.synthetics) < maxSyntheticsTraces {
+		cpy := new(harvestTrace)
+		*cpy = trace
+		traces.synthetics = append(traces.synthetics, cpy)
+		return
+	}
+	if nil != traces.trace && traces.trace.duration >= trace.duration {
+		return
+	}
+	cpy := new(harvestTrace)
+	*cpy = trace
+	traces.trace = cpy
+}
+
+// saved returns the slowest trace followed by the synthetics traces.
+func (traces *harvestTraces) saved() []*harvestTrace {
+	var saved []*harvestTrace
+	if nil != traces.trace {
+		saved = append(saved, traces.trace)
+	}
+	return append(saved, traces.synthetics...)
+}
+
+func (traces *harvestTraces) Data(agentRunID string, harvestStart time.Time) ([]byte, error) {
+	saved := traces.saved()
+	if 0 == len(saved) {
+		return nil, nil
+	}
+	estimate := 512
+	for _, trace := range saved {
+		estimate += 100 * len(trace.trace.nodes)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, estimate))
+
+	buf.WriteByte('[')
+	jsonx.AppendString(buf, agentRunID)
+	buf.WriteByte(',')
+	buf.WriteByte('[')
+	for i, trace := range saved {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		trace.writeJSON(buf)
+	}
+	buf.WriteByte(']')
+	buf.WriteByte(']')
+
+	return buf.Bytes(), nil
+}
+
+func (traces *harvestTraces) mergeIntoHarvest(h *harvest) {
+	for _, trace := range traces.saved() {
+		h.txnTraces.Witness(*trace)
+	}
+}
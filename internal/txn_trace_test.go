@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/api/datastore"
+)
+
+func TestTxnTraceNodeNesting(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	tr := &tracer{}
+	tr.txnTrace = txnTrace{enabled: true, maxNodes: 100}
+
+	t1 := startSegment(tr, start.Add(1*time.Second))
+	t2 := startSegment(tr, start.Add(2*time.Second))
+	endDatastoreSegment(tr, t2, start.Add(3*time.Second), datastore.Segment{
+		Product:    datastore.MySQL,
+		Collection: "my_table",
+		Operation:  "SELECT",
+		Query:      "SELECT * FROM my_table WHERE secret = 'zap'",
+	})
+	t3 := startSegment(tr, start.Add(4*time.Second))
+	endExternalSegment(tr, t3, start.Add(5*time.Second), externalMetricKey{Host: "example.com"}, "http://example.com/zip")
+	endBasicSegment(tr, t1, start.Add(6*time.Second), "t1")
+	t4 := startSegment(tr, start.Add(7*time.Second))
+	endBasicSegment(tr, t4, start.Add(8*time.Second), "t4")
+
+	attr := newAttributes(createAttributeConfig(sampleAttributeConfigInput))
+	attr.agent.RequestMethod = "GET"
+	addUserAttribute(attr, "zap", 123, destAll)
+
+	ht := harvestTrace{
+		start:     start,
+		duration:  9 * time.Second,
+		finalName: "WebTransaction/Go/hello",
+		cleanURL:  "/url",
+		trace:     tr.txnTrace,
+		attrs:     attr,
+	}
+	js, err := ht.MarshalJSON()
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect := compactJSONString(`
+	[
+		1.41713646e+12,9000,"WebTransaction/Go/hello","/url",
+		[
+			0,{},{},
+			[0,9000,"ROOT",{},[
+				[0,9000,"WebTransaction/Go/hello",{},[
+					[1000,6000,"Custom/t1",{},[
+						[2000,3000,"Datastore/statement/MySQL/my_table/SELECT",{"query":"SELECT * FROM my_table WHERE secret = ?"},[]],
+						[4000,5000,"External/example.com/all",{"uri":"http://example.com/zip"},[]]
+					]],
+					[7000,8000,"Custom/t4",{},[]]
+				]]
+			]],
+			{
+				"agentAttributes":{"request.method":"GET"},
+				"userAttributes":{"zap":123},
+				"intrinsics":{}
+			}
+		],
+		"",null,false,null,""
+	]`)
+	if string(js) != expect {
+		t.Error(string(js))
+	}
+}
+
+func TestTxnTraceSegmentThresholdAndLimit(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	tr := &tracer{}
+	tr.txnTrace = txnTrace{
+		enabled:          true,
+		segmentThreshold: 2 * time.Second,
+		maxNodes:         2,
+	}
+
+	t1 := startSegment(tr, start)
+	endBasicSegment(tr, t1, start.Add(1*time.Second), "short")
+	for i := 0; i < 3; i++ {
+		token := startSegment(tr, start)
+		endBasicSegment(tr, token, start.Add(3*time.Second), "long")
+	}
+	if len(tr.nodes) != 2 {
+		t.Fatal(len(tr.nodes))
+	}
+	for _, n := range tr.nodes {
+		if n.name != "Custom/long" {
+			t.Error(n.name)
+		}
+	}
+}
+
+func TestTxnTraceDisabled(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	tr := &tracer{}
+	tr.txnTrace = txnTrace{enabled: false, maxNodes: 100}
+	t1 := startSegment(tr, start)
+	endBasicSegment(tr, t1, start.Add(1*time.Second), "t1")
+	if nil != tr.nodes {
+		t.Error(tr.nodes)
+	}
+}
+
+func TestHarvestTracesKeepsSlowest(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	traces := newHarvestTraces()
+	if js, err := traces.Data("run", start); nil != js || nil != err {
+		t.Error(string(js), err)
+	}
+	traces.Witness(harvestTrace{start: start, duration: 2 * time.Second, finalName: "WebTransaction/Go/middle"})
+	traces.Witness(harvestTrace{start: start, duration: 3 * time.Second, finalName: "WebTransaction/Go/slowest"})
+	traces.Witness(harvestTrace{start: start, duration: 1 * time.Second, finalName: "WebTransaction/Go/fastest"})
+	if traces.trace.finalName != "WebTransaction/Go/slowest" {
+		t.Error(traces.trace.finalName)
+	}
+
+	h := newHarvest(start, defaultHarvestLimits)
+	h.txnTraces.Witness(harvestTrace{start: start, duration: 4 * time.Second, finalName: "WebTransaction/Go/next"})
+	traces.mergeIntoHarvest(h)
+	if h.txnTraces.trace.finalName != "WebTransaction/Go/next" {
+		t.Error(h.txnTraces.trace.finalName)
+	}
+
+	js, err := traces.Data("run", start)
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect := compactJSONString(`["run",[[
+		1.41713646e+12,3000,"WebTransaction/Go/slowest","",
+		[
+			0,{},{},
+			[0,3000,"ROOT",{},[[0,3000,"WebTransaction/Go/slowest",{},[]]]],
+			{"agentAttributes":{},"userAttributes":{},"intrinsics":{}}
+		],
+		"",null,false,null,""
+	]]]`)
+	if string(js) != expect {
+		t.Error(string(js))
+	}
+}
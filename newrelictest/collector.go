@@ -0,0 +1,326 @@
+// Package newrelictest provides a fake New Relic collector for local
+// development and tests.  The collector runs in-process, answers the commands
+// sent by an Application, and decodes their payloads so that tests can assert
+// on the data the agent sends over the wire:
+//
+//	collector := newrelictest.NewCollector()
+//	defer collector.Close()
+//
+//	cfg := newrelic.NewConfig("my app", "0123456789012345678901234567890123456789")
+//	collector.Configure(&cfg)
+//	app, err := newrelic.NewApplication(cfg)
+//	// record data, then shut down to send the final harvest
+//	app.Shutdown(10 * time.Second)
+//
+//	for _, m := range collector.Metrics() {
+//		// assert on m
+//	}
+package newrelictest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"github.com/newrelic/go-agent/api"
+)
+
+// Methods used in collector communication.
+const (
+	MethodRedirect     = "get_redirect_host"
+	MethodConnect      = "connect"
+	MethodMetrics      = "metric_data"
+	MethodCustomEvents = "custom_event_data"
+	MethodTxnEvents    = "analytic_event_data"
+	MethodErrorEvents  = "error_event_data"
+	MethodErrors       = "error_data"
+	MethodTxnTraces    = "transaction_sample_data"
+	MethodSlowQueries  = "sql_trace_data"
+	MethodSpanEvents   = "span_event_data"
+)
+
+// Request is a command received by the Collector.
+type Request struct {
+	Method  string
+	RunID   string
+	License string
+	// ContentEncoding is the compression format of the request body:
+	// "deflate" or "gzip".
+	ContentEncoding string
+	// Body is the uncompressed request body.
+	Body []byte
+	// StatusCode is the status code of the response.
+	StatusCode int
+}
+
+// EventHarvestConfig is the event harvest configuration of a ConnectReply.
+// Zero values are not sent.
+type EventHarvestConfig struct {
+	ReportPeriodMs int `json:"report_period_ms,omitempty"`
+	HarvestLimits  struct {
+		TxnEvents    int `json:"analytic_event_data,omitempty"`
+		CustomEvents int `json:"custom_event_data,omitempty"`
+		ErrorEvents  int `json:"error_event_data,omitempty"`
+	} `json:"harvest_limits"`
+}
+
+// ConnectReply is the reply to the connect command.  Use DefaultConnectReply
+// to create a ConnectReply with the values sent by the New Relic collector.
+type ConnectReply struct {
+	RunID string `json:"agent_run_id"`
+
+	// Cross Process
+	EncodingKey     string `json:"encoding_key,omitempty"`
+	CrossProcessID  string `json:"cross_process_id,omitempty"`
+	TrustedAccounts []int  `json:"trusted_account_ids,omitempty"`
+
+	// Distributed Tracing
+	AccountID         string `json:"account_id,omitempty"`
+	TrustedAccountKey string `json:"trusted_account_key,omitempty"`
+	PrimaryAppID      string `json:"primary_application_id,omitempty"`
+
+	// Settings
+	KeyTxnApdex            map[string]float64 `json:"web_transactions_apdex,omitempty"`
+	ApdexThresholdSeconds  float64            `json:"apdex_t"`
+	CollectAnalyticsEvents bool               `json:"collect_analytics_events"`
+	CollectCustomEvents    bool               `json:"collect_custom_events"`
+	CollectTraces          bool               `json:"collect_traces"`
+	CollectErrors          bool               `json:"collect_errors"`
+	CollectErrorEvents     bool               `json:"collect_error_events"`
+	CollectSpanEvents      bool               `json:"collect_span_events"`
+
+	EventHarvestConfig *EventHarvestConfig `json:"event_harvest_config,omitempty"`
+
+	// RUM
+	AgentLoader string `json:"js_agent_loader,omitempty"`
+	Beacon      string `json:"beacon,omitempty"`
+	BrowserKey  string `json:"browser_key,omitempty"`
+	AppID       string `json:"application_id,omitempty"`
+	ErrorBeacon string `json:"error_beacon,omitempty"`
+	JSAgentFile string `json:"js_agent_file,omitempty"`
+
+	// Extra contains additional fields of the reply, such as
+	// metric_name_rules.  Extra fields replace the fields above.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// DefaultConnectReply returns a ConnectReply which enables all data
+// collection.
+func DefaultConnectReply() ConnectReply {
+	return ConnectReply{
+		RunID:                  "newrelictest_run_id",
+		ApdexThresholdSeconds:  0.5,
+		CollectAnalyticsEvents: true,
+		CollectCustomEvents:    true,
+		CollectTraces:          true,
+		CollectErrors:          true,
+		CollectErrorEvents:     true,
+		CollectSpanEvents:      true,
+	}
+}
+
+func (reply ConnectReply) marshal() ([]byte, error) {
+	type plain ConnectReply
+	js, err := json.Marshal(plain(reply))
+	if nil != err || 0 == len(reply.Extra) {
+		return js, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(js, &fields); nil != err {
+		return nil, err
+	}
+	for k, v := range reply.Extra {
+		fields[k] = v
+	}
+	return json.Marshal(fields)
+}
+
+// Collector is a fake collector server.  It records every request it
+// receives.  Data commands are accepted unless a status code has been set
+// using SetStatusCode.  Collector methods are safe to use concurrently.
+type Collector struct {
+	server *httptest.Server
+	url    *url.URL
+
+	sync.Mutex
+	reply       ConnectReply
+	statusCodes map[string]int
+	requests    []Request
+}
+
+// NewCollector starts a Collector which replies to connect with
+// DefaultConnectReply.  Close should be called when finished.
+func NewCollector() *Collector {
+	c := &Collector{
+		reply:       DefaultConnectReply(),
+		statusCodes: make(map[string]int),
+	}
+	c.server = httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	c.url, _ = url.Parse(c.server.URL)
+	return c
+}
+
+// Close shuts down the Collector.
+func (c *Collector) Close() {
+	c.server.Close()
+}
+
+// URL returns the base URL of the Collector, of the form http://ipaddr:port.
+func (c *Collector) URL() string {
+	return c.server.URL
+}
+
+// Transport returns an http.RoundTripper which sends all requests to the
+// Collector, regardless of their host.
+func (c *Collector) Transport() http.RoundTripper {
+	return collectorTransport{url: c.url}
+}
+
+// Configure sets the Transport of the configuration given so that the
+// Application communicates with the Collector.  Cloud provider detection is
+// disabled since it makes requests to other hosts.
+func (c *Collector) Configure(cfg *api.Config) {
+	cfg.Transport = c.Transport()
+	cfg.Utilization.DetectAWS = false
+}
+
+// SetConnectReply sets the reply to subsequent connect commands.
+func (c *Collector) SetConnectReply(reply ConnectReply) {
+	c.Lock()
+	defer c.Unlock()
+	c.reply = reply
+}
+
+// SetStatusCode sets the status code of the responses to the method given.
+// The reply to a method with a status code other than 200 has an empty body.
+// A status code of zero restores the default behavior.
+func (c *Collector) SetStatusCode(method string, code int) {
+	c.Lock()
+	defer c.Unlock()
+	if 0 == code {
+		delete(c.statusCodes, method)
+	} else {
+		c.statusCodes[method] = code
+	}
+}
+
+// Requests returns the requests received for the method given, or all
+// requests if method is empty, in the order they were received.
+func (c *Collector) Requests(method string) []Request {
+	c.Lock()
+	defer c.Unlock()
+	var requests []Request
+	for _, r := range c.requests {
+		if "" == method || r.Method == method {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// Reset discards the requests received.
+func (c *Collector) Reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.requests = nil
+}
+
+type collectorTransport struct {
+	url *url.URL
+}
+
+func (tr collectorTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// The request is copied since a RoundTripper must not modify it.
+	cpy := new(http.Request)
+	*cpy = *r
+	u := *r.URL
+	u.Scheme = tr.url.Scheme
+	u.Host = tr.url.Host
+	cpy.URL = &u
+	return http.DefaultTransport.RoundTrip(cpy)
+}
+
+func uncompressBody(body []byte, encoding string) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	case "", "identity":
+		return body, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	if nil != err {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func writeReturnValue(w http.ResponseWriter, value []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"return_value":`))
+	w.Write(value)
+	w.Write([]byte(`}`))
+}
+
+func (c *Collector) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := Request{
+		Method:          query.Get("method"),
+		RunID:           query.Get("run_id"),
+		License:         query.Get("license_key"),
+		ContentEncoding: r.Header.Get("Content-Encoding"),
+		StatusCode:      http.StatusOK,
+	}
+
+	compressed, err := ioutil.ReadAll(r.Body)
+	if nil == err {
+		req.Body, err = uncompressBody(compressed, req.ContentEncoding)
+	}
+	if nil == err {
+		var payload json.RawMessage
+		err = json.Unmarshal(req.Body, &payload)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if code, ok := c.statusCodes[req.Method]; ok {
+		req.StatusCode = code
+	} else if nil != err {
+		req.StatusCode = http.StatusBadRequest
+	}
+	c.requests = append(c.requests, req)
+
+	if http.StatusOK != req.StatusCode {
+		w.WriteHeader(req.StatusCode)
+		return
+	}
+
+	switch req.Method {
+	case MethodRedirect:
+		host, _ := json.Marshal(c.url.Host)
+		writeReturnValue(w, host)
+	case MethodConnect:
+		reply, err := c.reply.marshal()
+		if nil != err {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeReturnValue(w, reply)
+	default:
+		writeReturnValue(w, []byte(`null`))
+	}
+}
@@ -0,0 +1,256 @@
+package newrelictest
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/api"
+	"github.com/newrelic/go-agent/api/datastore"
+	"github.com/newrelic/go-agent/internal"
+)
+
+func testApp(t *testing.T, c *Collector, cfgfn func(*api.Config)) api.Application {
+	cfg := api.NewConfig("my app", "0123456789012345678901234567890123456789")
+	cfg.Utilization.DetectDocker = false
+	cfg.RuntimeSampler.Enabled = false
+	c.Configure(&cfg)
+	if nil != cfgfn {
+		cfgfn(&cfg)
+	}
+	app, err := internal.NewAppInternal(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+	waiter := app.(interface {
+		WaitForConnection(time.Duration) error
+	})
+	if err := waiter.WaitForConnection(5 * time.Second); nil != err {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func findMetric(metrics []Metric, name, scope string) *Metric {
+	for i := range metrics {
+		if metrics[i].Name == name && metrics[i].Scope == scope {
+			return &metrics[i]
+		}
+	}
+	return nil
+}
+
+func TestCollectorPayloads(t *testing.T) {
+	c := NewCollector()
+	defer c.Close()
+
+	app := testApp(t, c, func(cfg *api.Config) {
+		cfg.Labels["zip"] = "zap"
+		cfg.DistributedTracer.Enabled = true
+		cfg.SpanEvents.Enabled = true
+		cfg.TransactionTracer.Threshold.IsApdexFailing = false
+		cfg.TransactionTracer.Threshold.Duration = 0
+		cfg.TransactionTracer.SegmentThreshold = 0
+		cfg.DatastoreTracer.SlowQuery.Threshold = 0
+	})
+
+	if err := app.RecordCustomEvent("myType", map[string]interface{}{"zip": "zap"}); nil != err {
+		t.Fatal(err)
+	}
+	if err := app.RecordCustomMetric("myMetric", 2); nil != err {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", "http://example.com/hello", nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	txn := app.StartTransaction("hello", nil, req)
+	txn.AddAttribute("color", "red")
+	txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+		Product:    datastore.MySQL,
+		Collection: "users",
+		Operation:  "SELECT",
+		Query:      "SELECT * FROM users WHERE id = 1",
+	})
+	txn.NoticeError(errors.New("oops"))
+	txn.End()
+	app.Shutdown(10 * time.Second)
+
+	connects := c.Connects()
+	if len(connects) != 1 || connects[0].Language != "go" ||
+		len(connects[0].AppName) != 1 || connects[0].AppName[0] != "my app" ||
+		len(connects[0].Labels) != 1 || connects[0].Labels[0] != (Label{"zip", "zap"}) {
+		t.Error(connects)
+	}
+	for _, r := range c.Requests("") {
+		if r.Method != MethodRedirect && r.Method != MethodConnect &&
+			r.RunID != DefaultConnectReply().RunID {
+			t.Error(r.Method, r.RunID)
+		}
+		if r.ContentEncoding != "deflate" {
+			t.Error(r.Method, r.ContentEncoding)
+		}
+	}
+
+	metrics := c.Metrics()
+	if m := findMetric(metrics, "Custom/myMetric", ""); nil == m || m.Count != 1 || m.Total != 2 {
+		t.Error(m)
+	}
+	if m := findMetric(metrics, "WebTransaction/Go/hello", ""); nil == m || m.Count != 1 {
+		t.Error(m)
+	}
+	if m := findMetric(metrics, "Datastore/statement/MySQL/users/SELECT", "WebTransaction/Go/hello"); nil == m {
+		t.Error("missing scoped datastore metric")
+	}
+
+	if events := c.CustomEvents(); len(events) != 1 ||
+		events[0].Intrinsics["type"] != "myType" ||
+		events[0].UserAttributes["zip"] != "zap" {
+		t.Error(events)
+	}
+	if events := c.TxnEvents(); len(events) != 1 ||
+		events[0].Intrinsics["name"] != "WebTransaction/Go/hello" ||
+		events[0].UserAttributes["color"] != "red" {
+		t.Error(events)
+	}
+	if events := c.ErrorEvents(); len(events) != 1 ||
+		events[0].Intrinsics["error.message"] != "oops" {
+		t.Error(events)
+	}
+	if events := c.SpanEvents(); len(events) != 2 {
+		t.Error(events)
+	}
+
+	if errs := c.Errors(); len(errs) != 1 ||
+		errs[0].TxnName != "WebTransaction/Go/hello" ||
+		errs[0].Message != "oops" ||
+		errs[0].Class != "*errors.errorString" ||
+		errs[0].UserAttributes["color"] != "red" ||
+		0 == len(errs[0].Stack) {
+		t.Error(errs)
+	}
+
+	traces := c.TxnTraces()
+	if len(traces) != 1 || traces[0].Name != "WebTransaction/Go/hello" ||
+		traces[0].Root.Name != "ROOT" || len(traces[0].Root.Children) != 1 {
+		t.Fatal(traces)
+	}
+	txnNode := traces[0].Root.Children[0]
+	if txnNode.Name != "WebTransaction/Go/hello" || len(txnNode.Children) != 1 ||
+		txnNode.Children[0].Name != "Datastore/statement/MySQL/users/SELECT" ||
+		txnNode.Children[0].Params["query"] != "SELECT * FROM users WHERE id = ?" {
+		t.Error(txnNode)
+	}
+	if traces[0].UserAttributes["color"] != "red" {
+		t.Error(traces[0].UserAttributes)
+	}
+
+	slows := c.SlowQueries()
+	if len(slows) != 1 || slows[0].TxnName != "WebTransaction/Go/hello" ||
+		slows[0].MetricName != "Datastore/statement/MySQL/users/SELECT" ||
+		slows[0].Query != "SELECT * FROM users WHERE id = ?" ||
+		slows[0].Count != 1 || nil == slows[0].Params["backtrace"] {
+		t.Error(slows)
+	}
+}
+
+func TestCollectorConnectReply(t *testing.T) {
+	c := NewCollector()
+	defer c.Close()
+
+	reply := DefaultConnectReply()
+	reply.RunID = "my_run_id"
+	reply.CollectCustomEvents = false
+	reply.Extra = map[string]interface{}{
+		"metric_name_rules": []map[string]interface{}{{
+			"match_expression": "myMetric",
+			"replacement":      "renamed",
+		}},
+	}
+	c.SetConnectReply(reply)
+
+	app := testApp(t, c, func(cfg *api.Config) {
+		cfg.Compression.Format = api.CompressionGzip
+	})
+	if err := app.RecordCustomEvent("myType", nil); err != internal.ErrCustomEventsRemoteDisabled {
+		t.Error(err)
+	}
+	if err := app.RecordCustomMetric("myMetric", 1); nil != err {
+		t.Fatal(err)
+	}
+	app.Shutdown(10 * time.Second)
+
+	requests := c.Requests(MethodMetrics)
+	if len(requests) != 1 || requests[0].RunID != "my_run_id" ||
+		requests[0].ContentEncoding != "gzip" {
+		t.Fatal(requests)
+	}
+	metrics := c.Metrics()
+	if nil != findMetric(metrics, "Custom/myMetric", "") ||
+		nil == findMetric(metrics, "Custom/renamed", "") {
+		t.Error(metrics)
+	}
+
+	c.Reset()
+	if requests := c.Requests(""); 0 != len(requests) {
+		t.Error(requests)
+	}
+}
+
+func TestCollectorStatusCode(t *testing.T) {
+	c := NewCollector()
+	defer c.Close()
+
+	c.SetStatusCode(MethodMetrics, http.StatusBadRequest)
+	app := testApp(t, c, nil)
+	if err := app.RecordCustomMetric("myMetric", 1); nil != err {
+		t.Fatal(err)
+	}
+	app.Shutdown(10 * time.Second)
+
+	requests := c.Requests(MethodMetrics)
+	if len(requests) != 1 || requests[0].StatusCode != http.StatusBadRequest ||
+		0 == len(requests[0].Body) {
+		t.Fatal(requests)
+	}
+	// Rejected payloads are not decoded.
+	if metrics := c.Metrics(); 0 != len(metrics) {
+		t.Error(metrics)
+	}
+
+	c.SetStatusCode(MethodMetrics, 0)
+	if _, err := http.Post(c.URL()+"/agent_listener/invoke_raw_method?method=metric_data", "application/json", nil); nil != err {
+		t.Fatal(err)
+	}
+	// The empty body is not valid JSON.
+	if requests := c.Requests(MethodMetrics); len(requests) != 2 ||
+		requests[1].StatusCode != http.StatusBadRequest {
+		t.Error(requests)
+	}
+}
+
+func TestCollectorHighSecurityQuery(t *testing.T) {
+	c := NewCollector()
+	defer c.Close()
+
+	app := testApp(t, c, func(cfg *api.Config) {
+		cfg.HighSecurity = true
+		cfg.DatastoreTracer.SlowQuery.Threshold = 0
+	})
+	txn := app.StartTransaction("hello", nil, nil)
+	txn.EndDatastore(txn.StartSegment(), datastore.Segment{
+		Product: datastore.MySQL,
+		Query:   "SELECT * FROM users WHERE name = 'secret'",
+	})
+	txn.End()
+	app.Shutdown(10 * time.Second)
+
+	if connects := c.Connects(); len(connects) != 1 || !connects[0].HighSecurity {
+		t.Error(connects)
+	}
+	slows := c.SlowQueries()
+	if len(slows) != 1 || slows[0].Query != "SELECT * FROM users WHERE name = ?" {
+		t.Error(slows)
+	}
+}
@@ -0,0 +1,337 @@
+package newrelictest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+// The accessors below decode the payloads of the requests accepted by the
+// Collector, in the order they were received.  Payloads which cannot be
+// decoded are skipped:  Use Requests to inspect them.
+
+// decodeTuple decodes a JSON array into the destinations given, one per
+// element.  Missing elements are ignored.
+func decodeTuple(data []byte, dests ...interface{}) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); nil != err {
+		return err
+	}
+	for i, f := range fields {
+		if i >= len(dests) {
+			break
+		}
+		if err := json.Unmarshal(f, dests[i]); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Collector) accepted(method string) []Request {
+	var accepted []Request
+	for _, r := range c.Requests(method) {
+		if http.StatusOK == r.StatusCode {
+			accepted = append(accepted, r)
+		}
+	}
+	return accepted
+}
+
+// Label is a label of a Connect.
+type Label struct {
+	Type  string `json:"label_type"`
+	Value string `json:"label_value"`
+}
+
+// Connect is the payload of a connect command.
+type Connect struct {
+	PID          int                    `json:"pid"`
+	Language     string                 `json:"language"`
+	AgentVersion string                 `json:"agent_version"`
+	Host         string                 `json:"host"`
+	DisplayHost  string                 `json:"display_host"`
+	AppName      []string               `json:"app_name"`
+	HighSecurity bool                   `json:"high_security"`
+	Labels       []Label                `json:"labels"`
+	Identifier   string                 `json:"identifier"`
+	Settings     map[string]interface{} `json:"settings"`
+	Utilization  map[string]interface{} `json:"utilization"`
+}
+
+// Connects returns the payloads of the connect commands received.
+func (c *Collector) Connects() []Connect {
+	var connects []Connect
+	for _, r := range c.accepted(MethodConnect) {
+		var connect Connect
+		if nil == decodeTuple(r.Body, &connect) {
+			connects = append(connects, connect)
+		}
+	}
+	return connects
+}
+
+// Metric is a metric of a metric_data payload.  Times are in seconds.
+type Metric struct {
+	Name       string
+	Scope      string
+	Count      float64
+	Total      float64
+	Exclusive  float64
+	Min        float64
+	Max        float64
+	SumSquares float64
+}
+
+// Metrics returns the metrics of the metric_data commands received.  Metrics
+// which appear in several payloads are not combined.
+func (c *Collector) Metrics() []Metric {
+	var metrics []Metric
+	for _, r := range c.accepted(MethodMetrics) {
+		var runID string
+		var start, end float64
+		var entries []json.RawMessage
+		if nil != decodeTuple(r.Body, &runID, &start, &end, &entries) {
+			continue
+		}
+		for _, e := range entries {
+			var id struct {
+				Name  string `json:"name"`
+				Scope string `json:"scope"`
+			}
+			var data [6]float64
+			if nil != decodeTuple(e, &id, &data) {
+				continue
+			}
+			metrics = append(metrics, Metric{
+				Name:       id.Name,
+				Scope:      id.Scope,
+				Count:      data[0],
+				Total:      data[1],
+				Exclusive:  data[2],
+				Min:        data[3],
+				Max:        data[4],
+				SumSquares: data[5],
+			})
+		}
+	}
+	return metrics
+}
+
+// Event is an event of an analytic_event_data, custom_event_data,
+// error_event_data, or span_event_data payload.
+type Event struct {
+	Intrinsics      map[string]interface{}
+	UserAttributes  map[string]interface{}
+	AgentAttributes map[string]interface{}
+}
+
+func (c *Collector) events(method string) []Event {
+	var events []Event
+	for _, r := range c.accepted(method) {
+		var runID string
+		var info map[string]interface{}
+		var entries []json.RawMessage
+		if nil != decodeTuple(r.Body, &runID, &info, &entries) {
+			continue
+		}
+		for _, e := range entries {
+			var event Event
+			if nil == decodeTuple(e, &event.Intrinsics, &event.UserAttributes, &event.AgentAttributes) {
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
+
+// TxnEvents returns the transaction events received.
+func (c *Collector) TxnEvents() []Event { return c.events(MethodTxnEvents) }
+
+// CustomEvents returns the custom events received.
+func (c *Collector) CustomEvents() []Event { return c.events(MethodCustomEvents) }
+
+// ErrorEvents returns the error events received.
+func (c *Collector) ErrorEvents() []Event { return c.events(MethodErrorEvents) }
+
+// SpanEvents returns the span events received.
+func (c *Collector) SpanEvents() []Event { return c.events(MethodSpanEvents) }
+
+// TracedError is an error of an error_data payload.
+type TracedError struct {
+	// Timestamp is in milliseconds since the Unix epoch.
+	Timestamp       float64
+	TxnName         string
+	Message         string
+	Class           string
+	Stack           []interface{}
+	RequestURI      string
+	Intrinsics      map[string]interface{}
+	UserAttributes  map[string]interface{}
+	AgentAttributes map[string]interface{}
+}
+
+// Errors returns the traced errors received.
+func (c *Collector) Errors() []TracedError {
+	var errs []TracedError
+	for _, r := range c.accepted(MethodErrors) {
+		var runID string
+		var entries []json.RawMessage
+		if nil != decodeTuple(r.Body, &runID, &entries) {
+			continue
+		}
+		for _, e := range entries {
+			var te TracedError
+			var params struct {
+				Stack      []interface{}          `json:"stack_trace"`
+				Agent      map[string]interface{} `json:"agentAttributes"`
+				User       map[string]interface{} `json:"userAttributes"`
+				Intrinsics map[string]interface{} `json:"intrinsics"`
+				RequestURI string                 `json:"request_uri"`
+			}
+			if nil != decodeTuple(e, &te.Timestamp, &te.TxnName, &te.Message, &te.Class, &params) {
+				continue
+			}
+			te.Stack = params.Stack
+			te.RequestURI = params.RequestURI
+			te.Intrinsics = params.Intrinsics
+			te.UserAttributes = params.User
+			te.AgentAttributes = params.Agent
+			errs = append(errs, te)
+		}
+	}
+	return errs
+}
+
+// TraceNode is a segment of a transaction trace.  Times are in milliseconds
+// since the start of the transaction.
+type TraceNode struct {
+	Start    float64
+	End      float64
+	Name     string
+	Params   map[string]interface{}
+	Children []TraceNode
+}
+
+func decodeTraceNode(data []byte) (TraceNode, error) {
+	var n TraceNode
+	var children []json.RawMessage
+	if err := decodeTuple(data, &n.Start, &n.End, &n.Name, &n.Params, &children); nil != err {
+		return n, err
+	}
+	for _, child := range children {
+		cn, err := decodeTraceNode(child)
+		if nil != err {
+			return n, err
+		}
+		n.Children = append(n.Children, cn)
+	}
+	return n, nil
+}
+
+// TxnTrace is a transaction trace of a transaction_sample_data payload.
+type TxnTrace struct {
+	// Start is in milliseconds since the Unix epoch.
+	Start float64
+	// Duration is in milliseconds.
+	Duration float64
+	Name     string
+	URI      string
+	// Root is the root node of the trace.  Its only child is the
+	// transaction node.
+	Root                 TraceNode
+	Intrinsics           map[string]interface{}
+	UserAttributes       map[string]interface{}
+	AgentAttributes      map[string]interface{}
+	GUID                 string
+	ForcePersist         bool
+	SyntheticsResourceID string
+}
+
+// TxnTraces returns the transaction traces received.
+func (c *Collector) TxnTraces() []TxnTrace {
+	var traces []TxnTrace
+	for _, r := range c.accepted(MethodTxnTraces) {
+		var runID string
+		var entries []json.RawMessage
+		if nil != decodeTuple(r.Body, &runID, &entries) {
+			continue
+		}
+		for _, e := range entries {
+			var tt TxnTrace
+			var data json.RawMessage
+			var reserved, xraySessionID interface{}
+			if nil != decodeTuple(e, &tt.Start, &tt.Duration, &tt.Name, &tt.URI, &data,
+				&tt.GUID, &reserved, &tt.ForcePersist, &xraySessionID, &tt.SyntheticsResourceID) {
+				continue
+			}
+			var start float64
+			var unused1, unused2 map[string]interface{}
+			var root json.RawMessage
+			var attrs struct {
+				Agent      map[string]interface{} `json:"agentAttributes"`
+				User       map[string]interface{} `json:"userAttributes"`
+				Intrinsics map[string]interface{} `json:"intrinsics"`
+			}
+			if nil != decodeTuple(data, &start, &unused1, &unused2, &root, &attrs) {
+				continue
+			}
+			var err error
+			if tt.Root, err = decodeTraceNode(root); nil != err {
+				continue
+			}
+			tt.Intrinsics = attrs.Intrinsics
+			tt.UserAttributes = attrs.User
+			tt.AgentAttributes = attrs.Agent
+			traces = append(traces, tt)
+		}
+	}
+	return traces
+}
+
+// SlowQuery is a slow query trace of a sql_trace_data payload.  Times are in
+// milliseconds.
+type SlowQuery struct {
+	TxnName    string
+	TxnURL     string
+	ID         int64
+	Query      string
+	MetricName string
+	Count      int64
+	Total      float64
+	Min        float64
+	Max        float64
+	// Params are the decoded parameters of the query, such as its
+	// backtrace.
+	Params map[string]interface{}
+}
+
+// SlowQueries returns the slow query traces received.
+func (c *Collector) SlowQueries() []SlowQuery {
+	var slows []SlowQuery
+	for _, r := range c.accepted(MethodSlowQueries) {
+		// The sql_trace_data payload does not contain the run id.
+		var entries []json.RawMessage
+		if nil != decodeTuple(r.Body, &entries) {
+			continue
+		}
+		for _, e := range entries {
+			var sq SlowQuery
+			var encoded string
+			if nil != decodeTuple(e, &sq.TxnName, &sq.TxnURL, &sq.ID, &sq.Query,
+				&sq.MetricName, &sq.Count, &sq.Total, &sq.Min, &sq.Max, &encoded) {
+				continue
+			}
+			compressed, err := base64.StdEncoding.DecodeString(encoded)
+			if nil != err {
+				continue
+			}
+			params, err := uncompressBody(compressed, "deflate")
+			if nil != err || nil != json.Unmarshal(params, &sq.Params) {
+				continue
+			}
+			slows = append(slows, sq)
+		}
+	}
+	return slows
+}